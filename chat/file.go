@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package chat
+
+import (
+	"fmt"
+
+	"github.com/jumonmd/gengo/chat"
+)
+
+// fileProviders are the providers which accept the file content parts such as PDF and audio.
+// gengo sends the image parts to Gemini as the inline data of their own MIME type,
+// so the file parts are sent as the image parts. The other providers accept only the images.
+var fileProviders = map[string]bool{
+	"gemini": true,
+}
+
+// convertFileParts converts the file content parts of the request to the parts which the provider of the model sends.
+// It returns an error if the provider does not accept the files, not to drop them silently.
+func convertFileParts(req *chat.Request) error {
+	m := chat.NewOptions().ModelCatalog.GetModel(req.Model)
+	for i, msg := range req.Messages {
+		for j, part := range msg.Content {
+			if part.Type != "file" {
+				continue
+			}
+			if m == nil || !fileProviders[m.Provider] {
+				mimetype, _, _ := chat.SplitDataURL(part.DataURL)
+				return fmt.Errorf("model %s does not accept %s files: use a gemini model for PDF and audio files", req.Model, mimetype)
+			}
+			req.Messages[i].Content[j].Type = "image"
+		}
+	}
+	return nil
+}
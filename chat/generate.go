@@ -7,12 +7,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
 
 	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/internal/errors"
 	"github.com/jumonmd/jumon/internal/tracer"
 	"github.com/nats-io/nats.go"
 )
 
+type contextKey string
+
+const contextKeyMaxCheckRetries contextKey = "max-check-retries"
+
+// WithMaxCheckRetries returns the context with the maximum number of regenerations when the checks fail.
+func WithMaxCheckRetries(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, contextKeyMaxCheckRetries, n)
+}
+
 // Generate chat response using NATS service.
 func Generate(ctx context.Context, nc *nats.Conn, req *chat.Request, opts ...chat.Option) (*chat.Response, error) {
 	chatdata, err := json.Marshal(req)
@@ -23,10 +34,10 @@ func Generate(ctx context.Context, nc *nats.Conn, req *chat.Request, opts ...cha
 	opt := chat.NewOptions(opts...)
 
 	headers := tracer.HeadersFromContext(ctx)
-	if streamto, ok := ctx.Value("stream-to").(string); ok {
-		headers.Set("stream-to", streamto)
-	}
 	headers.Set("baseurl", opt.BaseURL)
+	if n, ok := ctx.Value(contextKeyMaxCheckRetries).(int); ok {
+		headers.Set("max-check-retries", strconv.Itoa(n))
+	}
 
 	resp, err := nc.RequestMsgWithContext(ctx, &nats.Msg{
 		Subject: "chat.generate",
@@ -36,9 +47,8 @@ func Generate(ctx context.Context, nc *nats.Conn, req *chat.Request, opts ...cha
 	if err != nil {
 		return nil, fmt.Errorf("nats: %w", err)
 	}
-	if errorCode := resp.Header.Get("Nats-Service-Error-Code"); errorCode != "" {
-		errorMessage := resp.Header.Get("Nats-Service-Error")
-		return nil, fmt.Errorf("%s: %s", errorCode, errorMessage)
+	if err := errors.FromHeaders(resp.Header); err != nil {
+		return nil, err
 	}
 
 	chatresp := &chat.Response{}
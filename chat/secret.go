@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package chat
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/jumonmd/jumon/internal/secret"
+	"github.com/nats-io/nats.go"
+)
+
+// providerKeyEnvs are the environment variables of the provider API keys which gengo reads.
+var providerKeyEnvs = map[string]string{
+	"openai":    "OPENAI_API_KEY",
+	"anthropic": "ANTHROPIC_API_KEY",
+	"gemini":    "GOOGLE_API_KEY",
+}
+
+// providerKeyHeaders are the request headers of the provider API keys which can be replaced by the secrets.
+// Gemini is not included because its client requires the API key in the environment.
+var providerKeyHeaders = map[string]func(key string) (string, string){
+	"openai":    func(key string) (string, string) { return "Authorization", "Bearer " + key },
+	"anthropic": func(key string) (string, string) { return "X-Api-Key", key },
+}
+
+// providerBaseURLs are the default base URLs of the providers which the key proxy sends the requests to.
+var providerBaseURLs = map[string]string{
+	"openai":    "https://api.openai.com/v1",
+	"anthropic": "https://api.anthropic.com/",
+}
+
+// IsProviderKeyEnv reports whether the name is the environment variable of a provider API key.
+func IsProviderKeyEnv(name string) bool {
+	for _, env := range providerKeyEnvs {
+		if name == env {
+			return true
+		}
+	}
+	return false
+}
+
+// providerEndpoint returns the base URL which sends the requests of the model to the base URL
+// with the API key of its provider from the secret of the same name as the environment variable, e.g. OPENAI_API_KEY.
+// The base URL is returned as is when the secret is not set, and the provider uses the environment.
+// The release function must be called after the requests.
+func providerEndpoint(ctx context.Context, nc *nats.Conn, model, baseURL string) (string, func()) {
+	m := chat.NewOptions().ModelCatalog.GetModel(model)
+	if m == nil {
+		return baseURL, func() {}
+	}
+	header, ok := providerKeyHeaders[m.Provider]
+	if !ok {
+		return baseURL, func() {}
+	}
+
+	value, err := secret.Get(ctx, nc, providerKeyEnvs[m.Provider])
+	if errors.Is(err, secret.ErrNotFound) {
+		return baseURL, func() {}
+	}
+	if err != nil {
+		slog.Warn("chat generate", "status", "get provider api key from secrets failed", "error", err)
+		return baseURL, func() {}
+	}
+	if baseURL == "" {
+		baseURL = providerBaseURLs[m.Provider]
+	}
+	target, err := url.Parse(baseURL)
+	if err != nil {
+		slog.Warn("chat generate", "status", "parse base url failed", "error", err)
+		return baseURL, func() {}
+	}
+	proxy, err := startKeyProxy()
+	if err != nil {
+		slog.Warn("chat generate", "status", "start key proxy failed", "error", err)
+		return baseURL, func() {}
+	}
+	name, value := header(value)
+	return proxy.add(target, name, value)
+}
+
+// keyProxy is the loopback reverse proxy which sends the provider requests with the API keys of the secrets.
+// The gengo providers read the API keys only from the environment and send the requests with the default
+// HTTP client, so they are sent to the proxy by the base URL instead, and the proxy sends them
+// to the provider with its own transport and the key of each request.
+type keyProxy struct {
+	url       string
+	transport http.RoundTripper
+
+	mu     sync.Mutex
+	routes map[string]*httputil.ReverseProxy
+}
+
+var startKeyProxy = sync.OnceValues(func() (*keyProxy, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen key proxy: %w", err)
+	}
+	p := &keyProxy{
+		url:       "http://" + l.Addr().String(),
+		transport: http.DefaultTransport.(*http.Transport).Clone(),
+		routes:    map[string]*httputil.ReverseProxy{},
+	}
+	go func() {
+		if err := http.Serve(l, p); err != nil {
+			slog.Error("chat key proxy", "status", "stopped", "error", err)
+		}
+	}()
+	return p, nil
+})
+
+// add adds the route to the target with the header, and returns the base URL of the route and the function to remove it.
+// The route has a random token so that the other requests to the loopback address can not use the key.
+func (p *keyProxy) add(target *url.URL, name, value string) (string, func()) {
+	token := rand.Text()
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Path = strings.TrimPrefix(pr.Out.URL.Path, "/"+token)
+			pr.Out.URL.RawPath = ""
+			pr.SetURL(target)
+			pr.Out.Header.Set(name, value)
+		},
+		Transport: p.transport,
+		// the chat completion chunks are streamed at once.
+		FlushInterval: -1,
+	}
+
+	p.mu.Lock()
+	p.routes[token] = rp
+	p.mu.Unlock()
+	return p.url + "/" + token, func() {
+		p.mu.Lock()
+		delete(p.routes, token)
+		p.mu.Unlock()
+	}
+}
+
+func (p *keyProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	p.mu.Lock()
+	rp, ok := p.routes[token]
+	p.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	rp.ServeHTTP(w, r)
+}
@@ -8,11 +8,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"strconv"
 
 	"github.com/jumonmd/gengo"
 	"github.com/jumonmd/gengo/chat"
 	"github.com/jumonmd/jumon/internal/config"
 	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/jumonmd/jumon/internal/secret"
 	"github.com/jumonmd/jumon/internal/tracer"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
@@ -55,6 +57,8 @@ func generateHandler(nc *nats.Conn, r micro.Request) {
 	slog.Debug("chat generate", "headers", r.Headers())
 	ctx, span := tracer.Start(tracer.NewContext(r.Headers()), nc, "chat.generate")
 	defer span.End()
+	ctx, stop := span.WithCancel(ctx)
+	defer stop()
 
 	req := &chat.Request{}
 	err := json.Unmarshal(r.Data(), req)
@@ -90,10 +94,49 @@ func generateHandler(nc *nats.Conn, r micro.Request) {
 	checks := extractCurrentChecks(req)
 	removeChecks(req)
 
-	opts := []chat.Option{chat.WithStream(streamer)}
+	if err := convertFileParts(req); err != nil {
+		span.SetError(ErrBadRequest.Wrap(err))
+		r.Error(ErrBadRequest.ServiceError(err))
+		return
+	}
+
+	// the secrets are resolved only in the base URLs of the config, as any client can send the header.
 	baseURL := r.Headers().Get("baseurl")
-	if baseURL != "" {
-		opts = append(opts, chat.WithBaseURL(baseURL))
+	if secret.HasRefs(baseURL) {
+		err := fmt.Errorf("secret references are not allowed in the base url header")
+		span.SetError(ErrBadRequest.Wrap(err))
+		r.Error(ErrBadRequest.ServiceError(err))
+		return
+	}
+	if baseURL == "" {
+		baseURL, err = providerBaseURL(ctx, nc, req.Model)
+		if err != nil {
+			span.SetError(ErrBadRequest.Wrap(err))
+			r.Error(ErrBadRequest.ServiceError(err))
+			return
+		}
+	}
+	// the API key secret of the provider is sent by the key proxy.
+	endpoint, release := providerEndpoint(ctx, nc, req.Model, baseURL)
+	defer release()
+	var opts []chat.Option
+	if endpoint != "" {
+		opts = append(opts, chat.WithBaseURL(endpoint))
+	}
+	// the response with the checks is streamed after it passes them,
+	// so that the rejected responses are not streamed before the regenerated one.
+	if checks == "" {
+		opts = append(opts, chat.WithStream(streamer))
+	}
+
+	timeout, err := config.GetDuration(ctx, nc, config.ChatTimeout)
+	if err != nil {
+		slog.Warn("chat generate", "status", "get chat timeout from config failed", "error", err)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
 	resp, err := gengo.Generate(ctx, req, opts...)
@@ -107,27 +150,99 @@ func generateHandler(nc *nats.Conn, r micro.Request) {
 	slog.Debug("chat generate", "response", resp)
 	span.SetResponse(resp)
 
-	// check response with checks directive
-	if checks != "" {
-		handleVerify(ctx, nc, r, checks, req, resp)
+	// check response with checks directive.
+	// the intermediate responses with tool calls are not checked.
+	if checks != "" && len(resp.ToolCalls()) == 0 {
+		maxRetries, _ := strconv.Atoi(r.Headers().Get("max-check-retries"))
+		var ok bool
+		resp, ok = handleVerify(ctx, nc, r, checks, req, resp, maxRetries, opts)
+		if !ok {
+			return
+		}
+	}
+	if checks != "" && streamer != nil {
+		streamResponse(resp, streamer)
 	}
 
 	r.RespondJSON(resp, micro.WithHeaders(span.Headers()))
 }
 
 // handleVerify handles the verify request using AI.
-func handleVerify(ctx context.Context, nc *nats.Conn, r micro.Request, checks string, req *chat.Request, resp *chat.Response) {
+// When some checks fail, the reasons are sent back to the model to regenerate the response up to maxRetries times.
+// It returns the response which passed the checks, or responds with the error and returns false.
+func handleVerify(ctx context.Context, nc *nats.Conn, r micro.Request, checks string, req *chat.Request, resp *chat.Response, maxRetries int, opts []chat.Option) (*chat.Response, bool) {
 	slog.Info("chat verify", "status", "started", "checks", checks)
+	verifyModel := req.Model
 	defaultVerifyModel, err := config.Get(ctx, nc, config.DefaultVerifyModel)
 	if err != nil {
 		slog.Warn("chat generate", "status", "get default verify model from configfailed", "error", err)
 	}
 	if defaultVerifyModel != "" {
-		req.Model = defaultVerifyModel
+		verifyModel = defaultVerifyModel
+	}
+	// the verify model can be of another provider, so the base URL of the generation is not used.
+	// the verification is not streamed, and sends the API key secret of the provider of the verify model.
+	baseURL, err := providerBaseURL(ctx, nc, verifyModel)
+	if err != nil {
+		slog.Error("chat verify", "status", "get base url failed", "error", err)
+		r.Error(ErrVerify.ServiceError(err))
+		return nil, false
+	}
+	endpoint, release := providerEndpoint(ctx, nc, verifyModel, baseURL)
+	defer release()
+	var verifyOpts []chat.Option
+	if endpoint != "" {
+		verifyOpts = append(verifyOpts, chat.WithBaseURL(endpoint))
 	}
 
+	for attempt := 0; ; attempt++ {
+		failed, err := verify(ctx, nc, verifyModel, resp, checks, attempt, verifyOpts)
+		if err != nil {
+			slog.Error("chat verify", "status", "verify error", "error", err)
+			r.Error(ErrVerify.ServiceError(err))
+			return nil, false
+		}
+		if len(failed) == 0 {
+			return resp, true
+		}
+		if attempt >= maxRetries {
+			err := fmt.Errorf("checks: %s response: %s", checkReasons(failed), resp.String())
+			slog.Info("chat verify", "status", "verify failed", "error", err)
+			r.Error(ErrVerifyFailed.ServiceError(err))
+			return nil, false
+		}
+
+		slog.Info("chat verify", "status", "regenerate", "attempt", attempt+1, "failed", len(failed))
+		req.Messages = append(req.Messages, resp.Messages...)
+		req.Messages = append(req.Messages, checkFeedback(failed))
+		resp, err = gengo.Generate(ctx, req, opts...)
+		if err != nil {
+			slog.Info("chat verify", "status", "regeneration failed", "err", err)
+			r.Error(ErrGeneration.ServiceError(err))
+			return nil, false
+		}
+	}
+}
+
+// streamResponse streams the text of the whole response at once.
+func streamResponse(resp *chat.Response, streamer chat.Streamer) {
+	for _, m := range resp.Messages {
+		text := m.ContentString()
+		if text == "" {
+			continue
+		}
+		if err := streamer(&chat.StreamResponse{Type: "text", Content: text}); err != nil {
+			slog.Warn("chat generate", "status", "stream response failed", "error", err)
+			return
+		}
+	}
+}
+
+// verify checks the response in a chat.verify span and returns the failed checks.
+func verify(ctx context.Context, nc *nats.Conn, model string, resp *chat.Response, checks string, attempt int, opts []chat.Option) ([]CheckResult, error) {
 	ctx, cspan := tracer.Start(ctx, nc, "chat.verify")
 	defer cspan.End()
+	cspan.SetAttribute("attempt", strconv.Itoa(attempt))
 
 	cspan.SetRequest(struct {
 		Response *chat.Response
@@ -136,22 +251,41 @@ func handleVerify(ctx context.Context, nc *nats.Conn, r micro.Request, checks st
 		Response: resp,
 		Checks:   checks,
 	})
-	passed, err := VerifyResponse(ctx, req, resp, checks)
+	results, err := VerifyResponse(ctx, model, resp, checks, opts...)
 	if err != nil {
-		slog.Error("chat verify", "status", "verify error", "error", err)
 		cspan.SetError(ErrVerify.Wrap(err))
-		r.Error(ErrVerify.ServiceError(err))
-		return
+		return nil, err
 	}
-	cspan.SetResponse(passed)
+	cspan.SetResponse(results)
 
-	if !passed {
-		err := fmt.Errorf("checks: %s response: %s", checks, resp.String())
-		slog.Info("chat verify", "status", "verify failed", "error", err)
-		cspan.SetError(ErrVerifyFailed.Wrap(err))
-		r.Error(ErrVerifyFailed.ServiceError(err))
-		return
+	failed := failedChecks(results)
+	if len(failed) > 0 {
+		cspan.SetError(ErrVerifyFailed.Wrap(fmt.Errorf("checks: %s", checkReasons(failed))))
+	}
+	return failed, nil
+}
+
+// providerBaseURL returns the base URL of the provider of the model in the config, or empty if it is not set.
+// The base URL can have secret references such as "https://gateway.example.com/v1?key={{secret.GATEWAY_KEY}}".
+func providerBaseURL(ctx context.Context, nc *nats.Conn, model string) (string, error) {
+	m := chat.NewOptions().ModelCatalog.GetModel(model)
+	if m == nil {
+		return "", nil
+	}
+	key := map[string]config.Key{
+		"openai":    config.OpenAIBaseURL,
+		"anthropic": config.AnthropicBaseURL,
+		"gemini":    config.GeminiBaseURL,
+	}[m.Provider]
+	if key == "" {
+		return "", nil
+	}
+	baseURL, err := config.Get(ctx, nc, key)
+	if err != nil {
+		slog.Warn("chat generate", "status", "get base url from config failed", "error", err)
+		return "", nil
 	}
+	return secret.Resolve(ctx, nc, baseURL)
 }
 
 // removeChecks removes custom check message content part from the request.
@@ -4,12 +4,21 @@
 package chat
 
 import (
+	"encoding/json"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/internal/config"
+	"github.com/jumonmd/jumon/internal/secret"
 	"github.com/jumonmd/jumon/internal/testutil"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 func TestChatService(t *testing.T) {
@@ -48,3 +57,272 @@ func TestChatService(t *testing.T) {
 		t.Fatalf("expected %q, got %q", "hello", resp.Messages[0].ContentString())
 	}
 }
+
+func TestChatVerify(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	svc, err := NewService(nc)
+	if err != nil {
+		t.Fatalf("failed to create prompt service: %v", err)
+	}
+	defer svc.Stop()
+
+	// test llm: the check passes only for the response regenerated with the feedback.
+	testllm := testutil.NewMockOpenAIServerFunc(func(req testutil.ChatCompletionRequest) string {
+		data, _ := json.Marshal(req.Messages)
+		prompt := string(data)
+		switch {
+		case strings.Contains(prompt, "Check:") && strings.Contains(prompt, "fixed hello"):
+			return `{"passed": true, "reason": "it is fixed"}`
+		case strings.Contains(prompt, "Check:"):
+			return `{"passed": false, "reason": "it is not fixed"}`
+		case strings.Contains(prompt, "did not pass"):
+			return "fixed hello"
+		}
+		return "hello"
+	})
+	defer testllm.Close()
+
+	// the verification uses the base URL of the config of the verify model.
+	if _, err := js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: "config"}); err != nil {
+		t.Fatalf("failed to create config store: %v", err)
+	}
+	if err := config.Set(t.Context(), nc, config.OpenAIBaseURL, testllm.URL); err != nil {
+		t.Fatalf("failed to set config: %v", err)
+	}
+
+	newRequest := func() *chat.Request {
+		msg := chat.NewTextMessage(chat.MessageRoleHuman, "say hello")
+		msg.Content = append(msg.Content, chat.ContentPart{Type: "check", Text: "the response is repaired\n"})
+		return &chat.Request{Model: "gpt-4o-mini", Messages: []chat.Message{msg}}
+	}
+
+	sub, err := nc.SubscribeSync("stream.verify")
+	if err != nil {
+		t.Fatalf("failed to subscribe stream: %v", err)
+	}
+	ctx := tracer.WithStreamTo(WithMaxCheckRetries(t.Context(), 1), "stream.verify")
+	resp, err := Generate(ctx, nc, newRequest())
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	if got := resp.Messages[len(resp.Messages)-1].ContentString(); got != "fixed hello" {
+		t.Errorf("expected %q, got %q", "fixed hello", got)
+	}
+
+	// only the response which passed the checks is streamed.
+	streamed := ""
+	for {
+		msg, err := sub.NextMsg(100 * time.Millisecond)
+		if err != nil {
+			break
+		}
+		sr := chat.StreamResponse{}
+		if err := json.Unmarshal(msg.Data, &sr); err != nil {
+			t.Fatalf("failed to unmarshal stream response: %v", err)
+		}
+		streamed += sr.Content
+	}
+	if streamed != "fixed hello" {
+		t.Errorf("streamed %q, want %q", streamed, "fixed hello")
+	}
+
+	_, err = Generate(t.Context(), nc, newRequest())
+	if err == nil || !strings.Contains(err.Error(), "500102") || !strings.Contains(err.Error(), "it is not fixed") {
+		t.Errorf("expected verify failed error with the reason, got %v", err)
+	}
+}
+
+func TestChatVerifyProvider(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	svc, err := NewService(nc)
+	if err != nil {
+		t.Fatalf("failed to create chat service: %v", err)
+	}
+	defer svc.Stop()
+
+	// the generation is sent to the test openai llm, and the verification to the test anthropic llm.
+	openaillm := testutil.NewMockOpenAIServerFunc(func(req testutil.ChatCompletionRequest) string {
+		data, _ := json.Marshal(req.Messages)
+		if strings.Contains(string(data), "Check:") {
+			t.Errorf("verification is sent to the openai base url")
+		}
+		return "hello"
+	})
+	defer openaillm.Close()
+	verified := make(chan string, 1)
+	anthropicllm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verified <- r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"id":          "msg_test",
+			"type":        "message",
+			"role":        "assistant",
+			"model":       "claude-3-5-haiku-latest",
+			"content":     []map[string]string{{"type": "text", "text": `{"passed": true, "reason": "ok"}`}},
+			"stop_reason": "end_turn",
+			"usage":       map[string]int{"input_tokens": 1, "output_tokens": 1},
+		})
+	}))
+	defer anthropicllm.Close()
+
+	t.Setenv("ANTHROPIC_API_KEY", "test-key")
+	if _, err := js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: "config"}); err != nil {
+		t.Fatalf("failed to create config store: %v", err)
+	}
+	for key, value := range map[config.Key]string{
+		config.DefaultVerifyModel: "claude-3-5-haiku-latest",
+		config.AnthropicBaseURL:   anthropicllm.URL,
+	} {
+		if err := config.Set(t.Context(), nc, key, value); err != nil {
+			t.Fatalf("failed to set config %s: %v", key, err)
+		}
+	}
+
+	msg := chat.NewTextMessage(chat.MessageRoleHuman, "say hello")
+	msg.Content = append(msg.Content, chat.ContentPart{Type: "check", Text: "the response is a greeting\n"})
+	req := &chat.Request{Model: "gpt-4o-mini", Messages: []chat.Message{msg}}
+	if _, err := Generate(t.Context(), nc, req, chat.WithBaseURL(openaillm.URL)); err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	select {
+	case path := <-verified:
+		if path != "/v1/messages" {
+			t.Errorf("verification path = %q, want /v1/messages", path)
+		}
+	default:
+		t.Errorf("verification is not sent to the anthropic base url")
+	}
+}
+
+func TestFailedChecks(t *testing.T) {
+	results := []CheckResult{
+		{Check: "is polite", Passed: true},
+		{Check: "is short", Passed: false, Reason: "too long"},
+	}
+	failed := failedChecks(results)
+	if len(failed) != 1 || failed[0].Check != "is short" {
+		t.Fatalf("unexpected failed checks: %v", failed)
+	}
+	msg := checkFeedback(failed)
+	if !strings.Contains(msg.ContentString(), "- is short: too long") {
+		t.Errorf("unexpected feedback: %s", msg.ContentString())
+	}
+}
+
+func TestConvertFileParts(t *testing.T) {
+	newRequest := func(model string) *chat.Request {
+		msg := chat.NewTextMessage(chat.MessageRoleHuman, "FILE receipt.pdf (application/pdf):")
+		msg.Content = append(msg.Content, chat.ContentPart{Type: "file", DataURL: "data:application/pdf;base64,JVBERi0xLjQK"})
+		return &chat.Request{Model: model, Messages: []chat.Message{msg}}
+	}
+
+	// the files are sent to gemini as the inline data of the image parts.
+	req := newRequest("gemini/gemini-2.0-flash")
+	if err := convertFileParts(req); err != nil {
+		t.Fatalf("convertFileParts() error = %v", err)
+	}
+	if got := req.Messages[0].Content[1].Type; got != "image" {
+		t.Errorf("part type = %q, want image", got)
+	}
+
+	// the other providers do not accept them.
+	for _, model := range []string{"gpt-4o-mini", "claude-3-5-haiku-latest"} {
+		if err := convertFileParts(newRequest(model)); err == nil || !strings.Contains(err.Error(), "application/pdf") {
+			t.Errorf("convertFileParts() for %s error = %v, want unsupported file error", model, err)
+		}
+	}
+}
+
+func TestChatSecret(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	svc, err := NewService(nc)
+	if err != nil {
+		t.Fatalf("failed to create chat service: %v", err)
+	}
+	defer svc.Stop()
+
+	mockllm := testutil.NewMockOpenAIServer()
+	defer mockllm.Close()
+	auths := make(chan string, 2)
+	testllm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths <- r.Header.Get("Authorization")
+		mockllm.Server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer testllm.Close()
+
+	// setup secret store
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("OPENAI_API_KEY", "env-key")
+	if _, err := js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: secret.Bucket}); err != nil {
+		t.Fatalf("failed to create secret store: %v", err)
+	}
+	if err := secret.Set(t.Context(), nc, "LLM_HOST", strings.TrimPrefix(testllm.URL, "http://"), nil); err != nil {
+		t.Fatalf("failed to set secret: %v", err)
+	}
+	if err := secret.Set(t.Context(), nc, "OPENAI_API_KEY", "secret-key", nil); err != nil {
+		t.Fatalf("failed to set secret: %v", err)
+	}
+
+	// the base URL in the config has the secret reference.
+	if _, err := js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: "config"}); err != nil {
+		t.Fatalf("failed to create config store: %v", err)
+	}
+	if err := config.Set(t.Context(), nc, config.OpenAIBaseURL, "http://{{secret.LLM_HOST}}/v1"); err != nil {
+		t.Fatalf("failed to set config: %v", err)
+	}
+
+	req := &chat.Request{
+		Model:    "gpt-4o-mini",
+		Messages: []chat.Message{chat.NewTextMessage(chat.MessageRoleHuman, "say hello")},
+	}
+	resp, err := Generate(t.Context(), nc, req)
+	if err != nil {
+		t.Fatalf("failed to generate: %v", err)
+	}
+	if len(resp.Messages) == 0 {
+		t.Fatalf("expected a response message")
+	}
+	// the secret is sent instead of the environment, which is not changed.
+	if got := <-auths; got != "Bearer secret-key" {
+		t.Errorf("Authorization = %q, want the secret", got)
+	}
+	if got := os.Getenv("OPENAI_API_KEY"); got != "env-key" {
+		t.Errorf("OPENAI_API_KEY = %q, want the environment", got)
+	}
+	// the key is sent by the own transport of the key proxy, not by the default transport of the process.
+	if _, ok := http.DefaultTransport.(*http.Transport); !ok {
+		t.Errorf("http.DefaultTransport is replaced: %T", http.DefaultTransport)
+	}
+
+	// the secrets are not resolved in the base URL of the request, which any client can send.
+	_, err = Generate(t.Context(), nc, req, chat.WithBaseURL("http://example.com/v1?key={{secret.OPENAI_API_KEY}}"))
+	if err == nil || !strings.Contains(err.Error(), "400100") {
+		t.Errorf("expected bad request for secret in base URL, got %v", err)
+	}
+
+	if err := config.Set(t.Context(), nc, config.OpenAIBaseURL, "http://{{secret.UNKNOWN}}/v1"); err != nil {
+		t.Fatalf("failed to set config: %v", err)
+	}
+	_, err = Generate(t.Context(), nc, req)
+	if err == nil {
+		t.Errorf("expected error for unknown secret in base URL")
+	}
+}
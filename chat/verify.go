@@ -5,49 +5,125 @@ package chat
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"strings"
 
 	"github.com/jumonmd/gengo"
 	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/gengo/jsonschema"
 )
 
 const checkPromptTemplate = `
 You are a helpful assistant that checks the response of the user.
-The user will provide a response and a list of checks.
-You will check the response against the checks and return a list of results.
-Answer only with true or false.
+The user will provide a response and a check.
+You will decide whether the response satisfies the check.
+Answer only with the JSON object {"passed": true or false, "reason": "short reason"}.
 
 Response:
 %s
 
-Checks:
+Check:
 %s
 `
 
-// VerifyResponse checks the response against the checks and returns true if the response is accepted.
-func VerifyResponse(ctx context.Context, req *chat.Request, resp *chat.Response, checks string) (bool, error) {
-	slog.Info("check response", "response", resp.String(), "checks", checks)
-	prompt := fmt.Sprintf(checkPromptTemplate, resp.String(), checks)
+const checkFeedbackTemplate = `The response did not pass the following checks:
+%s
+
+Fix the response so that it passes all the checks. Answer only with the fixed response.`
+
+// CheckResult is the result of a check of the response.
+type CheckResult struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// checkResultSchema is the response schema of a check.
+var checkResultSchema = jsonschema.Schema{
+	"type": "object",
+	"properties": map[string]any{
+		"passed": map[string]any{"type": "boolean"},
+		"reason": map[string]any{"type": "string"},
+	},
+	"required": []any{"passed", "reason"},
+}
+
+// VerifyResponse checks the response against each of the checks separated by new lines
+// and returns the results in the order of the checks.
+func VerifyResponse(ctx context.Context, model string, resp *chat.Response, checks string, opts ...chat.Option) ([]CheckResult, error) {
+	results := []CheckResult{}
+	for _, check := range strings.Split(checks, "\n") {
+		check = strings.TrimSpace(check)
+		if check == "" {
+			continue
+		}
+		result, err := verifyCheck(ctx, model, resp, check, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("check %q: %w", check, err)
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+// verifyCheck asks the model whether the response satisfies the check.
+func verifyCheck(ctx context.Context, model string, resp *chat.Response, check string, opts ...chat.Option) (*CheckResult, error) {
+	slog.Info("check response", "response", resp.String(), "check", check)
+	prompt := fmt.Sprintf(checkPromptTemplate, resp.String(), check)
 
 	r := &chat.Request{
-		Model: req.Model,
+		Model: model,
 		Config: chat.ModelConfig{
 			Temperature: 0.0001,
 		},
-		Messages: []chat.Message{chat.NewTextMessage(chat.MessageRoleHuman, prompt)},
+		Messages:       []chat.Message{chat.NewTextMessage(chat.MessageRoleHuman, prompt)},
+		ResponseSchema: checkResultSchema,
 	}
-	resp, err := gengo.Generate(ctx, r)
+	cresp, err := gengo.Generate(ctx, r, opts...)
 	if err != nil {
-		return false, fmt.Errorf("generate: %w", err)
+		return nil, fmt.Errorf("generate: %w", err)
 	}
 
-	slog.Debug("check response", "response", resp.String())
-	if strings.Contains(strings.ToLower(resp.String()), "true") {
-		return true, nil
+	slog.Debug("check response", "response", cresp.String())
+	if len(cresp.Messages) == 0 {
+		return nil, fmt.Errorf("no check result")
 	}
+	result := &CheckResult{}
+	content := cresp.Messages[len(cresp.Messages)-1].ContentString()
+	if err := json.Unmarshal([]byte(strings.TrimSpace(content)), result); err != nil {
+		return nil, fmt.Errorf("unmarshal check result: %w", err)
+	}
+	result.Check = check
+	return result, nil
+}
+
+// failedChecks returns the failed results, or nil if all the checks passed.
+func failedChecks(results []CheckResult) []CheckResult {
+	var failed []CheckResult
+	for _, r := range results {
+		if !r.Passed {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
 
-	// failed
-	return false, nil
+// checkReasons returns the failed checks with their reasons in a line.
+func checkReasons(failed []CheckResult) string {
+	reasons := make([]string, len(failed))
+	for i, r := range failed {
+		reasons[i] = fmt.Sprintf("%s (%s)", r.Check, r.Reason)
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// checkFeedback returns the message to the generating model with the reasons of the failed checks.
+func checkFeedback(failed []CheckResult) chat.Message {
+	lines := make([]string, len(failed))
+	for i, r := range failed {
+		lines[i] = fmt.Sprintf("- %s: %s", r.Check, r.Reason)
+	}
+	return chat.NewTextMessage(chat.MessageRoleHuman, fmt.Sprintf(checkFeedbackTemplate, strings.Join(lines, "\n")))
 }
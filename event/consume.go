@@ -0,0 +1,256 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jumonmd/jumon/internal/subject"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	consumerName      = "jumon"
+	defaultMaxDeliver = 5
+	maxNakDelay       = time.Minute
+	// ackWait is the redelivery timeout of the unacked messages.
+	// The running messages are kept in progress by keepInProgress.
+	ackWait = 30 * time.Second
+)
+
+// consumers runs the durable JetStream consumers of the consume events.
+type consumers struct {
+	nc      *nats.Conn
+	js      jetstream.JetStream
+	mu      sync.Mutex
+	running map[string]jetstream.ConsumeContext
+	watcher jetstream.KeyWatcher
+}
+
+func newConsumers(nc *nats.Conn, js jetstream.JetStream) *consumers {
+	return &consumers{nc: nc, js: js, running: map[string]jetstream.ConsumeContext{}}
+}
+
+// watch watches the event key value store and starts or stops the consumers
+// when consume events are put or deleted.
+func (c *consumers) watch(ctx context.Context) error {
+	kv, err := c.js.KeyValue(ctx, "event")
+	if err != nil {
+		return fmt.Errorf("get key value store: %w", err)
+	}
+	w, err := kv.WatchAll(context.Background())
+	if err != nil {
+		return fmt.Errorf("watch events: %w", err)
+	}
+	c.watcher = w
+
+	go func() {
+		for entry := range w.Updates() {
+			if entry == nil {
+				continue
+			}
+			typ, subj, _ := strings.Cut(entry.Key(), ".")
+			if Type(typ) != EventTypeConsume {
+				continue
+			}
+
+			switch entry.Operation() {
+			case jetstream.KeyValuePut:
+				var evt Event
+				if err := json.Unmarshal(entry.Value(), &evt); err != nil {
+					slog.Error("event consume", "status", "unmarshal event failed", "key", entry.Key(), "error", err)
+					continue
+				}
+				if err := c.start(ctx, &evt); err != nil {
+					slog.Error("event consume", "status", "start consumer failed", "subject", evt.Subject, "error", err)
+				}
+			case jetstream.KeyValueDelete, jetstream.KeyValuePurge:
+				if err := c.remove(ctx, subj); err != nil {
+					slog.Error("event consume", "status", "remove consumer failed", "subject", subj, "error", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// start creates the stream and the durable consumer of the event and starts consuming.
+func (c *consumers) start(ctx context.Context, evt *Event) error {
+	maxDeliver := evt.MaxDeliver
+	if maxDeliver <= 0 {
+		maxDeliver = defaultMaxDeliver
+	}
+
+	stream, err := c.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:        streamName(evt.Subject),
+		Description: "consume event " + evt.Subject,
+		Subjects:    []string{subscribeSubject + "." + evt.Subject},
+		Retention:   jetstream.WorkQueuePolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("create stream: %w", err)
+	}
+
+	cons, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:    consumerName,
+		AckPolicy:  jetstream.AckExplicitPolicy,
+		AckWait:    ackWait,
+		MaxDeliver: maxDeliver,
+	})
+	if err != nil {
+		return fmt.Errorf("create consumer: %w", err)
+	}
+
+	c.stop(evt.Subject)
+
+	cc, err := cons.Consume(func(msg jetstream.Msg) {
+		c.handle(evt, maxDeliver, msg)
+	})
+	if err != nil {
+		return fmt.Errorf("consume: %w", err)
+	}
+
+	c.mu.Lock()
+	c.running[evt.Subject] = cc
+	c.mu.Unlock()
+
+	slog.Info("event consume", "status", "started", "subject", evt.Subject, "module", evt.Module)
+	return nil
+}
+
+// handle runs the module with the message.
+// The message is acked on success, or redelivered with backoff on failure.
+// After max deliveries, the message is published to the dead letter subject.
+func (c *consumers) handle(evt *Event, maxDeliver int, msg jetstream.Msg) {
+	ctx := tracer.NewContext(msg.Headers())
+	slog.Info("event consume", "subject", evt.Subject, "module", evt.Module)
+
+	if selfPublished(evt, msg.Headers()) {
+		slog.Error("event consume", "status", "module consumes its own output", "subject", evt.Subject, "module", evt.Module)
+		if err := msg.TermWithReason("self published"); err != nil {
+			slog.Error("event consume", "status", "term failed", "error", err)
+		}
+		return
+	}
+
+	stop := keepInProgress(msg)
+	resp, err := runModule(ctx, c.nc, evt.Module, msg.Data())
+	stop()
+	if err == nil {
+		slog.Info("event consume", "subject", evt.Subject, "response", string(resp))
+		if err := msg.Ack(); err != nil {
+			slog.Error("event consume", "status", "ack failed", "error", err)
+		}
+		return
+	}
+
+	slog.Error("event consume", "status", "run module failed", "subject", evt.Subject, "error", err)
+	meta, merr := msg.Metadata()
+	if merr != nil {
+		slog.Error("event consume", "status", "get metadata failed", "error", merr)
+		_ = msg.Nak()
+		return
+	}
+
+	if meta.NumDelivered < uint64(maxDeliver) {
+		if err := msg.NakWithDelay(nakDelay(meta.NumDelivered)); err != nil {
+			slog.Error("event consume", "status", "nak failed", "error", err)
+		}
+		return
+	}
+
+	if evt.DeadLetter != "" {
+		dlq := &nats.Msg{
+			Subject: evt.DeadLetter,
+			Data:    msg.Data(),
+			Header:  nats.Header{},
+		}
+		for k, v := range msg.Headers() {
+			dlq.Header[k] = v
+		}
+		dlq.Header.Set("Jumon-Event-Subject", msg.Subject())
+		dlq.Header.Set("Jumon-Error", err.Error())
+		if err := c.nc.PublishMsg(dlq); err != nil {
+			slog.Error("event consume", "status", "publish dead letter failed", "error", err)
+		}
+	}
+	if err := msg.TermWithReason(err.Error()); err != nil {
+		slog.Error("event consume", "status", "term failed", "error", err)
+	}
+}
+
+// keepInProgress resets the ack wait of the message until stop is called,
+// so that the message is not redelivered while the module is still running.
+func keepInProgress(msg jetstream.Msg) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(ackWait / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := msg.InProgress(); err != nil {
+					slog.Error("event consume", "status", "in progress failed", "error", err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// stop stops consuming the event subject.
+func (c *consumers) stop(subj string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cc, ok := c.running[subj]; ok {
+		cc.Stop()
+		delete(c.running, subj)
+	}
+}
+
+// remove stops consuming and deletes the stream of the event subject.
+func (c *consumers) remove(ctx context.Context, subj string) error {
+	c.stop(subj)
+	err := c.js.DeleteStream(ctx, streamName(subj))
+	if err != nil && !errors.Is(err, jetstream.ErrStreamNotFound) {
+		return fmt.Errorf("delete stream: %w", err)
+	}
+	slog.Info("event consume", "status", "removed", "subject", subj)
+	return nil
+}
+
+// close stops the watcher and all consumers.
+func (c *consumers) close() {
+	if c.watcher != nil {
+		_ = c.watcher.Stop()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for subj, cc := range c.running {
+		cc.Stop()
+		delete(c.running, subj)
+	}
+}
+
+// streamName returns the stream name for the event subject.
+func streamName(subj string) string {
+	return "event_" + subject.Escape(subj)
+}
+
+// nakDelay returns the exponential redelivery delay for the delivery count.
+func nakDelay(delivered uint64) time.Duration {
+	delay := time.Second << min(delivered, 6)
+	return min(delay, maxNakDelay)
+}
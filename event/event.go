@@ -9,27 +9,96 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"time"
 
+	"github.com/jumonmd/jumon/internal/cron"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
 type Type string
 
+const (
+	// HeaderTraceID is the header of the published module output which has the originating trace ID.
+	HeaderTraceID = "Jumon-Trace-Id"
+	// HeaderModule is the header of the published module output which has the module URL of the run. e.g. "mod#main".
+	HeaderModule = "Jumon-Module"
+)
+
+// ErrEventConflict is returned when a module defines an event which is owned by another module,
+// or which is registered by the event service endpoints.
+var ErrEventConflict = errors.New("event is owned by another module")
+
 const (
 	EventTypeSubscribe Type = "subscribe"
 	EventTypePublish   Type = "publish"
 	EventTypeConsume   Type = "consume"
+	EventTypeSchedule  Type = "schedule"
 )
 
 type Event struct {
 	Type    Type   `json:"type"`
 	Subject string `json:"subject"`
 	Module  string `json:"module"`
+	// Owner is the module which defines the event in its JUMON.md.
+	// It is empty for the events registered by the event service endpoints.
+	Owner string `json:"owner,omitempty"`
+	// MaxDeliver is the maximum delivery attempts of a consume event message.
+	MaxDeliver int `json:"max_deliver,omitempty"`
+	// DeadLetter is the subject where a consume event message is published after MaxDeliver failures.
+	DeadLetter string `json:"dead_letter,omitempty"`
+	// Schedule is the cron expression of a schedule event. e.g. "0 9 * * mon-fri".
+	Schedule string `json:"schedule,omitempty"`
+	// Timezone is the IANA time zone of the schedule. UTC if empty.
+	Timezone string `json:"timezone,omitempty"`
+}
+
+// Validate validates the event.
+func (e *Event) Validate() error {
+	switch e.Type {
+	case EventTypeSubscribe, EventTypePublish, EventTypeConsume, EventTypeSchedule:
+	default:
+		return fmt.Errorf("unknown event type: %s", e.Type)
+	}
+	if e.Subject == "" {
+		return fmt.Errorf("subject is required")
+	}
+	if slices.Contains(manageEndpoints, e.Subject) {
+		return fmt.Errorf("reserved subject: %s", e.Subject)
+	}
+	if e.Module == "" {
+		return fmt.Errorf("module is required")
+	}
+	if e.MaxDeliver < 0 {
+		return fmt.Errorf("max_deliver must not be negative")
+	}
+	if e.Type == EventTypeSchedule {
+		if e.Schedule == "" {
+			return fmt.Errorf("schedule is required")
+		}
+		if _, err := cron.Parse(e.Schedule); err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+		if _, err := time.LoadLocation(e.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+	return nil
+}
+
+// eventKey returns the key value store key of the event.
+func eventKey(typ Type, subject string) string {
+	return fmt.Sprintf("%s.%s", typ, subject)
+}
+
+// notFound reports whether the error is caused by a missing event.
+func notFound(err error) bool {
+	return errors.Is(err, jetstream.ErrKeyNotFound)
 }
 
 // PutEvent puts an event into the key value store.
 func PutEvent(ctx context.Context, js jetstream.JetStream, evt *Event) error {
-	key := fmt.Sprintf("%s.%s", evt.Type, evt.Subject)
+	key := eventKey(evt.Type, evt.Subject)
 
 	evtdata, err := json.Marshal(evt)
 	if err != nil {
@@ -52,7 +121,7 @@ func PutEvent(ctx context.Context, js jetstream.JetStream, evt *Event) error {
 
 // GetEvent gets an event from the key value store.
 func GetEvent(ctx context.Context, js jetstream.JetStream, typ Type, subject string) (*Event, error) {
-	key := fmt.Sprintf("%s.%s", typ, subject)
+	key := eventKey(typ, subject)
 
 	kv, err := js.KeyValue(ctx, "event")
 	if err != nil {
@@ -74,7 +143,7 @@ func GetEvent(ctx context.Context, js jetstream.JetStream, typ Type, subject str
 
 // DeleteEvent deletes an event from the key value store.
 func DeleteEvent(ctx context.Context, js jetstream.JetStream, typ Type, subject string) error {
-	key := fmt.Sprintf("%s.%s", typ, subject)
+	key := eventKey(typ, subject)
 
 	kv, err := js.KeyValue(ctx, "event")
 	if err != nil {
@@ -119,3 +188,46 @@ func ListEvents(ctx context.Context, js jetstream.JetStream) ([]Event, error) {
 
 	return events, nil
 }
+
+// SyncEvents reconciles the events owned by the module with the given events.
+// It puts the given events and deletes the owned events that are no longer defined.
+// Nothing is changed if one of the given events is owned by another module.
+func SyncEvents(ctx context.Context, js jetstream.JetStream, owner string, events []Event) error {
+	current, err := ListEvents(ctx, js)
+	if err != nil {
+		return fmt.Errorf("list events: %w", err)
+	}
+
+	owners := map[string]string{}
+	for _, evt := range current {
+		owners[eventKey(evt.Type, evt.Subject)] = evt.Owner
+	}
+	for _, evt := range events {
+		if o, ok := owners[eventKey(evt.Type, evt.Subject)]; ok && o != owner {
+			return fmt.Errorf("%w: %s %s of %q", ErrEventConflict, evt.Type, evt.Subject, o)
+		}
+	}
+
+	defined := map[string]bool{}
+	for _, evt := range events {
+		evt.Owner = owner
+		err := PutEvent(ctx, js, &evt)
+		if err != nil {
+			return fmt.Errorf("put event: %w", err)
+		}
+		defined[eventKey(evt.Type, evt.Subject)] = true
+	}
+
+	for _, evt := range current {
+		if evt.Owner != owner || defined[eventKey(evt.Type, evt.Subject)] {
+			continue
+		}
+		slog.Info("delete stale event", "owner", owner, "type", evt.Type, "subject", evt.Subject)
+		err := DeleteEvent(ctx, js, evt.Type, evt.Subject)
+		if err != nil {
+			return fmt.Errorf("delete event: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,413 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/jumonmd/jumon/internal/secret"
+	"github.com/jumonmd/jumon/internal/testutil"
+	"github.com/jumonmd/jumon/tool"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestSyncEvents(t *testing.T) {
+	// setup test server
+	_, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	_, err = setupKV(js)
+	if err != nil {
+		t.Fatalf("failed to setup kv: %v", err)
+	}
+
+	// registered by hand, it must not be removed by the module
+	manual := &Event{Type: EventTypeSubscribe, Subject: "manual", Module: "other"}
+	if err := PutEvent(t.Context(), js, manual); err != nil {
+		t.Fatalf("failed to put event: %v", err)
+	}
+
+	first := []Event{
+		{Type: EventTypeSubscribe, Subject: "a", Module: "mod"},
+		{Type: EventTypeSubscribe, Subject: "b", Module: "mod"},
+	}
+	if err := SyncEvents(t.Context(), js, "mod", first); err != nil {
+		t.Fatalf("failed to sync events: %v", err)
+	}
+
+	second := []Event{
+		{Type: EventTypeSubscribe, Subject: "b", Module: "mod#other"},
+	}
+	if err := SyncEvents(t.Context(), js, "mod", second); err != nil {
+		t.Fatalf("failed to sync events: %v", err)
+	}
+
+	// the events of the other owners must not be overwritten
+	conflicts := map[string][]Event{
+		"other": {{Type: EventTypeSubscribe, Subject: "b", Module: "other"}},
+		"mod":   {{Type: EventTypeSubscribe, Subject: "manual", Module: "mod"}},
+	}
+	for owner, events := range conflicts {
+		if err := SyncEvents(t.Context(), js, owner, events); !errors.Is(err, ErrEventConflict) {
+			t.Errorf("SyncEvents() by %s error = %v, want ErrEventConflict", owner, err)
+		}
+	}
+
+	got, err := ListEvents(t.Context(), js)
+	if err != nil {
+		t.Fatalf("failed to list events: %v", err)
+	}
+
+	want := []Event{
+		{Type: EventTypeSubscribe, Subject: "b", Module: "mod#other", Owner: "mod"},
+		{Type: EventTypeSubscribe, Subject: "manual", Module: "other"},
+	}
+	sortEvents := cmpopts.SortSlices(func(a, b Event) bool { return a.Subject < b.Subject })
+	if diff := cmp.Diff(want, got, sortEvents); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOwnedEvent(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	svc, err := NewService(nc, js)
+	if err != nil {
+		t.Fatalf("failed to start event service: %v", err)
+	}
+	defer svc.Stop()
+
+	owned := []Event{{Type: EventTypeSubscribe, Subject: "owned", Module: "mod"}}
+	if err := SyncEvents(t.Context(), js, "mod", owned); err != nil {
+		t.Fatalf("failed to sync events: %v", err)
+	}
+
+	request := func(endpoint, module string, evt Event) string {
+		t.Helper()
+		data, err := json.Marshal(evt)
+		if err != nil {
+			t.Fatalf("failed to marshal event: %v", err)
+		}
+		header := nats.Header{}
+		if module != "" {
+			signature, err := secret.SignModule(module, data)
+			if err != nil {
+				t.Fatalf("failed to sign request: %v", err)
+			}
+			header.Set(tool.HeaderModule, module)
+			header.Set(tool.HeaderModuleSignature, signature)
+		}
+		resp, err := nc.RequestMsg(&nats.Msg{Subject: "event." + endpoint, Data: data, Header: header}, time.Second)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp.Header.Get("Nats-Service-Error-Code")
+	}
+
+	overwrite := Event{Type: EventTypeSubscribe, Subject: "owned", Module: "other"}
+	for _, module := range []string{"", "other"} {
+		if got := request("put", module, overwrite); got != "409500" {
+			t.Errorf("put by %q error code = %q, want 409500", module, got)
+		}
+		if got := request("delete", module, overwrite); got != "409500" {
+			t.Errorf("delete by %q error code = %q, want 409500", module, got)
+		}
+	}
+
+	// the owner can change its event and keeps owning it
+	if got := request("put", "mod", overwrite); got != "" {
+		t.Fatalf("put by owner error code = %q, want none", got)
+	}
+	got, err := GetEvent(t.Context(), js, EventTypeSubscribe, "owned")
+	if err != nil {
+		t.Fatalf("failed to get event: %v", err)
+	}
+	want := &Event{Type: EventTypeSubscribe, Subject: "owned", Module: "other", Owner: "mod"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("event mismatch (-want +got):\n%s", diff)
+	}
+	if got := request("delete", "mod", overwrite); got != "" {
+		t.Errorf("delete by owner error code = %q, want none", got)
+	}
+}
+
+func TestSelfPublished(t *testing.T) {
+	tests := []struct {
+		module string
+		from   string
+		want   bool
+	}{
+		{module: "mod", from: "mod#main", want: true},
+		{module: "mod#other", from: "mod#other", want: true},
+		{module: "mod", from: "mod#other", want: false},
+		{module: "mod", from: "", want: false},
+	}
+	for _, tt := range tests {
+		h := nats.Header{}
+		if tt.from != "" {
+			h.Set(HeaderModule, tt.from)
+		}
+		if got := selfPublished(&Event{Module: tt.module}, h); got != tt.want {
+			t.Errorf("selfPublished(%s, %s) = %v, want %v", tt.module, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestConsume(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	svc, err := NewService(nc, js)
+	if err != nil {
+		t.Fatalf("failed to start event service: %v", err)
+	}
+	defer svc.Stop()
+
+	// mock module service, it fails for the "fail" module.
+	received := make(chan string, 10)
+	mod, err := micro.AddService(nc, micro.Config{
+		Name:    "test-module",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "module.run.>",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				received <- string(r.Data())
+				if r.Subject() == "module.run.fail" {
+					r.Error("500", "failed", nil)
+					return
+				}
+				r.Respond([]byte(`"ok"`))
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to start module service: %v", err)
+	}
+	defer mod.Stop()
+
+	dead, err := nc.SubscribeSync("jobs.dead")
+	if err != nil {
+		t.Fatalf("failed to subscribe dead letter: %v", err)
+	}
+
+	events := []*Event{
+		{Type: EventTypeConsume, Subject: "jobs.ok", Module: "ok"},
+		{Type: EventTypeConsume, Subject: "jobs.fail", Module: "fail", MaxDeliver: 1, DeadLetter: "jobs.dead"},
+	}
+	for _, evt := range events {
+		if err := PutEvent(t.Context(), js, evt); err != nil {
+			t.Fatalf("failed to put event: %v", err)
+		}
+	}
+
+	// wait for the consumers to be created
+	for _, subj := range []string{"jobs.ok", "jobs.fail"} {
+		waitStream(t, js, subj)
+	}
+
+	if _, err := js.Publish(t.Context(), "event.jobs.ok", []byte(`{"id":1}`)); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != `{"id":1}` {
+			t.Errorf("module received %s", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("module was not run")
+	}
+
+	if _, err := js.Publish(t.Context(), "event.jobs.fail", []byte(`{"id":2}`)); err != nil {
+		t.Fatalf("failed to publish: %v", err)
+	}
+	msg, err := dead.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatalf("no dead letter message: %v", err)
+	}
+	if string(msg.Data) != `{"id":2}` {
+		t.Errorf("dead letter data = %s", msg.Data)
+	}
+	if got := msg.Header.Get("Jumon-Event-Subject"); got != "event.jobs.fail" {
+		t.Errorf("dead letter subject header = %s", got)
+	}
+}
+
+func waitStream(t *testing.T, js jetstream.JetStream, subj string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		stream, err := js.Stream(t.Context(), streamName(subj))
+		if err == nil {
+			if _, err := stream.Consumer(t.Context(), consumerName); err == nil {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("consumer of %s was not created", subj)
+}
+
+func TestSchedule(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	_, err = setupKV(js)
+	if err != nil {
+		t.Fatalf("failed to setup kv: %v", err)
+	}
+
+	received := make(chan ScheduleInput, 10)
+	mod, err := micro.AddService(nc, micro.Config{
+		Name:    "test-module",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "module.run.>",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				var input ScheduleInput
+				if err := json.Unmarshal(r.Data(), &input); err != nil {
+					t.Errorf("failed to unmarshal input: %v", err)
+				}
+				received <- input
+				r.Respond([]byte(`"ok"`))
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to start module service: %v", err)
+	}
+	defer mod.Stop()
+
+	evt := &Event{Type: EventTypeSchedule, Subject: "every.minute", Module: "mod", Schedule: "* * * * *"}
+	if err := evt.Validate(); err != nil {
+		t.Fatalf("invalid event: %v", err)
+	}
+	if err := PutEvent(t.Context(), js, evt); err != nil {
+		t.Fatalf("failed to put event: %v", err)
+	}
+
+	// two schedulers share the markers, as if the server restarted or runs twice.
+	s1, err := newScheduler(nc, js)
+	if err != nil {
+		t.Fatalf("failed to create scheduler: %v", err)
+	}
+	s2, err := newScheduler(nc, js)
+	if err != nil {
+		t.Fatalf("failed to create scheduler: %v", err)
+	}
+
+	base := time.Date(2025, 3, 14, 10, 0, 30, 0, time.UTC)
+	tick := func(s *scheduler, now time.Time) {
+		t.Helper()
+		s.now = func() time.Time { return now }
+		if err := s.tick(t.Context()); err != nil {
+			t.Fatalf("tick failed: %v", err)
+		}
+	}
+	expectRuns := func(n int) {
+		t.Helper()
+		for range n {
+			select {
+			case input := <-received:
+				if input.Subject != "every.minute" {
+					t.Errorf("subject = %s", input.Subject)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("module was not run")
+			}
+		}
+		select {
+		case <-received:
+			t.Fatal("module was run too many times")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// the first tick only records the marker
+	tick(s1, base)
+	expectRuns(0)
+
+	// not due yet
+	tick(s1, base.Add(20*time.Second))
+	expectRuns(0)
+
+	// due, and the other scheduler must not fire it again
+	tick(s1, base.Add(40*time.Second))
+	tick(s2, base.Add(45*time.Second))
+	expectRuns(1)
+
+	// missed runs fire only once
+	tick(s2, base.Add(10*time.Minute))
+	expectRuns(1)
+
+	// the marker is removed with the event
+	if err := DeleteEvent(t.Context(), js, EventTypeSchedule, "every.minute"); err != nil {
+		t.Fatalf("failed to delete event: %v", err)
+	}
+	tick(s1, base.Add(11*time.Minute))
+	expectRuns(0)
+	if _, err := s1.kv.Get(t.Context(), "every.minute"); !errors.Is(err, jetstream.ErrKeyNotFound) {
+		t.Errorf("marker was not removed: %v", err)
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		evt     Event
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			evt:  Event{Type: EventTypeSchedule, Subject: "s", Module: "m", Schedule: "@daily", Timezone: "UTC"},
+		},
+		{
+			name:    "no schedule",
+			evt:     Event{Type: EventTypeSchedule, Subject: "s", Module: "m"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid cron",
+			evt:     Event{Type: EventTypeSchedule, Subject: "s", Module: "m", Schedule: "* *"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid timezone",
+			evt:     Event{Type: EventTypeSchedule, Subject: "s", Module: "m", Schedule: "@daily", Timezone: "Nowhere/City"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.evt.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
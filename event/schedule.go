@@ -0,0 +1,194 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jumonmd/jumon/internal/cron"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	scheduleBucket   = "schedule"
+	scheduleInterval = 10 * time.Second
+)
+
+// marker is the last run of a schedule event stored in the schedule key value store.
+// It is updated with the revision, so only one scheduler fires the event even after restarts
+// or with multiple servers.
+type marker struct {
+	Schedule string    `json:"schedule"`
+	Timezone string    `json:"timezone,omitempty"`
+	LastRun  time.Time `json:"last_run"`
+}
+
+// ScheduleInput is the input of the module run by a schedule event.
+type ScheduleInput struct {
+	Subject     string    `json:"subject"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+}
+
+// scheduler runs the modules of the schedule events.
+type scheduler struct {
+	nc   *nats.Conn
+	js   jetstream.JetStream
+	kv   jetstream.KeyValue
+	now  func() time.Time
+	quit chan struct{}
+	// wg waits for the ticker goroutine, not for the running modules.
+	wg sync.WaitGroup
+}
+
+func newScheduler(nc *nats.Conn, js jetstream.JetStream) (*scheduler, error) {
+	kv, err := js.CreateOrUpdateKeyValue(context.Background(), jetstream.KeyValueConfig{
+		Bucket:      scheduleBucket,
+		Description: "last runs of schedule events",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create key value store: %w", err)
+	}
+	return &scheduler{nc: nc, js: js, kv: kv, now: time.Now, quit: make(chan struct{})}, nil
+}
+
+// start checks the schedule events every scheduleInterval until stop is called.
+func (s *scheduler) start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(scheduleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.quit:
+				return
+			case <-ticker.C:
+				if err := s.tick(context.Background()); err != nil {
+					slog.Error("event schedule", "status", "tick failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func (s *scheduler) stop() {
+	close(s.quit)
+	s.wg.Wait()
+}
+
+// tick fires the due schedule events and removes the markers of deleted events.
+func (s *scheduler) tick(ctx context.Context) error {
+	events, err := ListEvents(ctx, s.js)
+	if err != nil {
+		return fmt.Errorf("list events: %w", err)
+	}
+
+	scheduled := map[string]bool{}
+	for _, evt := range events {
+		if evt.Type != EventTypeSchedule {
+			continue
+		}
+		scheduled[evt.Subject] = true
+		if err := s.check(ctx, &evt); err != nil {
+			slog.Error("event schedule", "status", "check failed", "subject", evt.Subject, "error", err)
+		}
+	}
+
+	keys, err := s.kv.Keys(ctx)
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("list markers: %w", err)
+	}
+	for _, key := range keys {
+		if scheduled[key] {
+			continue
+		}
+		if err := s.kv.Delete(ctx, key); err != nil {
+			return fmt.Errorf("delete marker: %w", err)
+		}
+	}
+	return nil
+}
+
+// check fires the event if the next run after the last run has come.
+// A new or changed schedule starts from now, and missed runs during downtime fire only once.
+func (s *scheduler) check(ctx context.Context, evt *Event) error {
+	now := s.now()
+	entry, err := s.kv.Get(ctx, evt.Subject)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		_, err := s.kv.Create(ctx, evt.Subject, newMarker(evt, now))
+		if err != nil && !errors.Is(err, jetstream.ErrKeyExists) {
+			return fmt.Errorf("create marker: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get marker: %w", err)
+	}
+
+	var m marker
+	if err := json.Unmarshal(entry.Value(), &m); err != nil {
+		return fmt.Errorf("unmarshal marker: %w", err)
+	}
+	if m.Schedule != evt.Schedule || m.Timezone != evt.Timezone {
+		_, err := s.kv.Update(ctx, evt.Subject, newMarker(evt, now), entry.Revision())
+		if err != nil && !errors.Is(err, jetstream.ErrKeyExists) {
+			return fmt.Errorf("reset marker: %w", err)
+		}
+		return nil
+	}
+
+	sched, err := cron.Parse(evt.Schedule)
+	if err != nil {
+		return fmt.Errorf("parse schedule: %w", err)
+	}
+	loc, err := time.LoadLocation(evt.Timezone)
+	if err != nil {
+		return fmt.Errorf("load timezone: %w", err)
+	}
+	next := sched.Next(m.LastRun.In(loc))
+	if next.IsZero() || next.After(now) {
+		return nil
+	}
+
+	// claim the run. it fails if another scheduler has updated the marker.
+	_, err = s.kv.Update(ctx, evt.Subject, newMarker(evt, now), entry.Revision())
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		slog.Debug("event schedule", "subject", evt.Subject, "status", "claimed by another scheduler")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("update marker: %w", err)
+	}
+
+	input, err := json.Marshal(ScheduleInput{Subject: evt.Subject, ScheduledAt: next})
+	if err != nil {
+		return fmt.Errorf("marshal input: %w", err)
+	}
+	slog.Info("event schedule", "subject", evt.Subject, "module", evt.Module, "scheduled_at", next)
+
+	go func() {
+		resp, err := runModule(context.Background(), s.nc, evt.Module, input)
+		if err != nil {
+			slog.Error("event schedule", "status", "run module failed", "subject", evt.Subject, "error", err)
+			return
+		}
+		slog.Info("event schedule", "subject", evt.Subject, "response", string(resp))
+	}()
+	return nil
+}
+
+func newMarker(evt *Event, lastRun time.Time) []byte {
+	data, _ := json.Marshal(marker{Schedule: evt.Schedule, Timezone: evt.Timezone, LastRun: lastRun})
+	return data
+}
@@ -9,22 +9,32 @@ import (
 	"fmt"
 	"log/slog"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jumonmd/jumon/internal/errors"
-	"github.com/jumonmd/jumon/module"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/jumonmd/jumon/tool"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/nats-io/nats.go/micro"
 )
 
-const subscribeSubject = "event"
+const (
+	subscribeSubject = "event"
+	runModuleTimeout = 10 * time.Minute
+)
 
 var (
 	// ErrValidateEvent is returned when event validation fails.
 	ErrValidateEvent = errors.New(400500, "validate event failed")
 	// ErrEventNotFound is returned when a requested event doesn't exist.
 	ErrEventNotFound = errors.New(404500, "event not found")
+	// ErrEventOwned is returned when an event owned by a module is changed by another caller.
+	ErrEventOwned = errors.New(409500, "event owned by another module")
+	// ErrRunModule is returned when the module of the event fails to run.
+	ErrRunModule = errors.New(500500, "run module failed")
 )
 
 var manageEndpoints = []string{
@@ -35,8 +45,10 @@ var manageEndpoints = []string{
 }
 
 type Service struct {
-	svc micro.Service
-	sub *nats.Subscription
+	svc       micro.Service
+	sub       *nats.Subscription
+	consumers *consumers
+	scheduler *scheduler
 }
 
 func (s *Service) AddEndpoint(name string, handler micro.Handler, opts ...micro.EndpointOpt) error {
@@ -63,6 +75,12 @@ func (s *Service) Stop() error {
 	if s.sub != nil {
 		s.sub.Unsubscribe()
 	}
+	if s.consumers != nil {
+		s.consumers.close()
+	}
+	if s.scheduler != nil {
+		s.scheduler.stop()
+	}
 	return s.svc.Stop()
 }
 
@@ -111,23 +129,43 @@ func NewService(nc *nats.Conn, js jetstream.JetStream) (micro.Service, error) {
 	}))
 
 	sub, err := nc.Subscribe(subscribeSubject+".>", func(msg *nats.Msg) {
-		if slices.Contains(manageEndpoints, msg.Subject) {
+		if slices.Contains(manageEndpoints, strings.TrimPrefix(msg.Subject, subscribeSubject+".")) {
 			return
 		}
 
-		ctx := context.Background()
-		err := subscribeMessage(ctx, nc, js, msg)
-		if err != nil {
-			slog.Error("event service", "status", "subscribe failed", "error", err)
-		}
+		// module runs may take long, so messages are handled concurrently.
+		go func() {
+			ctx := tracer.NewContext(msg.Header)
+			err := subscribeMessage(ctx, nc, js, msg)
+			if err != nil {
+				slog.Error("event service", "status", "subscribe failed", "error", err)
+			}
+		}()
 	})
 	if err != nil {
 		slog.Error("event service", "status", "subscribe failed", "error", err)
 		return nil, fmt.Errorf("subscribe: %w", err)
 	}
 
+	cons := newConsumers(nc, js)
+	err = cons.watch(context.Background())
+	if err != nil {
+		sub.Unsubscribe()
+		slog.Error("event service", "status", "watch events failed", "error", err)
+		return nil, fmt.Errorf("watch events: %w", err)
+	}
+
+	sched, err := newScheduler(nc, js)
+	if err != nil {
+		sub.Unsubscribe()
+		cons.close()
+		slog.Error("event service", "status", "setup scheduler failed", "error", err)
+		return nil, fmt.Errorf("setup scheduler: %w", err)
+	}
+	sched.start()
+
 	slog.Info("event service", "status", "started")
-	return &Service{svc: svc, sub: sub}, nil
+	return &Service{svc: svc, sub: sub, consumers: cons, scheduler: sched}, nil
 }
 
 func subscribeMessage(ctx context.Context, nc *nats.Conn, js jetstream.JetStream, msg *nats.Msg) error {
@@ -135,11 +173,25 @@ func subscribeMessage(ctx context.Context, nc *nats.Conn, js jetstream.JetStream
 	slog.Info("event", "subject", subject)
 
 	evt, err := GetEvent(ctx, js, EventTypeSubscribe, subject)
+	if notFound(err) {
+		// the subject may be consumed by a stream or not registered.
+		slog.Debug("event", "subject", subject, "status", "no subscribe event")
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("get event: %w", err)
 	}
+	if selfPublished(evt, msg.Header) {
+		return fmt.Errorf("module %s is subscribed to its own output: %s", evt.Module, subject)
+	}
 
-	resp, err := module.Run(ctx, nc, evt.Module, msg.Data)
+	resp, err := runModule(ctx, nc, evt.Module, msg.Data)
+	// the message sent as a request is replied with the module output.
+	if msg.Reply != "" {
+		if rerr := respondModule(msg, resp, err); rerr != nil {
+			slog.Error("event", "status", "respond failed", "subject", subject, "error", rerr)
+		}
+	}
 	if err != nil {
 		return fmt.Errorf("run module: %w", err)
 	}
@@ -149,6 +201,58 @@ func subscribeMessage(ctx context.Context, nc *nats.Conn, js jetstream.JetStream
 	return nil
 }
 
+// selfPublished reports whether the message is the output published by the module of the event.
+// Running the module with its own output would publish it again forever.
+func selfPublished(evt *Event, h nats.Header) bool {
+	from := h.Get(HeaderModule)
+	if from == "" {
+		return false
+	}
+	target := evt.Module
+	if !strings.Contains(target, "#") {
+		target += "#main"
+	}
+	return from == target
+}
+
+// respondModule responds to the event message with the module output or the error
+// in the same headers as the NATS micro service.
+func respondModule(msg *nats.Msg, resp []byte, err error) error {
+	if err == nil {
+		return msg.Respond(resp)
+	}
+	code, message := ErrRunModule.Code, err.Error()
+	var serr *errors.ServiceError
+	if errors.As(err, &serr) {
+		code, message = serr.Code, serr.Description
+	}
+	return msg.RespondMsg(&nats.Msg{
+		Header: nats.Header{
+			"Nats-Service-Error-Code": {strconv.Itoa(code)},
+			"Nats-Service-Error":      {message},
+		},
+	})
+}
+
+// runModule runs the module with the data using the module service.
+func runModule(ctx context.Context, nc *nats.Conn, modurl string, data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, runModuleTimeout)
+	defer cancel()
+
+	resp, err := nc.RequestMsgWithContext(ctx, &nats.Msg{
+		Subject: "module.run." + modurl,
+		Data:    data,
+		Header:  tracer.HeadersFromContext(ctx),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+	if err := errors.FromHeaders(resp.Header); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
 func putEventHandler(ctx context.Context, js jetstream.JetStream, r micro.Request) {
 	var input Event
 	if err := json.Unmarshal(r.Data(), &input); err != nil {
@@ -157,11 +261,32 @@ func putEventHandler(ctx context.Context, js jetstream.JetStream, r micro.Reques
 	}
 
 	evt := Event{
-		Type:    EventTypeSubscribe,
-		Subject: input.Subject,
-		Module:  input.Module,
+		Type:       input.Type,
+		Subject:    input.Subject,
+		Module:     input.Module,
+		MaxDeliver: input.MaxDeliver,
+		DeadLetter: input.DeadLetter,
+		Schedule:   input.Schedule,
+		Timezone:   input.Timezone,
+	}
+	if evt.Type == "" {
+		evt.Type = EventTypeSubscribe
+	}
+	if evt.Type == EventTypePublish {
+		r.Error(ErrValidateEvent.ServiceError(fmt.Errorf("publish events are defined in the module")))
+		return
+	}
+	if err := evt.Validate(); err != nil {
+		r.Error(ErrValidateEvent.ServiceError(err))
+		return
+	}
+	owner, err := checkOwner(ctx, js, r, evt.Type, evt.Subject)
+	if err != nil {
+		r.Error(ErrEventOwned.ServiceError(err))
+		return
 	}
-	err := PutEvent(ctx, js, &evt)
+	evt.Owner = owner
+	err = PutEvent(ctx, js, &evt)
 	if err != nil {
 		slog.Error("event service", "status", "put event failed", "error", err)
 		r.Error("500", "failed to put event", nil)
@@ -177,7 +302,10 @@ func getEventHandler(ctx context.Context, js jetstream.JetStream, r micro.Reques
 		return
 	}
 
-	evt, err := GetEvent(ctx, js, EventTypeSubscribe, input.Subject)
+	if input.Type == "" {
+		input.Type = EventTypeSubscribe
+	}
+	evt, err := GetEvent(ctx, js, input.Type, input.Subject)
 	if err != nil {
 		slog.Error("event service", "status", "get event failed", "error", err)
 		r.Error("404", "event not found", nil)
@@ -202,7 +330,14 @@ func deleteEventHandler(ctx context.Context, js jetstream.JetStream, r micro.Req
 		r.Error("403", "invalid payload", nil)
 		return
 	}
-	err := DeleteEvent(ctx, js, EventTypeSubscribe, input.Subject)
+	if input.Type == "" {
+		input.Type = EventTypeSubscribe
+	}
+	if _, err := checkOwner(ctx, js, r, input.Type, input.Subject); err != nil {
+		r.Error(ErrEventOwned.ServiceError(err))
+		return
+	}
+	err := DeleteEvent(ctx, js, input.Type, input.Subject)
 	if err != nil {
 		slog.Error("event service", "status", "delete event failed", "error", err)
 		r.Error("500", "failed to delete event", nil)
@@ -211,6 +346,29 @@ func deleteEventHandler(ctx context.Context, js jetstream.JetStream, r micro.Req
 	r.Respond(nil)
 }
 
+// checkOwner returns the owner of the stored event after checking that the request is made by it.
+// Events without an owner can be changed by any caller.
+func checkOwner(ctx context.Context, js jetstream.JetStream, r micro.Request, typ Type, subject string) (string, error) {
+	evt, err := GetEvent(ctx, js, typ, subject)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if evt.Owner == "" {
+		return "", nil
+	}
+	modname, err := tool.RequestModule(r)
+	if err != nil {
+		return "", err
+	}
+	if modname != evt.Owner {
+		return "", fmt.Errorf("%s %s of %q", typ, subject, evt.Owner)
+	}
+	return evt.Owner, nil
+}
+
 func setupKV(js jetstream.JetStream) (jetstream.KeyValue, error) {
 	return js.CreateOrUpdateKeyValue(context.Background(), jetstream.KeyValueConfig{
 		Bucket:      "event",
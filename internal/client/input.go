@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jumonmd/gengo/jsonschema"
+	"github.com/jumonmd/jumon/module"
+)
+
+// ReadInput returns the input of the run from the argument, the input file or stdin in this order.
+// stdin is read only when it is not a terminal. It is an error to give both the argument and the file.
+func ReadInput(arg, file string, stdin *os.File) ([]byte, error) {
+	if arg != "" && file != "" {
+		return nil, fmt.Errorf("both input and input file are given")
+	}
+	if arg != "" {
+		return []byte(arg), nil
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read input file: %w", err)
+		}
+		return data, nil
+	}
+	if stdin == nil {
+		return nil, nil
+	}
+	stat, err := stdin.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice != 0 {
+		return nil, nil
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return nil, fmt.Errorf("read stdin: %w", err)
+	}
+	return data, nil
+}
+
+// splitScriptName returns the module name and the script name of "module#script".
+// The script option overrides the script name in the module name.
+func splitScriptName(name, scriptOpt string) (string, string) {
+	name, scriptName, _ := strings.Cut(name, "#")
+	if scriptOpt != "" {
+		scriptName = scriptOpt
+	}
+	return name, scriptName
+}
+
+// runTarget returns the module URL of the script, and the input with the variables.
+func runTarget(mod *module.Module, scriptName string, input []byte, vars []string) (string, []byte, error) {
+	modurl := mod.Name
+	if scriptName != "" {
+		modurl += "#" + scriptName
+	}
+	scr := mod.GetScript(scriptName)
+	if scr == nil {
+		return "", nil, module.ErrScriptNotFound.Wrap(fmt.Errorf("script not found: %s", scriptName))
+	}
+	input, err := buildInput(input, vars, scr.InputSchema)
+	if err != nil {
+		return "", nil, module.ErrInvalidInput.Wrap(err)
+	}
+	return modurl, input, nil
+}
+
+// buildInput sets the key=value variables to the JSON object of the input.
+// The value is converted to the type of the property in the input schema, and is a string otherwise.
+// A dotted key such as "user.name" sets the nested property.
+func buildInput(input []byte, vars []string, schema jsonschema.Schema) ([]byte, error) {
+	if len(vars) == 0 {
+		return input, nil
+	}
+
+	obj := map[string]any{}
+	if len(strings.TrimSpace(string(input))) > 0 {
+		if err := json.Unmarshal(input, &obj); err != nil {
+			return nil, fmt.Errorf("input must be a JSON object to set variables: %w", err)
+		}
+	}
+
+	for _, v := range vars {
+		key, value, ok := strings.Cut(v, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid variable %q: expected key=value", v)
+		}
+		typed, err := varValue(value, propertyType(schema, key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid variable %s: %w", key, err)
+		}
+		if err := setPath(obj, strings.Split(key, "."), typed); err != nil {
+			return nil, fmt.Errorf("invalid variable %s: %w", key, err)
+		}
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("marshal input: %w", err)
+	}
+	return data, nil
+}
+
+// propertyType returns the type of the dotted property in the schema, or an empty string if unknown.
+func propertyType(schema jsonschema.Schema, key string) string {
+	var node any = map[string]any(schema)
+	for _, seg := range strings.Split(key, ".") {
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return ""
+		}
+		props, ok := obj["properties"].(map[string]any)
+		if !ok {
+			return ""
+		}
+		node = props[seg]
+	}
+	obj, ok := node.(map[string]any)
+	if !ok {
+		return ""
+	}
+	typ, _ := obj["type"].(string)
+	return typ
+}
+
+// varValue converts the value to the JSON schema type.
+func varValue(value, typ string) (any, error) {
+	switch typ {
+	case "integer":
+		return strconv.ParseInt(value, 10, 64)
+	case "number":
+		return strconv.ParseFloat(value, 64)
+	case "boolean":
+		return strconv.ParseBool(value)
+	case "object", "array", "null":
+		var v any
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", typ, err)
+		}
+		return v, nil
+	}
+	return value, nil
+}
+
+func setPath(obj map[string]any, path []string, value any) error {
+	if len(path) == 1 {
+		obj[path[0]] = value
+		return nil
+	}
+	child, ok := obj[path[0]]
+	if !ok {
+		child = map[string]any{}
+		obj[path[0]] = child
+	}
+	childObj, ok := child.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s is not an object", path[0])
+	}
+	return setPath(childObj, path[1:], value)
+}
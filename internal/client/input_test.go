@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jumonmd/gengo/jsonschema"
+)
+
+func TestBuildInput(t *testing.T) {
+	schema := jsonschema.Schema{
+		"type": "object",
+		"properties": map[string]any{
+			"name":  map[string]any{"type": "string"},
+			"count": map[string]any{"type": "integer"},
+			"draft": map[string]any{"type": "boolean"},
+			"tags":  map[string]any{"type": "array"},
+			"user": map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"age": map[string]any{"type": "number"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		vars    []string
+		want    string
+		wantErr bool
+	}{
+		{name: "no vars", input: "hello", want: "hello"},
+		{
+			name: "typed vars",
+			vars: []string{"name=42", "count=3", "draft=true", `tags=["a","b"]`, "user.age=20.5", "other=x"},
+			want: `{"count":3,"draft":true,"name":"42","other":"x","tags":["a","b"],"user":{"age":20.5}}`,
+		},
+		{name: "merge input", input: `{"name":"a","count":1}`, vars: []string{"count=2"}, want: `{"count":2,"name":"a"}`},
+		{name: "not an object", input: "hello", vars: []string{"name=a"}, wantErr: true},
+		{name: "invalid type", vars: []string{"count=many"}, wantErr: true},
+		{name: "no value", vars: []string{"name"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildInput([]byte(tt.input), tt.vars, schema)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildInput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("buildInput() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadInput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.json")
+	if err := os.WriteFile(path, []byte(`{"name":"jumon"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := ReadInput("hello", "", nil); err != nil || string(got) != "hello" {
+		t.Errorf("ReadInput(arg) = %q, %v", got, err)
+	}
+	if got, err := ReadInput("", path, nil); err != nil || string(got) != `{"name":"jumon"}` {
+		t.Errorf("ReadInput(file) = %q, %v", got, err)
+	}
+	if _, err := ReadInput("hello", path, nil); err == nil {
+		t.Error("expected error for both input and input file, got nil")
+	}
+
+	// stdin is a regular file, not a terminal
+	stdin, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	if got, err := ReadInput("", "", stdin); err != nil || string(got) != `{"name":"jumon"}` {
+		t.Errorf("ReadInput(stdin) = %q, %v", got, err)
+	}
+}
+
+func TestSplitScriptName(t *testing.T) {
+	tests := []struct {
+		name, opt            string
+		wantName, wantScript string
+	}{
+		{name: "./hello", wantName: "./hello"},
+		{name: "./hello#sub", wantName: "./hello", wantScript: "sub"},
+		{name: "./hello#sub", opt: "other", wantName: "./hello", wantScript: "other"},
+	}
+	for _, tt := range tests {
+		gotName, gotScript := splitScriptName(tt.name, tt.opt)
+		if gotName != tt.wantName || gotScript != tt.wantScript {
+			t.Errorf("splitScriptName(%q, %q) = %q, %q", tt.name, tt.opt, gotName, gotScript)
+		}
+	}
+}
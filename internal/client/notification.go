@@ -14,33 +14,119 @@ import (
 )
 
 // PrintNotifications subscribes to the notification subject and prints the notifications.
-func PrintNotifications(nc *nats.Conn, subject string, w io.Writer) (*nats.Subscription, error) {
-	return nc.Subscribe(subject, func(msg *nats.Msg) {
-		n := &tracer.Notification{}
-		err := json.Unmarshal(msg.Data, n)
+// If streamSubject is not empty, the chat stream deltas published to it are printed as they arrive,
+// and the step responses already printed by the deltas are not printed again.
+// It returns the function to unsubscribe.
+func PrintNotifications(nc *nats.Conn, subject, streamSubject string, w io.Writer) (func(), error) {
+	// both subjects share the channel to print in the order of arrival.
+	msgs := make(chan *nats.Msg, 256)
+	sub, err := nc.ChanSubscribe(subject, msgs)
+	if err != nil {
+		return nil, fmt.Errorf("subscribe notification: %w", err)
+	}
+	subs := []*nats.Subscription{sub}
+	if streamSubject != "" {
+		ssub, err := nc.ChanSubscribe(streamSubject, msgs)
 		if err != nil {
-			return
+			_ = sub.Unsubscribe()
+			return nil, fmt.Errorf("subscribe stream: %w", err)
 		}
+		subs = append(subs, ssub)
+	}
 
-		if n.Name == "script.step.run" {
-			switch n.On {
-			case "request":
-				printStepRequest(w, n.Content)
-			case "response":
-				printStepResponse(w, n.Content)
-			case "error":
-				printError(w, n.Content)
+	done := make(chan struct{})
+	go func() {
+		p := &printer{w: w}
+		for {
+			select {
+			case <-done:
+				return
+			case msg := <-msgs:
+				if msg.Subject == streamSubject {
+					p.printStream(msg.Data)
+					continue
+				}
+				p.printNotification(msg.Data)
 			}
 		}
-		if n.Name == "script.run" {
-			switch n.On {
-			case "response":
-				printScriptResponse(w, n.Content)
-			case "error":
-				printError(w, n.Content)
+	}()
+
+	return func() {
+		for _, s := range subs {
+			_ = s.Unsubscribe()
+		}
+		close(done)
+	}, nil
+}
+
+// printer prints the notifications of a run.
+type printer struct {
+	w io.Writer
+	// streamed is true while the deltas of the current step response are being printed.
+	streamed bool
+}
+
+func (p *printer) printNotification(data []byte) {
+	n := &tracer.Notification{}
+	err := json.Unmarshal(data, n)
+	if err != nil {
+		return
+	}
+
+	if n.Name == "script.step.run" {
+		switch n.On {
+		case "request":
+			printStepRequest(p.w, n.Content)
+		case "response":
+			if p.streamed {
+				fmt.Fprintln(p.w)
+				p.streamed = false
+				return
 			}
+			printStepResponse(p.w, n.Content)
+		case "error":
+			p.endStream()
+			printError(p.w, n.Content)
+		}
+	}
+	if n.Name == "script.run" {
+		p.endStream()
+		switch n.On {
+		case "response":
+			printScriptResponse(p.w, n.Content)
+		case "error":
+			printError(p.w, n.Content)
+		case "cancelled":
+			fmt.Fprintln(p.w, "Cancelled: ", n.Content)
 		}
-	})
+	}
+}
+
+// printStream prints the content delta of the chat stream response.
+func (p *printer) printStream(data []byte) {
+	resp := &chat.StreamResponse{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return
+	}
+	if resp.Type != "" && resp.Type != "text" {
+		return
+	}
+	if resp.Content == "" {
+		return
+	}
+	if !p.streamed {
+		fmt.Fprint(p.w, "  ")
+		p.streamed = true
+	}
+	fmt.Fprint(p.w, resp.Content)
+}
+
+// endStream ends the line of the interrupted stream.
+func (p *printer) endStream() {
+	if p.streamed {
+		fmt.Fprintln(p.w)
+		p.streamed = false
+	}
 }
 
 func printScriptResponse(w io.Writer, s string) {
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/internal/testutil"
+	"github.com/jumonmd/jumon/internal/tracer"
+)
+
+// syncBuffer is a buffer safe for the concurrent write and read.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestPrintNotifications(t *testing.T) {
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("Failed to setup NATS server: %v", err)
+	}
+	defer cleanup()
+
+	notify := func(name, on string, content any) {
+		data, _ := json.Marshal(content)
+		n, _ := json.Marshal(tracer.Notification{Name: name, On: on, Content: string(data)})
+		if err := nc.Publish("notification.n1", n); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	stream := func(content string) {
+		data, _ := json.Marshal(chat.StreamResponse{Type: "text", Content: content})
+		if err := nc.Publish("_INBOX.s1", data); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+	resp := chat.Response{Messages: []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, "Hello, world")}}
+	req := chat.Request{Messages: []chat.Message{chat.NewTextMessage(chat.MessageRoleHuman, "1. Say hello")}}
+
+	tests := []struct {
+		name          string
+		streamSubject string
+		want          string
+	}{
+		{name: "stream", streamSubject: "_INBOX.s1", want: "  HUMAN: 1. Say hello\n  Hello, world\n"},
+		{name: "no stream", want: "  HUMAN: 1. Say hello\n  AI: Hello, world\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &syncBuffer{}
+			stop, err := PrintNotifications(nc, "notification.n1", tt.streamSubject, w)
+			if err != nil {
+				t.Fatalf("PrintNotifications() error = %v", err)
+			}
+			defer stop()
+
+			notify("script.step.run", "request", req)
+			stream("Hello, ")
+			stream("world")
+			notify("script.step.run", "response", resp)
+			if err := nc.Flush(); err != nil {
+				t.Fatalf("flush: %v", err)
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for w.String() != tt.want && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := w.String(); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/jumonmd/jumon/internal/errors"
+)
+
+// Output modes of the final output.
+const (
+	// OutputJSON prints the output as indented JSON.
+	OutputJSON = "json"
+	// OutputText prints a string output without quotes, and other outputs as JSON.
+	OutputText = "text"
+	// OutputRaw prints the output as it is returned.
+	OutputRaw = "raw"
+)
+
+// Exit codes of the CLI by the service error code family.
+const (
+	ExitOK       = 0
+	ExitError    = 1
+	ExitInvalid  = 3 // 400: invalid input, module or script
+	ExitNotFound = 4 // 404: module, script or run not found
+	ExitConflict = 5 // 409: the run state does not allow the operation
+	ExitFailed   = 6 // 500: the run failed
+)
+
+// printOutput prints the final output in the output mode.
+func printOutput(w io.Writer, output json.RawMessage, mode string) error {
+	switch mode {
+	case OutputRaw:
+		_, err := w.Write(output)
+		return err
+	case OutputText:
+		var s string
+		if err := json.Unmarshal(output, &s); err == nil {
+			_, err := fmt.Fprintln(w, s)
+			return err
+		}
+		_, err := fmt.Fprintln(w, string(output))
+		return err
+	case OutputJSON:
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, output, "", "  "); err != nil {
+			return fmt.Errorf("indent output: %w", err)
+		}
+		_, err := fmt.Fprintln(w, buf.String())
+		return err
+	}
+	return fmt.Errorf("unknown output mode: %s", mode)
+}
+
+// ExitCode returns the exit code of the error by the family of the first service error code in the chain.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var serr *errors.ServiceError
+	if !errors.As(err, &serr) {
+		return ExitError
+	}
+	switch serr.Code / 1000 {
+	case 400:
+		return ExitInvalid
+	case 404:
+		return ExitNotFound
+	case 409:
+		return ExitConflict
+	case 500:
+		return ExitFailed
+	}
+	return ExitError
+}
@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/jumonmd/jumon/module"
+	"github.com/nats-io/nats.go"
+)
+
+func TestPrintOutput(t *testing.T) {
+	tests := []struct {
+		output string
+		mode   string
+		want   string
+	}{
+		{output: `"hello\nworld"`, mode: OutputText, want: "hello\nworld\n"},
+		{output: `{"a":1}`, mode: OutputText, want: "{\"a\":1}\n"},
+		{output: `{"a":1}`, mode: OutputJSON, want: "{\n  \"a\": 1\n}\n"},
+		{output: `"hello"`, mode: OutputRaw, want: `"hello"`},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := printOutput(&buf, []byte(tt.output), tt.mode); err != nil {
+			t.Fatalf("printOutput() error = %v", err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("printOutput(%s, %s) = %q, want %q", tt.output, tt.mode, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{err: nil, want: ExitOK},
+		{err: fmt.Errorf("unknown"), want: ExitError},
+		{err: fmt.Errorf("run module: %w", module.ErrInvalidInput.Wrap(fmt.Errorf("bad"))), want: ExitInvalid},
+		{err: module.ErrScriptNotFound.Wrap(fmt.Errorf("main")), want: ExitNotFound},
+		{err: fmt.Errorf("resume run: %w", errors.FromHeaders(serviceError("409401", "run already succeeded"))), want: ExitConflict},
+		{err: fmt.Errorf("run module: run step: %w", errors.FromHeaders(serviceError("500102", "verify failed"))), want: ExitFailed},
+		{err: fmt.Errorf("run module: 500102: verify failed"), want: ExitError},
+	}
+	for _, tt := range tests {
+		if got := ExitCode(tt.err); got != tt.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func serviceError(code, message string) nats.Header {
+	return nats.Header{
+		"Nats-Service-Error-Code": {code},
+		"Nats-Service-Error":      {message},
+	}
+}
@@ -6,22 +6,42 @@ package client
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/jumonmd/jumon/internal/errors"
 	"github.com/jumonmd/jumon/internal/logger"
 	"github.com/jumonmd/jumon/internal/server"
 	"github.com/jumonmd/jumon/internal/tracer"
 	"github.com/jumonmd/jumon/module"
+	"github.com/jumonmd/jumon/script"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/nats-io/nats.go/micro"
 )
 
+// RunOptions is the options of running a module.
+type RunOptions struct {
+	// Stream prints the model responses as they are generated.
+	Stream bool
+	// Files are the paths of the files attached to the first message.
+	// A path can be named as "name=path", and the base name of the path is used otherwise.
+	Files []string
+	// Script is the name of the script to run. The name can also be given as "module#script".
+	Script string
+	// Vars are the key=value variables set to the JSON object of the input.
+	Vars []string
+	// Output is the output mode of the final output. e.g. "json", "text" or "raw".
+	// If it is set, the progress is printed to stderr and only the final output to stdout.
+	Output string
+}
+
 // Run is the main entry point for running a module.
-func Run(name string, input []byte) error {
+func Run(name string, input []byte, opts RunOptions) error {
 	cfg, err := LoadConfig(DefaultConfigPath())
 	if err != nil {
 		return fmt.Errorf("load client config: %w", err)
@@ -39,28 +59,65 @@ func Run(name string, input []byte) error {
 	// Prepare context with timeout
 	ctx, cancel := notifyContext(cfg)
 	defer cancel()
+	if opts.Stream {
+		ctx = tracer.WithStreamTo(ctx, nats.NewInbox())
+	}
+
+	files, err := readFiles(opts.Files)
+	if err != nil {
+		return err
+	}
 
 	// Setup notification
-	err = subscribeNotification(ctx, nc)
+	var progress io.Writer = os.Stdout
+	if opts.Output != "" {
+		progress = os.Stderr
+	}
+	err = subscribeNotification(ctx, nc, progress)
 	if err != nil {
 		slog.Warn("setup notification", "error", err)
 	}
 
 	// Get module to the system
+	name, scriptName := splitScriptName(name, opts.Script)
 	mod, err := getModule(ctx, js, name)
 	if err != nil {
-		return fmt.Errorf("get module: %w", err)
+		return module.ErrModuleNotFound.Wrap(fmt.Errorf("get module: %w", err))
+	}
+	modurl, input, err := runTarget(mod, scriptName, input, opts.Vars)
+	if err != nil {
+		return err
 	}
 
 	// Run the module
-	_, err = module.Run(ctx, nc, mod.Name, input)
+	output, err := module.Run(ctx, nc, modurl, input, files...)
 	if err != nil {
 		return fmt.Errorf("run module: %w", err)
 	}
 
+	if opts.Output != "" {
+		return printOutput(os.Stdout, output, opts.Output)
+	}
 	return nil
 }
 
+// readFiles reads the files to attach. A path can be named as "name=path".
+func readFiles(paths []string) ([]script.File, error) {
+	scr := &script.Script{}
+	for _, p := range paths {
+		name, path, ok := strings.Cut(p, "=")
+		if !ok {
+			name, path = filepath.Base(p), p
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read file: %w", err)
+		}
+		scr.AddFile(name, data)
+	}
+	return scr.Files, nil
+}
+
 // setupServices initializes all required services (NATS, logger, local service)
 // and returns a cleanup function.
 func setupServices(cfg *Config, isDebug bool) (nc *nats.Conn, js jetstream.JetStream, localSvc micro.Service, cleanup func(), err error) {
@@ -100,7 +157,8 @@ func notifyContext(cfg *Config) (context.Context, context.CancelFunc) {
 }
 
 // subscribeNotification configures notification subscription.
-func subscribeNotification(ctx context.Context, nc *nats.Conn) error {
+// The chat stream is also printed if the context has the stream-to subject.
+func subscribeNotification(ctx context.Context, nc *nats.Conn, w io.Writer) error {
 	notifyTo := tracer.ContextValueNotifyTo(ctx)
 	if notifyTo == "" {
 		slog.Warn("empty notification ID")
@@ -109,7 +167,7 @@ func subscribeNotification(ctx context.Context, nc *nats.Conn) error {
 
 	slog.Info("notify to", "notifyTo", notifyTo)
 
-	sub, err := PrintNotifications(nc, "notification."+notifyTo, os.Stdout)
+	stop, err := PrintNotifications(nc, "notification."+notifyTo, tracer.ContextValueStreamTo(ctx), w)
 	if err != nil {
 		return err
 	}
@@ -117,7 +175,7 @@ func subscribeNotification(ctx context.Context, nc *nats.Conn) error {
 	// Ensure subscription is cleaned up when context is done
 	go func() {
 		<-ctx.Done()
-		sub.Unsubscribe()
+		stop()
 	}()
 
 	return nil
@@ -125,23 +183,18 @@ func subscribeNotification(ctx context.Context, nc *nats.Conn) error {
 
 // getModule resolves and retrieves a module either from local directory or git.
 func getModule(ctx context.Context, js jetstream.JetStream, name string) (*module.Module, error) {
-	// Get module key-value store
-	modkv, err := js.KeyValue(ctx, "module")
-	if err != nil {
-		return nil, fmt.Errorf("kv create: %w", err)
-	}
-
 	// Resolve module by name (either local path or git repository)
 	var mod *module.Module
+	var err error
 	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, ".") {
 		// Local module
-		mod, err = module.GetByDir(ctx, modkv, name)
+		mod, err = module.GetByDir(ctx, js, name)
 		if err != nil {
 			return nil, fmt.Errorf("get dir failed: %w", err)
 		}
 	} else {
 		// Remote module
-		mod, err = module.GetByGit(ctx, modkv, name)
+		mod, err = module.GetByGit(ctx, js, name)
 		if err != nil {
 			return nil, fmt.Errorf("get git failed: %w", err)
 		}
@@ -163,9 +216,8 @@ func executeModule(ctx context.Context, nc *nats.Conn, mod *module.Module, input
 	}
 
 	// Check for errors in response
-	if errorCode := resp.Header.Get("Nats-Service-Error-Code"); errorCode != "" {
-		errorMessage := resp.Header.Get("Nats-Service-Error")
-		return fmt.Errorf("module run error: %s: %s", errorCode, errorMessage)
+	if err := errors.FromHeaders(resp.Header); err != nil {
+		return fmt.Errorf("module run error: %w", err)
 	}
 
 	// Log success
@@ -5,6 +5,7 @@ package client
 
 import (
 	"context"
+	"io"
 	"testing"
 	"time"
 
@@ -116,7 +117,7 @@ func TestSetupNotification(t *testing.T) {
 	ctx, _ := tracer.CreateNotifyContext()
 
 	// Setup notification
-	err = subscribeNotification(ctx, nc)
+	err = subscribeNotification(ctx, nc, io.Discard)
 	if err != nil {
 		t.Errorf("Failed to setup notification: %v", err)
 	}
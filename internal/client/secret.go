@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jumonmd/jumon/internal/secret"
+	"github.com/nats-io/nats.go"
+)
+
+// SecretSet sends the secret to the server, which seals it with its master key.
+// The value is read from stdin when it is not given, so that it is not left in the shell history.
+// e.g. "jumon secret set OPENAI_API_KEY < key.txt".
+// The modules are the module names which can refer the secret in their tool arguments.
+func SecretSet(name, value string, modules []string, stdin *os.File) error {
+	if err := secret.ValidateName(name); err != nil {
+		return err
+	}
+	if value == "" {
+		data, err := ReadInput("", "", stdin)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+	}
+	if value == "" {
+		return fmt.Errorf("secret value is required: give it as an argument or from stdin")
+	}
+
+	data, err := json.Marshal(secret.SetRequest{Name: name, Value: value, Modules: modules})
+	if err != nil {
+		return fmt.Errorf("marshal secret: %w", err)
+	}
+	return withServer(func(ctx context.Context, nc *nats.Conn) error {
+		_, err := request(ctx, nc, "secret.set", data)
+		return err
+	})
+}
+
+// SecretList prints the names of the secrets with the modules which they are granted to.
+// The values are never printed.
+func SecretList() error {
+	return withServer(func(ctx context.Context, nc *nats.Conn) error {
+		data, err := request(ctx, nc, "secret.list", nil)
+		if err != nil {
+			return err
+		}
+		entries := []secret.Entry{}
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("unmarshal secrets: %w", err)
+		}
+		printSecretList(os.Stdout, entries)
+		return nil
+	})
+}
+
+// SecretRm deletes the secret.
+func SecretRm(name string) error {
+	return withServer(func(ctx context.Context, nc *nats.Conn) error {
+		_, err := request(ctx, nc, "secret.delete", []byte(name))
+		return err
+	})
+}
+
+func printSecretList(w io.Writer, entries []secret.Entry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tMODULES")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\n", e.Name, strings.Join(e.Modules, ","))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jumonmd/jumon/internal/config"
+	"github.com/nats-io/nats.go"
+)
+
+// ConfigGet prints the server config value of the key resolved in the scope, e.g. "module=<name>".
+func ConfigGet(name, scope string) error {
+	return withServerConfig(name, scope, func(ctx context.Context, nc *nats.Conn, key config.Key, s config.Scope) error {
+		value, err := config.Get(ctx, nc, key, s)
+		if err != nil {
+			return err
+		}
+		fmt.Println(value)
+		return nil
+	})
+}
+
+// ConfigSet sets the server config value of the key in the scope.
+func ConfigSet(name, value, scope string) error {
+	return withServerConfig(name, scope, func(ctx context.Context, nc *nats.Conn, key config.Key, s config.Scope) error {
+		return config.Set(ctx, nc, key, value, s)
+	})
+}
+
+// ConfigUnset deletes the server config value of the key in the scope.
+func ConfigUnset(name, scope string) error {
+	return withServerConfig(name, scope, func(ctx context.Context, nc *nats.Conn, key config.Key, s config.Scope) error {
+		return config.Unset(ctx, nc, key, s)
+	})
+}
+
+// ConfigList prints all the server config keys with their defaults and the values set in the scopes.
+func ConfigList() error {
+	return withServer(func(ctx context.Context, nc *nats.Conn) error {
+		entries, err := config.List(ctx, nc)
+		if err != nil {
+			return err
+		}
+		printConfigList(os.Stdout, entries)
+		return nil
+	})
+}
+
+func printConfigList(w io.Writer, entries []config.Entry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSCOPE\tVALUE\tDESCRIPTION")
+	for _, spec := range config.Specs() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", spec.Key, "default", spec.Default, spec.Description)
+		for _, e := range entries {
+			if e.Key == spec.Key {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t\n", e.Key, e.Scope, e.Value)
+			}
+		}
+	}
+	tw.Flush()
+}
+
+// withServerConfig connects to the server and calls fn with the parsed key and scope.
+func withServerConfig(name, scope string, fn func(ctx context.Context, nc *nats.Conn, key config.Key, s config.Scope) error) error {
+	key, err := config.ParseKey(name)
+	if err != nil {
+		return err
+	}
+	s, err := config.ParseScope(scope)
+	if err != nil {
+		return err
+	}
+
+	return withServer(func(ctx context.Context, nc *nats.Conn) error {
+		return fn(ctx, nc, key, s)
+	})
+}
+
+// withServer connects to the server and calls fn.
+func withServer(fn func(ctx context.Context, nc *nats.Conn) error) error {
+	cfg, err := LoadConfig(DefaultConfigPath())
+	if err != nil {
+		return fmt.Errorf("load client config: %w", err)
+	}
+
+	nc, _, _, cleanup, err := setupServices(cfg, os.Getenv("JUMON_DEBUG") == "1")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	return fn(ctx, nc)
+}
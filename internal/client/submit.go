@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/jumonmd/jumon/module"
+	"github.com/nats-io/nats.go"
+)
+
+const requestTimeout = 10 * time.Second
+
+// Submit submits the module to run asynchronously and prints the run ID.
+// The script and the variables of the options are used, and the others are ignored.
+func Submit(name string, input []byte, opts RunOptions) error {
+	cfg, err := LoadConfig(DefaultConfigPath())
+	if err != nil {
+		return fmt.Errorf("load client config: %w", err)
+	}
+
+	nc, js, _, cleanup, err := setupServices(cfg, os.Getenv("JUMON_DEBUG") == "1")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RunTimeoutDuration())
+	defer cancel()
+
+	name, scriptName := splitScriptName(name, opts.Script)
+	mod, err := getModule(ctx, js, name)
+	if err != nil {
+		return module.ErrModuleNotFound.Wrap(fmt.Errorf("get module: %w", err))
+	}
+	modurl, input, err := runTarget(mod, scriptName, input, opts.Vars)
+	if err != nil {
+		return err
+	}
+
+	runID, err := submitModule(ctx, nc, modurl, input)
+	if err != nil {
+		return err
+	}
+	fmt.Println(runID)
+	return nil
+}
+
+// Status prints the state of the run, and the output if the run has finished.
+func Status(id string) error {
+	cfg, err := LoadConfig(DefaultConfigPath())
+	if err != nil {
+		return fmt.Errorf("load client config: %w", err)
+	}
+
+	nc, _, _, cleanup, err := setupServices(cfg, os.Getenv("JUMON_DEBUG") == "1")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	rec, err := runStatus(ctx, nc, id)
+	if err != nil {
+		return err
+	}
+	printStatus(os.Stdout, rec)
+
+	if rec.State == module.RunStateSucceeded {
+		output, err := request(ctx, nc, "module.result."+id, nil)
+		if err != nil {
+			return fmt.Errorf("get result: %w", err)
+		}
+		fmt.Fprintln(os.Stdout, "Output:", string(output))
+	}
+	return nil
+}
+
+// Resume resumes the failed or interrupted run from its checkpoint and prints the run ID.
+func Resume(id string) error {
+	cfg, err := LoadConfig(DefaultConfigPath())
+	if err != nil {
+		return fmt.Errorf("load client config: %w", err)
+	}
+
+	nc, _, _, cleanup, err := setupServices(cfg, os.Getenv("JUMON_DEBUG") == "1")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	data, err := request(ctx, nc, "module.resume."+id, nil)
+	if err != nil {
+		return fmt.Errorf("resume run: %w", err)
+	}
+	var resp module.SubmitResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return fmt.Errorf("unmarshal resume response: %w", err)
+	}
+	fmt.Println(resp.RunID)
+	return nil
+}
+
+// Cancel requests to cancel the run, including the chat, tool and script requests of the run.
+func Cancel(id string) error {
+	cfg, err := LoadConfig(DefaultConfigPath())
+	if err != nil {
+		return fmt.Errorf("load client config: %w", err)
+	}
+
+	nc, _, _, cleanup, err := setupServices(cfg, os.Getenv("JUMON_DEBUG") == "1")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	if _, err := request(ctx, nc, "module.cancel."+id, nil); err != nil {
+		return fmt.Errorf("cancel run: %w", err)
+	}
+	fmt.Println("cancel requested:", id)
+	return nil
+}
+
+func submitModule(ctx context.Context, nc *nats.Conn, name string, input []byte) (string, error) {
+	data, err := request(ctx, nc, "module.submit."+name, input)
+	if err != nil {
+		return "", fmt.Errorf("submit module: %w", err)
+	}
+	var resp module.SubmitResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", fmt.Errorf("unmarshal submit response: %w", err)
+	}
+	return resp.RunID, nil
+}
+
+func runStatus(ctx context.Context, nc *nats.Conn, id string) (*module.RunRecord, error) {
+	data, err := request(ctx, nc, "module.status."+id, nil)
+	if err != nil {
+		return nil, fmt.Errorf("get status: %w", err)
+	}
+	rec := &module.RunRecord{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, fmt.Errorf("unmarshal status: %w", err)
+	}
+	return rec, nil
+}
+
+func printStatus(w io.Writer, rec *module.RunRecord) {
+	fmt.Fprintln(w, "Run:", rec.ID)
+	fmt.Fprintln(w, "Module:", rec.Module)
+	fmt.Fprintln(w, "State:", rec.State)
+	fmt.Fprintln(w, "Created:", rec.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintln(w, "Updated:", rec.UpdatedAt.Format(time.RFC3339))
+	if rec.State == module.RunStateFailed || rec.State == module.RunStateCancelled {
+		fmt.Fprintf(w, "Error: %d: %s\n", rec.ErrorCode, rec.Error)
+	}
+}
+
+// request sends the request to the service and returns the response data or the service error.
+func request(ctx context.Context, nc *nats.Conn, subject string, data []byte) ([]byte, error) {
+	resp, err := nc.RequestWithContext(ctx, subject, data)
+	if err != nil {
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+	if err := errors.FromHeaders(resp.Header); err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
@@ -7,59 +7,300 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jumonmd/jumon/internal/secret"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
-type key string
+// Key is the name of a config value in the config key value store.
+type Key string
 
 const (
 	// DefaultModel is the default model to use.
-	DefaultModel key = "DefaultModel"
+	DefaultModel Key = "DefaultModel"
 	// DefaultVerifyModel is the default verify model to use.
-	DefaultVerifyModel key = "DefaultVerifyModel"
+	DefaultVerifyModel Key = "DefaultVerifyModel"
+	// OpenAIBaseURL is the base URL of the OpenAI compatible API.
+	OpenAIBaseURL Key = "OpenAIBaseURL"
+	// AnthropicBaseURL is the base URL of the Anthropic API.
+	AnthropicBaseURL Key = "AnthropicBaseURL"
+	// GeminiBaseURL is the base URL of the Gemini API.
+	GeminiBaseURL Key = "GeminiBaseURL"
+	// ChatTimeout is the timeout of a chat generation. 0 is no timeout.
+	ChatTimeout Key = "ChatTimeout"
+	// ToolTimeout is the timeout of a tool run. 0 is no timeout.
+	// The value of the module scope is used for the tools run by the module.
+	ToolTimeout Key = "ToolTimeout"
+	// MaxToolCalls is the maximum number of tool calls run concurrently in a step
+	// of the scripts without their own limit.
+	MaxToolCalls Key = "MaxToolCalls"
 )
 
-func Get(ctx context.Context, nc *nats.Conn, key key) (string, error) {
+// Kind is the type of a config value.
+type Kind string
+
+const (
+	KindString   Kind = "string"
+	KindURL      Kind = "url"
+	KindDuration Kind = "duration"
+	KindInt      Kind = "int"
+)
+
+// Spec is the definition of a config key.
+type Spec struct {
+	Key  Key
+	Kind Kind
+	// Scoped reports whether the key can be set for a module.
+	Scoped      bool
+	Default     string
+	Description string
+}
+
+var specs = []Spec{
+	{Key: DefaultModel, Kind: KindString, Scoped: true, Default: "gpt-4o", Description: "default model of the scripts"},
+	{Key: DefaultVerifyModel, Kind: KindString, Default: "gpt-4o-mini", Description: "model to verify the checks"},
+	{Key: OpenAIBaseURL, Kind: KindURL, Description: "base URL of the OpenAI compatible API"},
+	{Key: AnthropicBaseURL, Kind: KindURL, Description: "base URL of the Anthropic API"},
+	{Key: GeminiBaseURL, Kind: KindURL, Description: "base URL of the Gemini API"},
+	{Key: ChatTimeout, Kind: KindDuration, Default: "0s", Description: "timeout of a chat generation, 0 is no timeout"},
+	{Key: ToolTimeout, Kind: KindDuration, Scoped: true, Default: "0s", Description: "timeout of a tool run, 0 is no timeout"},
+	{Key: MaxToolCalls, Kind: KindInt, Scoped: true, Default: "4", Description: "maximum concurrent tool calls in a step"},
+}
+
+// Specs returns the definitions of all the config keys.
+func Specs() []Spec {
+	return specs
+}
+
+// ParseKey returns the config key of the name. The name is case insensitive.
+func ParseKey(name string) (Key, error) {
+	for _, s := range specs {
+		if strings.EqualFold(string(s.Key), name) {
+			return s.Key, nil
+		}
+	}
+	return "", fmt.Errorf("unknown config key: %s", name)
+}
+
+func (k Key) spec() Spec {
+	for _, s := range specs {
+		if s.Key == k {
+			return s
+		}
+	}
+	return Spec{Key: k, Kind: KindString}
+}
+
+// Validate checks that the value is of the kind of the key.
+func (k Key) Validate(value string) error {
+	switch k.spec().Kind {
+	case KindURL:
+		// the URL can have secret references such as "{{secret.GATEWAY_KEY}}" resolved on use.
+		u, err := url.Parse(secret.MaskRefs(value))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%s must be an absolute URL: %s", k, value)
+		}
+	case KindDuration:
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("%s must be a duration such as 30s or 5m: %s", k, value)
+		}
+	case KindInt:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("%s must be a positive integer: %s", k, value)
+		}
+	default:
+		if value == "" {
+			return fmt.Errorf("%s must not be empty", k)
+		}
+	}
+	return nil
+}
+
+// validName matches the names which can be a part of the key value store key.
+var validName = regexp.MustCompile(`^[-/_=.a-zA-Z0-9]+$`)
+
+// Scope narrows a config value to a module. The zero value is the global scope.
+type Scope struct {
+	Module string
+}
+
+// ModuleScope returns the scope of the module.
+func ModuleScope(name string) Scope {
+	return Scope{Module: name}
+}
+
+// ParseScope parses the scope such as "module=jumonmd/jumon/example/hello".
+// An empty string is the global scope.
+func ParseScope(s string) (Scope, error) {
+	if s == "" {
+		return Scope{}, nil
+	}
+	kind, name, ok := strings.Cut(s, "=")
+	if !ok || kind != "module" || !validName.MatchString(name) || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") {
+		return Scope{}, fmt.Errorf("invalid scope: %s: expected module=<name>", s)
+	}
+	return Scope{Module: name}, nil
+}
+
+func (s Scope) String() string {
+	if s.Module == "" {
+		return "global"
+	}
+	return "module=" + s.Module
+}
+
+// kvKey returns the key value store key of the config key in the scope.
+// e.g. "DefaultModel" or "DefaultModel.module.jumonmd/jumon/example/hello".
+func (s Scope) kvKey(k Key) string {
+	if s.Module == "" {
+		return string(k)
+	}
+	return string(k) + ".module." + s.Module
+}
+
+// Get returns the config value of the key. The value of the first scope which has it is returned,
+// and then the global value, or the default value if the key is not set.
+func Get(ctx context.Context, nc *nats.Conn, key Key, scopes ...Scope) (string, error) {
 	kv, err := keyvalue(ctx, nc)
 	if err != nil {
 		return "", fmt.Errorf("key value store: %w", err)
 	}
 
-	val, err := kv.Get(ctx, string(key))
-	if errors.Is(err, jetstream.ErrKeyNotFound) {
-		return defaultConfig(key), nil
+	for _, scope := range append(scopes, Scope{}) {
+		val, err := kv.Get(ctx, scope.kvKey(key))
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("get config: %w", err)
+		}
+		return string(val.Value()), nil
 	}
+	return defaultConfig(key), nil
+}
+
+// GetDuration returns the config value of the duration key.
+func GetDuration(ctx context.Context, nc *nats.Conn, key Key, scopes ...Scope) (time.Duration, error) {
+	val, err := Get(ctx, nc, key, scopes...)
+	if err != nil || val == "" {
+		return 0, err
+	}
+	d, err := time.ParseDuration(val)
 	if err != nil {
-		return "", fmt.Errorf("get config: %w", err)
+		return 0, fmt.Errorf("parse config %s: %w", key, err)
 	}
+	return d, nil
+}
 
-	return string(val.Value()), nil
+// GetInt returns the config value of the int key.
+func GetInt(ctx context.Context, nc *nats.Conn, key Key, scopes ...Scope) (int, error) {
+	val, err := Get(ctx, nc, key, scopes...)
+	if err != nil || val == "" {
+		return 0, err
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("parse config %s: %w", key, err)
+	}
+	return n, nil
 }
 
-func Set(ctx context.Context, nc *nats.Conn, key key, value string) error {
+// Set validates and sets the config value of the key in the scope.
+// Only the scoped keys can be set for a module, because the others are read in the global scope.
+func Set(ctx context.Context, nc *nats.Conn, key Key, value string, scopes ...Scope) error {
+	if err := key.Validate(value); err != nil {
+		return err
+	}
+	if s := scope(scopes); s.Module != "" && !key.spec().Scoped {
+		return fmt.Errorf("%s can not be set for a module: %s", key, s)
+	}
 	kv, err := keyvalue(ctx, nc)
 	if err != nil {
 		return fmt.Errorf("key value store: %w", err)
 	}
 
-	_, err = kv.Put(ctx, string(key), []byte(value))
+	_, err = kv.Put(ctx, scope(scopes).kvKey(key), []byte(value))
 	if err != nil {
 		return fmt.Errorf("set config: %w", err)
 	}
 	return nil
 }
 
-func defaultConfig(key key) string {
-	switch key {
-	case DefaultModel:
-		return "gpt-4o"
-	case DefaultVerifyModel:
-		return "gpt-4o-mini"
-	default:
-		return ""
+// Unset deletes the config value of the key in the scope. The default value is used after that.
+func Unset(ctx context.Context, nc *nats.Conn, key Key, scopes ...Scope) error {
+	kv, err := keyvalue(ctx, nc)
+	if err != nil {
+		return fmt.Errorf("key value store: %w", err)
 	}
+
+	err = kv.Delete(ctx, scope(scopes).kvKey(key))
+	if err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+		return fmt.Errorf("unset config: %w", err)
+	}
+	return nil
+}
+
+// Entry is a config value set in a scope.
+type Entry struct {
+	Key   Key
+	Scope Scope
+	Value string
+}
+
+// List returns the config values set in all the scopes, sorted by the key and the scope.
+func List(ctx context.Context, nc *nats.Conn) ([]Entry, error) {
+	kv, err := keyvalue(ctx, nc)
+	if err != nil {
+		return nil, fmt.Errorf("key value store: %w", err)
+	}
+
+	lister, err := kv.ListKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list config: %w", err)
+	}
+	entries := []Entry{}
+	for k := range lister.Keys() {
+		name, rest, _ := strings.Cut(k, ".")
+		entry := Entry{Key: Key(name)}
+		if module, ok := strings.CutPrefix(rest, "module."); ok {
+			entry.Scope = ModuleScope(module)
+		}
+		val, err := kv.Get(ctx, k)
+		if err != nil {
+			return nil, fmt.Errorf("get config: %w", err)
+		}
+		entry.Value = string(val.Value())
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Key != entries[j].Key {
+			return entries[i].Key < entries[j].Key
+		}
+		return entries[i].Scope.Module < entries[j].Scope.Module
+	})
+	return entries, nil
+}
+
+// scope returns the first scope, or the global scope if there is none.
+func scope(scopes []Scope) Scope {
+	if len(scopes) == 0 {
+		return Scope{}
+	}
+	return scopes[0]
+}
+
+func defaultConfig(key Key) string {
+	return key.spec().Default
 }
 
 func keyvalue(ctx context.Context, nc *nats.Conn) (jetstream.KeyValue, error) {
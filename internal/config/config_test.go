@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jumonmd/jumon/internal/testutil"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestKeyValidate(t *testing.T) {
+	tests := []struct {
+		key     Key
+		value   string
+		wantErr bool
+	}{
+		{key: DefaultModel, value: "claude-3-haiku"},
+		{key: DefaultModel, value: "", wantErr: true},
+		{key: OpenAIBaseURL, value: "http://localhost:11434/v1"},
+		{key: OpenAIBaseURL, value: "localhost", wantErr: true},
+		{key: OpenAIBaseURL, value: "https://{{secret.GATEWAY_USER}}@gateway.example.com/v1?key={{secret.GATEWAY_KEY}}"},
+		{key: ChatTimeout, value: "30s"},
+		{key: ChatTimeout, value: "30", wantErr: true},
+		{key: MaxToolCalls, value: "8"},
+		{key: MaxToolCalls, value: "-1", wantErr: true},
+		{key: MaxToolCalls, value: "0", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.key.Validate(tt.value); (err != nil) != tt.wantErr {
+			t.Errorf("%s.Validate(%q) error = %v, wantErr %v", tt.key, tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	if s, err := ParseScope("module=jumonmd/jumon/example/hello"); err != nil || s.Module != "jumonmd/jumon/example/hello" {
+		t.Errorf("ParseScope() = %v, %v", s, err)
+	}
+	if s, err := ParseScope(""); err != nil || s != (Scope{}) {
+		t.Errorf("ParseScope(empty) = %v, %v", s, err)
+	}
+	for _, s := range []string{"user=me", "module=", "module=a b"} {
+		if _, err := ParseScope(s); err == nil {
+			t.Errorf("ParseScope(%q) expected error, got nil", s)
+		}
+	}
+	if k, err := ParseKey("defaultmodel"); err != nil || k != DefaultModel {
+		t.Errorf("ParseKey() = %v, %v", k, err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+	if _, err := js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: "config"}); err != nil {
+		t.Fatalf("failed to create config bucket: %v", err)
+	}
+	ctx := t.Context()
+	mod := ModuleScope("jumonmd/jumon/example/hello")
+
+	// default
+	if entries, err := List(ctx, nc); err != nil || len(entries) != 0 {
+		t.Errorf("List() of empty config = %v, %v", entries, err)
+	}
+	if got, _ := Get(ctx, nc, DefaultModel, mod); got != "gpt-4o" {
+		t.Errorf("default = %q", got)
+	}
+
+	// global and module values
+	if err := Set(ctx, nc, DefaultModel, "gpt-4o-mini"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := Set(ctx, nc, DefaultModel, "claude-3-haiku", mod); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := Set(ctx, nc, ChatTimeout, "1m"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := Set(ctx, nc, ChatTimeout, "soon"); err == nil {
+		t.Error("expected validation error, got nil")
+	}
+	if err := Set(ctx, nc, ChatTimeout, "1m", mod); err == nil {
+		t.Error("expected module scope error for a global key, got nil")
+	}
+	if got, _ := Get(ctx, nc, DefaultModel, mod); got != "claude-3-haiku" {
+		t.Errorf("module value = %q", got)
+	}
+	if got, _ := Get(ctx, nc, DefaultModel, ModuleScope("other")); got != "gpt-4o-mini" {
+		t.Errorf("global value = %q", got)
+	}
+	if got, _ := GetDuration(ctx, nc, ChatTimeout); got != time.Minute {
+		t.Errorf("duration value = %v", got)
+	}
+
+	entries, err := List(ctx, nc)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	want := []Entry{
+		{Key: ChatTimeout, Value: "1m"},
+		{Key: DefaultModel, Value: "gpt-4o-mini"},
+		{Key: DefaultModel, Scope: mod, Value: "claude-3-haiku"},
+	}
+	if diff := cmp.Diff(want, entries); diff != "" {
+		t.Errorf("List() mismatch (-want +got):\n%s", diff)
+	}
+
+	// unset falls back to the global value
+	if err := Unset(ctx, nc, DefaultModel, mod); err != nil {
+		t.Fatalf("Unset() error = %v", err)
+	}
+	if got, _ := Get(ctx, nc, DefaultModel, mod); got != "gpt-4o-mini" {
+		t.Errorf("value after unset = %q", got)
+	}
+}
@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	base := time.Date(2025, 3, 14, 10, 30, 15, 0, time.UTC) // Friday
+	tests := []struct {
+		name string
+		expr string
+		want time.Time
+	}{
+		{
+			name: "every minute",
+			expr: "* * * * *",
+			want: time.Date(2025, 3, 14, 10, 31, 0, 0, time.UTC),
+		},
+		{
+			name: "every 15 minutes",
+			expr: "*/15 * * * *",
+			want: time.Date(2025, 3, 14, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name: "daily",
+			expr: "@daily",
+			want: time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "hourly",
+			expr: "@hourly",
+			want: time.Date(2025, 3, 14, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "weekdays at 9",
+			expr: "0 9 * * mon-fri",
+			want: time.Date(2025, 3, 17, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 7",
+			expr: "0 0 * * 7",
+			want: time.Date(2025, 3, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "list and range",
+			expr: "5,10 8-9 1 jan,jun *",
+			want: time.Date(2025, 6, 1, 8, 5, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or day of week",
+			expr: "0 0 20 * mon",
+			want: time.Date(2025, 3, 17, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "every other day on mondays",
+			expr: "0 0 */2 * mon",
+			want: time.Date(2025, 3, 17, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "question mark as any day",
+			expr: "0 0 ? * mon",
+			want: time.Date(2025, 3, 17, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "question mark as any weekday",
+			expr: "0 0 20 * ?",
+			want: time.Date(2025, 3, 20, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			expr: "0 0 29 2 *",
+			want: time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			got := s.Next(base)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	s, err := Parse("0 9 * * *")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	base := time.Date(2025, 3, 14, 1, 0, 0, 0, time.UTC) // 10:00 in Tokyo
+	got := s.Next(base.In(loc))
+	want := time.Date(2025, 3, 15, 0, 0, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("Next() = %v, want %v", got, want)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"? * * * *",
+		"@every 5m",
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := Parse(expr); err == nil {
+				t.Errorf("Parse(%q) expected error", expr)
+			}
+		})
+	}
+}
@@ -9,7 +9,7 @@ import (
 	"strings"
 )
 
-// decodeDATAURL decodes data URL to data and mime type.
+// Decode decodes data URL to data and mime type. e.g. "application/json".
 func Decode(dataURL string) (data []byte, mimeType string, err error) {
 	parts := strings.Split(dataURL, ",")
 	if len(parts) != 2 {
@@ -22,7 +22,7 @@ func Decode(dataURL string) (data []byte, mimeType string, err error) {
 	if err != nil {
 		return nil, "", fmt.Errorf("base64 decode failed: %w", err)
 	}
-	mimeType = parts[0]
+	mimeType = strings.TrimSuffix(strings.TrimPrefix(parts[0], "data:"), ";base64")
 	return
 }
 
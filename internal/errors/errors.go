@@ -6,6 +6,7 @@ package errors
 import (
 	"errors"
 	"fmt"
+	"strconv"
 )
 
 type ServiceError struct {
@@ -18,19 +19,69 @@ func New(code int, description string) *ServiceError {
 	return &ServiceError{Code: code, Description: description}
 }
 
+// Unwrap returns the cause of the error wrapped by ServiceError.Wrap, or the next error in the chain.
 func Unwrap(err error) error {
+	if w, ok := err.(*wrapError); ok {
+		return w.err
+	}
 	return errors.Unwrap(err)
 }
 
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+func As(err error, target any) bool {
+	return errors.As(err, target)
+}
+
 func (e *ServiceError) Error() string {
 	return fmt.Sprintf("%d: %s", e.Code, e.Description)
 }
 
+// Wrap returns the error of the cause with the service error in the chain, e.g. to get its code with errors.As.
 func (e *ServiceError) Wrap(err error) error {
-	return fmt.Errorf("%s: %w", e.Error(), err)
+	return &wrapError{se: e, err: err}
+}
+
+// wrapError is the cause wrapped by a service error.
+type wrapError struct {
+	se  *ServiceError
+	err error
+}
+
+func (w *wrapError) Error() string {
+	return w.se.Error() + ": " + w.err.Error()
+}
+
+func (w *wrapError) Unwrap() []error {
+	return []error{w.se, w.err}
 }
 
 // ServiceError returns the error for NATS micro service.
 func (e *ServiceError) ServiceError(err error) (string, string, []byte) {
 	return fmt.Sprintf("%d", e.Code), e.Description + ": " + err.Error(), nil
 }
+
+// FromHeaders returns the service error of the NATS micro service response headers,
+// or nil if the response is not an error.
+func FromHeaders(h interface{ Get(key string) string }) error {
+	code := h.Get("Nats-Service-Error-Code")
+	if code == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(code)
+	if err != nil {
+		return fmt.Errorf("%s: %s", code, h.Get("Nats-Service-Error"))
+	}
+	return &ServiceError{Code: n, Description: h.Get("Nats-Service-Error")}
+}
+
+// Code returns the code of the first service error in the chain, or 0 if err has none.
+func Code(err error) int {
+	var se *ServiceError
+	if !errors.As(err, &se) {
+		return 0
+	}
+	return se.Code
+}
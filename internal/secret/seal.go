@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/zchee/go-xdgbasedir"
+)
+
+// masterKeySize is the size of the AES-256 master key.
+const masterKeySize = 32
+
+// keyPath returns the path to the master key file based on XDG Config Directory.
+// e.g. ~/.config/jumon/master.key.
+func keyPath() string {
+	return filepath.Join(xdgbasedir.ConfigHome(), "jumon", "master.key")
+}
+
+// masterKey reads the master key file, or creates it with a random key on the first use.
+func masterKey() ([]byte, error) {
+	path := keyPath()
+	key, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createMasterKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read master key: %w", err)
+	}
+	if len(key) != masterKeySize {
+		return nil, fmt.Errorf("invalid master key: %s", path)
+	}
+	return key, nil
+}
+
+func createMasterKey(path string) ([]byte, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create master key dir: %w", err)
+	}
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate master key: %w", err)
+	}
+
+	// the key is written to a temporary file and linked into place, so that the other process
+	// which creates the key at the same time never reads a partially written key.
+	f, err := os.CreateTemp(filepath.Dir(path), ".master.key-*")
+	if err != nil {
+		return nil, fmt.Errorf("create master key: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write master key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("write master key: %w", err)
+	}
+	err = os.Link(f.Name(), path)
+	if errors.Is(err, fs.ErrExist) {
+		return masterKey()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create master key: %w", err)
+	}
+	return key, nil
+}
+
+// seal encrypts the value with the master key. The name is authenticated
+// so that a sealed value can not be moved to another name.
+func seal(key []byte, name, value string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, []byte(value), []byte(name)), nil
+}
+
+// open decrypts the value sealed by seal.
+func open(key []byte, name string, sealed []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("sealed value is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	value, err := gcm.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("open sealed value (wrong master key?): %w", err)
+	}
+	return string(value), nil
+}
+
+// sign returns the HMAC of the request data made for the module.
+// The module and the data are separated by a zero byte, which the module names do not have.
+func sign(key []byte, module string, data []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("jumon module request\x00" + module + "\x00"))
+	mac.Write(data)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+	return gcm, nil
+}
@@ -0,0 +1,299 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+// Package secret stores the secrets such as the provider API keys and the tool credentials.
+// The values are sealed with the master key of the server before they are put in the key value store,
+// and are referred as "{{secret.NAME}}" in the tool arguments, the WASM plugin config and the base URLs.
+// The references in the tool arguments are resolved only for the modules which the secret is granted to.
+package secret
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Bucket is the key value store bucket of the sealed secrets.
+const Bucket = "secret"
+
+var (
+	// ErrNotFound is returned when the secret is not set.
+	ErrNotFound = errors.New("secret not found")
+	// ErrNotGranted is returned when the secret is referred by a module which it is not granted to.
+	ErrNotGranted = errors.New("secret not granted")
+	// ErrInvalidSignature is returned when the module signature of a request does not match.
+	ErrInvalidSignature = errors.New("invalid module signature")
+)
+
+// Entry is a secret name with the modules which the secret is granted to. The value is not included.
+type Entry struct {
+	Name    string   `json:"name"`
+	Modules []string `json:"modules,omitempty"`
+}
+
+// record is the stored value of a secret.
+type record struct {
+	Sealed  []byte   `json:"sealed"`
+	Modules []string `json:"modules,omitempty"`
+}
+
+// validName matches the secret names. e.g. "OPENAI_API_KEY".
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// secretRef matches the secret references. e.g. "{{secret.GITHUB_TOKEN}}".
+var secretRef = regexp.MustCompile(`\{\{\s*secret\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// ValidateName checks that the name can be a secret name.
+func ValidateName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q: use letters, digits and underscores", name)
+	}
+	return nil
+}
+
+// Set seals and sets the secret value of the name with the master key of this host.
+// The clients set the secrets through the secret service so that they are sealed on the server.
+// The modules are the module names which can refer the secret in their tool arguments.
+func Set(ctx context.Context, nc *nats.Conn, name, value string, modules []string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	if value == "" {
+		return fmt.Errorf("secret %s must not be empty", name)
+	}
+	if slices.Contains(modules, "") {
+		return fmt.Errorf("secret %s: module name must not be empty", name)
+	}
+	key, err := masterKey()
+	if err != nil {
+		return err
+	}
+	sealed, err := seal(key, name, value)
+	if err != nil {
+		return fmt.Errorf("seal secret: %w", err)
+	}
+	data, err := json.Marshal(record{Sealed: sealed, Modules: modules})
+	if err != nil {
+		return fmt.Errorf("marshal secret: %w", err)
+	}
+
+	kv, err := keyvalue(ctx, nc)
+	if err != nil {
+		return err
+	}
+	if _, err := kv.Put(ctx, name, data); err != nil {
+		return fmt.Errorf("set secret: %w", err)
+	}
+	return nil
+}
+
+// Get returns the secret value of the name for the server config such as the provider API keys.
+// The value is registered to the tracer to be redacted from the spans and the notifications.
+func Get(ctx context.Context, nc *nats.Conn, name string) (string, error) {
+	value, _, err := get(ctx, nc, name)
+	return value, err
+}
+
+// GetFor returns the secret value of the name if it is granted to the module.
+func GetFor(ctx context.Context, nc *nats.Conn, module, name string) (string, error) {
+	value, rec, err := get(ctx, nc, name)
+	if err != nil {
+		return "", err
+	}
+	if module == "" || !slices.Contains(rec.Modules, module) {
+		return "", fmt.Errorf("%w: %s to module %q", ErrNotGranted, name, module)
+	}
+	return value, nil
+}
+
+func get(ctx context.Context, nc *nats.Conn, name string) (string, *record, error) {
+	if err := ValidateName(name); err != nil {
+		return "", nil, err
+	}
+	kv, err := keyvalue(ctx, nc)
+	if err != nil {
+		return "", nil, err
+	}
+	entry, err := kv.Get(ctx, name)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return "", nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("get secret: %w", err)
+	}
+	rec := &record{}
+	if err := json.Unmarshal(entry.Value(), rec); err != nil {
+		return "", nil, fmt.Errorf("unmarshal secret %s: %w", name, err)
+	}
+
+	key, err := masterKey()
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := open(key, name, rec.Sealed)
+	if err != nil {
+		return "", nil, fmt.Errorf("secret %s: %w", name, err)
+	}
+	tracer.AddSecret(value)
+	return value, rec, nil
+}
+
+// SignModule returns the signature of the request data made for the module with the master key.
+// It proves that the request is made by the server running the module, not by any other client of the NATS server,
+// so that the secrets granted to the module are resolved only for its own requests.
+func SignModule(module string, data []byte) (string, error) {
+	key, err := masterKey()
+	if err != nil {
+		return "", err
+	}
+	return sign(key, module, data), nil
+}
+
+// VerifyModule checks the signature of the request data made for the module by SignModule.
+func VerifyModule(module string, data []byte, signature string) error {
+	key, err := masterKey()
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(sign(key, module, data)), []byte(signature)) {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, module)
+	}
+	return nil
+}
+
+// List returns the secrets in order of the names. The values are not returned.
+func List(ctx context.Context, nc *nats.Conn) ([]Entry, error) {
+	kv, err := keyvalue(ctx, nc)
+	if err != nil {
+		return nil, err
+	}
+	lister, err := kv.ListKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list secrets: %w", err)
+	}
+	entries := []Entry{}
+	for name := range lister.Keys() {
+		kve, err := kv.Get(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("get secret: %w", err)
+		}
+		rec := record{}
+		if err := json.Unmarshal(kve.Value(), &rec); err != nil {
+			return nil, fmt.Errorf("unmarshal secret %s: %w", name, err)
+		}
+		entries = append(entries, Entry{Name: name, Modules: rec.Modules})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// Delete deletes the secret of the name.
+func Delete(ctx context.Context, nc *nats.Conn, name string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	kv, err := keyvalue(ctx, nc)
+	if err != nil {
+		return err
+	}
+	if _, err := kv.Get(ctx, name); errors.Is(err, jetstream.ErrKeyNotFound) {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err := kv.Delete(ctx, name); err != nil {
+		return fmt.Errorf("delete secret: %w", err)
+	}
+	return nil
+}
+
+// Resolve replaces the secret references in s with their values for the server config such as the base URLs.
+func Resolve(ctx context.Context, nc *nats.Conn, s string) (string, error) {
+	return resolve(s, func(name string) (string, error) {
+		return Get(ctx, nc, name)
+	})
+}
+
+// ResolveValue replaces the secret references in the strings of the JSON value such as the tool arguments.
+// Only the secrets granted to the module are resolved, so an empty module resolves none of them.
+// The maps and the slices are copied so that the original value keeps the references.
+func ResolveValue(ctx context.Context, nc *nats.Conn, module string, v any) (any, error) {
+	return resolveValue(v, func(name string) (string, error) {
+		return GetFor(ctx, nc, module, name)
+	})
+}
+
+func resolve(s string, get func(name string) (string, error)) (string, error) {
+	var rerr error
+	out := secretRef.ReplaceAllStringFunc(s, func(m string) string {
+		if rerr != nil {
+			return m
+		}
+		value, err := get(secretRef.FindStringSubmatch(m)[1])
+		if err != nil {
+			rerr = err
+			return m
+		}
+		return value
+	})
+	return out, rerr
+}
+
+func resolveValue(v any, get func(name string) (string, error)) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return resolve(v, get)
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			r, err := resolveValue(e, get)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			r, err := resolveValue(e, get)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// HasRefs reports whether s has secret references.
+func HasRefs(s string) bool {
+	return secretRef.MatchString(s)
+}
+
+// MaskRefs replaces the secret references in s with a placeholder, e.g. to validate a URL which has them.
+func MaskRefs(s string) string {
+	return secretRef.ReplaceAllString(s, "secret")
+}
+
+func keyvalue(ctx context.Context, nc *nats.Conn) (jetstream.KeyValue, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("create stream: %w", err)
+	}
+	kv, err := js.KeyValue(ctx, Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("key value store: %w", err)
+	}
+	return kv, nil
+}
@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package secret
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jumonmd/jumon/internal/testutil"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestSeal(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	path := filepath.Join(dir, "jumon", "master.key")
+
+	key, err := masterKey()
+	if err != nil {
+		t.Fatalf("masterKey() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("master key file = %v, %v", info, err)
+	}
+	again, err := masterKey()
+	if err != nil || !bytes.Equal(key, again) {
+		t.Fatalf("masterKey() is not reused: %v", err)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(dir, "jumon", ".master.key-*")); len(tmps) != 0 {
+		t.Errorf("temporary key files are left: %v", tmps)
+	}
+
+	// the processes which create the key at the same time use the same key.
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	keys := make(chan []byte, 10)
+	var wg sync.WaitGroup
+	for range cap(keys) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key, err := masterKey()
+			if err != nil {
+				t.Errorf("masterKey() error = %v", err)
+			}
+			keys <- key
+		}()
+	}
+	wg.Wait()
+	close(keys)
+	first := <-keys
+	for key := range keys {
+		if !bytes.Equal(first, key) {
+			t.Errorf("masterKey() = %x, want %x", key, first)
+		}
+	}
+
+	sealed, err := seal(key, "TOKEN", "s3cret")
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+	if bytes.Contains(sealed, []byte("s3cret")) {
+		t.Errorf("sealed value has the plain text")
+	}
+	if got, err := open(key, "TOKEN", sealed); err != nil || got != "s3cret" {
+		t.Errorf("open() = %q, %v", got, err)
+	}
+	if _, err := open(key, "OTHER", sealed); err == nil {
+		t.Errorf("open() with another name expected error, got nil")
+	}
+}
+
+func TestSecret(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+	kv, err := js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: Bucket})
+	if err != nil {
+		t.Fatalf("failed to create key value store: %v", err)
+	}
+	ctx := t.Context()
+
+	if err := Set(ctx, nc, "GITHUB_TOKEN", "ghp_test_token", []string{"test/github"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := Set(ctx, nc, "API_KEY", "key-123", []string{"test/github", "test/other"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := Set(ctx, nc, "bad-name", "x", nil); err == nil {
+		t.Errorf("Set() with invalid name expected error, got nil")
+	}
+
+	entry, err := kv.Get(ctx, "GITHUB_TOKEN")
+	if err != nil || bytes.Contains(entry.Value(), []byte("ghp_test_token")) {
+		t.Errorf("stored value is not sealed: %v", err)
+	}
+
+	entries, err := List(ctx, nc)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	wantEntries := []Entry{
+		{Name: "API_KEY", Modules: []string{"test/github", "test/other"}},
+		{Name: "GITHUB_TOKEN", Modules: []string{"test/github"}},
+	}
+	if diff := cmp.Diff(wantEntries, entries); diff != "" {
+		t.Errorf("List() mismatch (-want +got):\n%s", diff)
+	}
+
+	args := map[string]any{
+		"subject": "tool.github",
+		"headers": map[string]any{"Authorization": "Bearer {{ secret.GITHUB_TOKEN }}"},
+		"keys":    []any{"{{secret.API_KEY}}", 1.0},
+	}
+	got, err := ResolveValue(ctx, nc, "test/github", args)
+	if err != nil {
+		t.Fatalf("ResolveValue() error = %v", err)
+	}
+	want := map[string]any{
+		"subject": "tool.github",
+		"headers": map[string]any{"Authorization": "Bearer ghp_test_token"},
+		"keys":    []any{"key-123", 1.0},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ResolveValue() mismatch (-want +got):\n%s", diff)
+	}
+	if args["keys"].([]any)[0] != "{{secret.API_KEY}}" {
+		t.Errorf("ResolveValue() changed the original value")
+	}
+	if got := tracer.Redact("token=ghp_test_token"); got != "token=[REDACTED]" {
+		t.Errorf("resolved value is not redacted: %s", got)
+	}
+
+	// the secrets are resolved only for the granted modules, but the server config can refer any of them.
+	for _, module := range []string{"test/other", ""} {
+		if _, err := ResolveValue(ctx, nc, module, args); !errors.Is(err, ErrNotGranted) {
+			t.Errorf("ResolveValue() for %q error = %v, want ErrNotGranted", module, err)
+		}
+	}
+	if got, err := Resolve(ctx, nc, "{{secret.GITHUB_TOKEN}}"); err != nil || got != "ghp_test_token" {
+		t.Errorf("Resolve() = %q, %v", got, err)
+	}
+
+	if err := Delete(ctx, nc, "API_KEY"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := Resolve(ctx, nc, "{{secret.API_KEY}}"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve() deleted secret error = %v, want ErrNotFound", err)
+	}
+	if err := Delete(ctx, nc, "API_KEY"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete() deleted secret error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestService(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+	if _, err := js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: Bucket}); err != nil {
+		t.Fatalf("failed to create key value store: %v", err)
+	}
+	svc, err := NewService(nc)
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+	defer svc.Stop()
+
+	request := func(subject, data string) (string, string) {
+		t.Helper()
+		resp, err := nc.Request(subject, []byte(data), time.Second)
+		if err != nil {
+			t.Fatalf("request %s failed: %v", subject, err)
+		}
+		return string(resp.Data), resp.Header.Get("Nats-Service-Error-Code")
+	}
+
+	tests := []struct {
+		name     string
+		subject  string
+		data     string
+		wantData string
+		wantCode string
+	}{
+		{"set", "secret.set", `{"name":"GITHUB_TOKEN","value":"ghp_test_token","modules":["test/github"]}`, "", ""},
+		{"set invalid name", "secret.set", `{"name":"bad-name","value":"x"}`, "", "400600"},
+		{"set empty value", "secret.set", `{"name":"EMPTY"}`, "", "400600"},
+		{"set empty module", "secret.set", `{"name":"EMPTY","value":"x","modules":[""]}`, "", "400600"},
+		{"list", "secret.list", "", `[{"name":"GITHUB_TOKEN","modules":["test/github"]}]`, ""},
+		{"delete", "secret.delete", "GITHUB_TOKEN", "", ""},
+		{"delete not found", "secret.delete", "GITHUB_TOKEN", "", "404600"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "delete" {
+				// the value is sealed with the master key of the server.
+				if got, err := Get(t.Context(), nc, "GITHUB_TOKEN"); err != nil || got != "ghp_test_token" {
+					t.Fatalf("Get() = %q, %v", got, err)
+				}
+			}
+			data, code := request(tt.subject, tt.data)
+			if code != tt.wantCode {
+				t.Fatalf("error code = %q, want %q", code, tt.wantCode)
+			}
+			if data != tt.wantData {
+				t.Errorf("data = %s, want %s", data, tt.wantData)
+			}
+		})
+	}
+}
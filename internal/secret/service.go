@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+// storeTimeout is the timeout of the secret store operations.
+const storeTimeout = 10 * time.Second
+
+var (
+	// ErrInvalidSecret is returned when the secret name or value is invalid.
+	ErrInvalidSecret = errors.New(400600, "invalid secret")
+	// ErrSecretNotFound is returned when a requested secret doesn't exist.
+	ErrSecretNotFound = errors.New(404600, "secret not found")
+	// ErrStoreSecret is returned when the secret store operation fails.
+	ErrStoreSecret = errors.New(500600, "store secret failed")
+)
+
+// SetRequest is the request of secret.set.
+type SetRequest struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Modules are the module names which the secret is granted to.
+	Modules []string `json:"modules,omitempty"`
+}
+
+// NewService creates the secret service. The secrets are sealed in the service
+// with the master key of the server, so the clients never need the key.
+// The values are never traced and never returned.
+// subject: secret.set, secret.list, secret.delete
+func NewService(nc *nats.Conn) (micro.Service, error) {
+	svc, err := micro.AddService(nc, micro.Config{
+		Name:        "jumon_secret",
+		Version:     "0.1.0",
+		Description: `jumon secret service`,
+	})
+	if err != nil {
+		slog.Error("secret service", "status", "create service failed", "error", err)
+		return nil, fmt.Errorf("create secret service: %w", err)
+	}
+
+	g := svc.AddGroup("secret")
+	g.AddEndpoint("set", micro.HandlerFunc(func(r micro.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		setHandler(ctx, nc, r)
+	}))
+
+	g.AddEndpoint("list", micro.HandlerFunc(func(r micro.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		listHandler(ctx, nc, r)
+	}))
+
+	g.AddEndpoint("delete", micro.HandlerFunc(func(r micro.Request) {
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		deleteHandler(ctx, nc, r)
+	}))
+
+	slog.Info("secret service", "status", "started")
+	return svc, nil
+}
+
+func setHandler(ctx context.Context, nc *nats.Conn, r micro.Request) {
+	req := SetRequest{}
+	if err := json.Unmarshal(r.Data(), &req); err != nil {
+		r.Error(ErrInvalidSecret.ServiceError(err))
+		return
+	}
+	if err := ValidateName(req.Name); err != nil {
+		r.Error(ErrInvalidSecret.ServiceError(err))
+		return
+	}
+	if req.Value == "" {
+		r.Error(ErrInvalidSecret.ServiceError(fmt.Errorf("secret %s must not be empty", req.Name)))
+		return
+	}
+
+	if slices.Contains(req.Modules, "") {
+		r.Error(ErrInvalidSecret.ServiceError(fmt.Errorf("secret %s: module name must not be empty", req.Name)))
+		return
+	}
+
+	if err := Set(ctx, nc, req.Name, req.Value, req.Modules); err != nil {
+		slog.Error("secret.set", "name", req.Name, "error", err)
+		r.Error(ErrStoreSecret.ServiceError(err))
+		return
+	}
+	slog.Info("secret.set", "name", req.Name)
+	r.Respond(nil)
+}
+
+func listHandler(ctx context.Context, nc *nats.Conn, r micro.Request) {
+	entries, err := List(ctx, nc)
+	if err != nil {
+		r.Error(ErrStoreSecret.ServiceError(err))
+		return
+	}
+	r.RespondJSON(entries)
+}
+
+func deleteHandler(ctx context.Context, nc *nats.Conn, r micro.Request) {
+	name := string(r.Data())
+	if err := ValidateName(name); err != nil {
+		r.Error(ErrInvalidSecret.ServiceError(err))
+		return
+	}
+
+	err := Delete(ctx, nc, name)
+	if errors.Is(err, ErrNotFound) {
+		r.Error(ErrSecretNotFound.ServiceError(err))
+		return
+	}
+	if err != nil {
+		r.Error(ErrStoreSecret.ServiceError(err))
+		return
+	}
+	slog.Info("secret.delete", "name", name)
+	r.Respond(nil)
+}
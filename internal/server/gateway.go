@@ -0,0 +1,219 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// runTimeout is the timeout of the run requests.
+	runTimeout = 10 * time.Minute
+	// storeTimeout is the timeout of the module and event store requests.
+	storeTimeout = 10 * time.Second
+	// maxRequestBodySize is the maximum size of the request body.
+	maxRequestBodySize = 10 << 20
+)
+
+// ErrorResponse is the response body of the gateway errors.
+type ErrorResponse struct {
+	// Code is the service error code. e.g. 404400.
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+}
+
+// handleGateway registers the REST gateway of the NATS services.
+// The traceparent and notify-to request headers are passed to the services,
+// so the notifications of a run can be received from /v1/notifications/{id}.
+func handleGateway(mux *http.ServeMux, nc *nats.Conn) {
+	// modules
+	mux.HandleFunc("POST /v1/modules/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		modurl, action := moduleAction(r)
+		switch action {
+		case "run":
+			gatewayRequest(nc, w, r, "module.run."+modurl, runTimeout)
+		case "submit":
+			gatewayRequest(nc, w, r, "module.submit."+modurl, storeTimeout)
+		default:
+			writeError(w, http.StatusNotFound, 0, "not found")
+		}
+	})
+	mux.HandleFunc("GET /v1/runs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		gatewayRequest(nc, w, r, "module.status."+r.PathValue("id"), storeTimeout)
+	})
+	mux.HandleFunc("GET /v1/runs/{id}/result", func(w http.ResponseWriter, r *http.Request) {
+		gatewayRequest(nc, w, r, "module.result."+r.PathValue("id"), storeTimeout)
+	})
+	mux.HandleFunc("POST /v1/runs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		gatewayRequest(nc, w, r, "module.cancel."+r.PathValue("id"), storeTimeout)
+	})
+	mux.HandleFunc("POST /v1/runs/{id}/resume", func(w http.ResponseWriter, r *http.Request) {
+		gatewayRequest(nc, w, r, "module.resume."+r.PathValue("id"), storeTimeout)
+	})
+	mux.HandleFunc("GET /v1/modules", func(w http.ResponseWriter, r *http.Request) {
+		gatewayRequest(nc, w, r, "module.list", storeTimeout)
+	})
+	mux.HandleFunc("GET /v1/modules/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		gatewayRequest(nc, w, r, "module.get."+r.PathValue("name"), storeTimeout)
+	})
+	mux.HandleFunc("PUT /v1/modules/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		gatewayRequest(nc, w, r, "module.put."+r.PathValue("name"), storeTimeout)
+	})
+	mux.HandleFunc("DELETE /v1/modules/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		gatewayRequest(nc, w, r, "module.delete."+r.PathValue("name"), storeTimeout)
+	})
+
+	// scripts and tools
+	mux.HandleFunc("POST /v1/scripts/run", func(w http.ResponseWriter, r *http.Request) {
+		gatewayRequest(nc, w, r, "script.run", runTimeout)
+	})
+	mux.HandleFunc("POST /v1/tools/run", func(w http.ResponseWriter, r *http.Request) {
+		gatewayRequest(nc, w, r, "tool.run", runTimeout)
+	})
+
+	// events
+	mux.HandleFunc("GET /v1/events", func(w http.ResponseWriter, r *http.Request) {
+		gatewayRequest(nc, w, r, "event.list", storeTimeout)
+	})
+	mux.HandleFunc("PUT /v1/events", func(w http.ResponseWriter, r *http.Request) {
+		gatewayRequest(nc, w, r, "event.put", storeTimeout)
+	})
+	mux.HandleFunc("GET /v1/events/{type}/{subject}", func(w http.ResponseWriter, r *http.Request) {
+		gatewayEventRequest(nc, w, r, "event.get")
+	})
+	mux.HandleFunc("DELETE /v1/events/{type}/{subject}", func(w http.ResponseWriter, r *http.Request) {
+		gatewayEventRequest(nc, w, r, "event.delete")
+	})
+
+	// notifications
+	mux.HandleFunc("GET /v1/notifications/{id}", func(w http.ResponseWriter, r *http.Request) {
+		notificationHandler(nc, w, r)
+	})
+}
+
+// moduleAction returns the module URL and the action of the path, e.g.
+// "/v1/modules/github.com/user/repo/run" -> "github.com/user/repo", "run".
+// The module URL has the script name of the "script" query.
+func moduleAction(r *http.Request) (modurl, action string) {
+	name := r.PathValue("name")
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return "", ""
+	}
+	modurl, action = name[:i], name[i+1:]
+	if scr := r.URL.Query().Get("script"); scr != "" {
+		modurl += "#" + scr
+	}
+	return modurl, action
+}
+
+// gatewayRequest sends the request body to the subject and writes the response.
+// The service error code is translated to the HTTP status.
+func gatewayRequest(nc *nats.Conn, w http.ResponseWriter, r *http.Request, subject string, timeout time.Duration) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 0, "failed to read body")
+		return
+	}
+	gatewayRespond(nc, w, r, subject, body, timeout)
+}
+
+// gatewayEventRequest sends the event type and subject of the path to the event endpoint.
+func gatewayEventRequest(nc *nats.Conn, w http.ResponseWriter, r *http.Request, subject string) {
+	data, err := json.Marshal(map[string]string{
+		"type":    r.PathValue("type"),
+		"subject": r.PathValue("subject"),
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, 0, err.Error())
+		return
+	}
+	gatewayRespond(nc, w, r, subject, data, storeTimeout)
+}
+
+func gatewayRespond(nc *nats.Conn, w http.ResponseWriter, r *http.Request, subject string, data []byte, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	slog.Info("http gateway", "method", r.Method, "path", r.URL.Path, "subject", subject)
+	resp, err := nc.RequestMsgWithContext(ctx, &nats.Msg{
+		Subject: subject,
+		Data:    data,
+		Header:  tracer.HeadersFromContext(tracer.NewContext(r.Header)),
+	})
+	if err != nil {
+		slog.Error("http gateway", "subject", subject, "error", err)
+		writeError(w, http.StatusBadGateway, 0, fmt.Sprintf("request %s: %v", subject, err))
+		return
+	}
+	if err := errors.FromHeaders(resp.Header); err != nil {
+		status, code, message := serviceError(err)
+		writeError(w, status, code, message)
+		return
+	}
+
+	if len(resp.Data) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if json.Valid(resp.Data) {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	}
+	_, _ = w.Write(resp.Data)
+}
+
+// notificationHandler streams the notifications of the id as Server-Sent Events.
+// The id is the notify-to header value of the run request.
+func notificationHandler(nc *nats.Conn, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, 0, "streaming is not supported")
+		return
+	}
+
+	msgs := make(chan *nats.Msg, 64)
+	sub, err := nc.ChanSubscribe("notification."+r.PathValue("id"), msgs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, 0, err.Error())
+		return
+	}
+	defer sub.Unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-msgs:
+			var n tracer.Notification
+			if err := json.Unmarshal(msg.Data, &n); err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", n.On, msg.Data)
+			flusher.Flush()
+		}
+	}
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, ErrorResponse{Code: code, Error: message})
+}
@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/event"
+	"github.com/jumonmd/jumon/internal/testutil"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/jumonmd/jumon/module"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestGateway(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	for _, bucket := range []string{"module", "config"} {
+		if _, err := js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: bucket}); err != nil {
+			t.Fatalf("failed to create kv: %v", err)
+		}
+	}
+
+	evtsvc, err := event.NewService(nc, js)
+	if err != nil {
+		t.Fatalf("failed to create event service: %v", err)
+	}
+	defer evtsvc.Stop()
+
+	modsvc, err := module.NewService(nc)
+	if err != nil {
+		t.Fatalf("failed to create module service: %v", err)
+	}
+	defer modsvc.Stop()
+
+	respdata, err := json.Marshal(chat.Response{
+		Model:        "gpt-4o-mini",
+		FinishReason: "stop",
+		Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, "hello")},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+	chtsvc, err := testutil.NewMicroServer(nc, "chat.generate", respdata)
+	if err != nil {
+		t.Fatalf("failed to create mock chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	toolsvc, err := testutil.NewMicroServer(nc, "tool.run", []byte(`{"result":1}`))
+	if err != nil {
+		t.Fatalf("failed to create mock tool service: %v", err)
+	}
+	defer toolsvc.Stop()
+
+	srv := httptest.NewServer(newHTTPHandler(nc, js, &HTTPConfig{Token: "test-token"}))
+	defer srv.Close()
+
+	do := func(method, path, body string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer test-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(data))
+	}
+
+	modmd := "---\nmodule: test/gateway\n---\n\n## Scripts\n### main\n1. say hello\n"
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"put module", http.MethodPut, "/v1/modules/test%2Fgateway", modmd, http.StatusNoContent, ""},
+		{"invalid module", http.MethodPut, "/v1/modules/bad", "---\nmodule: bad\n---\n", http.StatusBadRequest, ""},
+		{"list modules", http.MethodGet, "/v1/modules", "", http.StatusOK, `["test/gateway"]`},
+		{"get module", http.MethodGet, "/v1/modules/test%2Fgateway", "", http.StatusOK, strings.TrimSpace(modmd)},
+		{"get module by path", http.MethodGet, "/v1/modules/test/gateway", "", http.StatusOK, strings.TrimSpace(modmd)},
+		{"run module", http.MethodPost, "/v1/modules/test%2Fgateway/run", "", http.StatusOK, `"hello"`},
+		{"run module by path", http.MethodPost, "/v1/modules/test/gateway/run", "", http.StatusOK, `"hello"`},
+		{"unknown action", http.MethodPost, "/v1/modules/test/gateway/none", "", http.StatusNotFound, ""},
+		{"run unknown script", http.MethodPost, "/v1/modules/test%2Fgateway/run?script=none", "", http.StatusNotFound, ""},
+		{"run tool", http.MethodPost, "/v1/tools/run", `{}`, http.StatusOK, `{"result":1}`},
+		{"put event", http.MethodPut, "/v1/events", `{"subject":"a.b","module":"test/gateway"}`, http.StatusNoContent, ""},
+		{"invalid event", http.MethodPut, "/v1/events", `{"subject":"a.b"}`, http.StatusBadRequest, ""},
+		{"get event", http.MethodGet, "/v1/events/subscribe/a.b", "", http.StatusOK, `{"type":"subscribe","subject":"a.b","module":"test/gateway"}`},
+		{"delete event", http.MethodDelete, "/v1/events/subscribe/a.b", "", http.StatusNoContent, ""},
+		{"get deleted event", http.MethodGet, "/v1/events/subscribe/a.b", "", http.StatusNotFound, ""},
+		{"delete module", http.MethodDelete, "/v1/modules/test%2Fgateway", "", http.StatusNoContent, ""},
+		{"get deleted module", http.MethodGet, "/v1/modules/test%2Fgateway", "", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := do(tt.method, tt.path, tt.body)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body = %s", status, tt.wantStatus, body)
+			}
+			if tt.wantBody != "" && body != tt.wantBody {
+				t.Errorf("body = %s, want %s", body, tt.wantBody)
+			}
+		})
+	}
+
+	for _, auth := range []string{"", "Bearer wrong-token"} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/modules", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("status with %q = %d, want 401", auth, resp.StatusCode)
+		}
+	}
+
+	t.Run("notifications", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/notifications/abc", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer test-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("content type = %s", ct)
+		}
+
+		data, _ := json.Marshal(tracer.Notification{TraceID: "t", On: "response", Name: "script.run", Content: "done"})
+		if err := nc.Publish("notification.abc", data); err != nil {
+			t.Fatalf("failed to publish: %v", err)
+		}
+
+		lines := make(chan string)
+		go func() {
+			sc := bufio.NewScanner(resp.Body)
+			for sc.Scan() {
+				lines <- sc.Text()
+			}
+		}()
+		for _, want := range []string{"event: response", "data: " + string(data)} {
+			select {
+			case got := <-lines:
+				if got != want {
+					t.Errorf("line = %s, want %s", got, want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("no notification")
+			}
+		}
+	})
+}
@@ -0,0 +1,323 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jumonmd/jumon/event"
+	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/jumonmd/jumon/module"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/zchee/go-xdgbasedir"
+)
+
+const (
+	defaultSignatureHeader = "X-Hub-Signature-256"
+	defaultHookTimeout     = "5m"
+	maxHookBodySize        = 10 << 20
+)
+
+// HTTPConfig is the configuration of the HTTP listener.
+// The listener is disabled if Listen is empty.
+type HTTPConfig struct {
+	// Listen is the address of the HTTP listener. e.g. "127.0.0.1:8080".
+	Listen string `toml:"listen"`
+	// Token is the bearer token of the REST gateway and the OpenAI compatible endpoints.
+	// The endpoints under /v1/ are disabled if empty, so that only the hooks are public.
+	Token string `toml:"token"`
+	// Hooks are the webhooks which are accepted by the listener.
+	Hooks []HookConfig `toml:"hooks"`
+}
+
+// HookConfig is the configuration of a webhook.
+// POST /hooks/<subject> publishes the request body on "event.<subject>".
+type HookConfig struct {
+	// Subject is the event subject of the hook. e.g. "github.issue".
+	Subject string `toml:"subject"`
+	// Secret is the HMAC-SHA256 key to verify the signature of the request body.
+	// The signature is not verified if empty.
+	Secret string `toml:"secret"`
+	// SignatureHeader is the header of the signature. "X-Hub-Signature-256" by default.
+	// The value is the hex encoded signature with an optional "sha256=" prefix.
+	SignatureHeader string `toml:"signature_header"`
+	// Async submits the module run of the subscribe event and responds with the run ID
+	// without waiting for the module output.
+	// The request can also ask it with the "Prefer: respond-async" header.
+	Async bool `toml:"async"`
+	// Timeout is the timeout of the synchronous response. e.g. "5m".
+	Timeout string `toml:"timeout"`
+}
+
+// HookResponse is the response of an asynchronous webhook.
+// The run ID is empty if the subject has no subscribe event, e.g. it is consumed by a stream.
+type HookResponse struct {
+	RunID string `json:"run_id,omitempty"`
+}
+
+// LoadHTTPConfig loads the HTTP configuration from the path.
+// It returns an empty configuration if the file does not exist.
+func LoadHTTPConfig(path string) (*HTTPConfig, error) {
+	cfg := &HTTPConfig{}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if _, err := toml.DecodeFile(path, cfg); err != nil {
+		return nil, fmt.Errorf("decode http config: %w", err)
+	}
+	for _, hook := range cfg.Hooks {
+		if hook.Subject == "" {
+			return nil, fmt.Errorf("hook subject is required")
+		}
+		if hook.Timeout != "" {
+			if _, err := time.ParseDuration(hook.Timeout); err != nil {
+				return nil, fmt.Errorf("hook %s: invalid timeout: %w", hook.Subject, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// httpConfigPath returns the path to the HTTP config file based on XDG Config Directory.
+// e.g. ~/.config/jumon/http.toml.
+func httpConfigPath() string {
+	configDir := xdgbasedir.ConfigHome()
+	return filepath.Join(configDir, "jumon", "http.toml")
+}
+
+// setupHTTPServer starts the HTTP listener if it is configured.
+// It returns nil if the listener is disabled.
+func setupHTTPServer(nc *nats.Conn, js jetstream.JetStream) (*http.Server, error) {
+	cfg, err := LoadHTTPConfig(httpConfigPath())
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Listen == "" {
+		return nil, nil
+	}
+
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           newHTTPHandler(nc, js, cfg),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		slog.Info("http server", "status", "started", "listen", cfg.Listen)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("http server", "status", "stopped", "error", err)
+		}
+	}()
+	return srv, nil
+}
+
+func shutdownHTTPServer(srv *http.Server) {
+	if srv == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("http server", "status", "shutdown failed", "error", err)
+	}
+}
+
+// newHTTPHandler returns the handler of the HTTP listener.
+func newHTTPHandler(nc *nats.Conn, js jetstream.JetStream, cfg *HTTPConfig) http.Handler {
+	hooks := map[string]HookConfig{}
+	for _, hook := range cfg.Hooks {
+		hooks[hook.Subject] = hook
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /hooks/{subject...}", func(w http.ResponseWriter, r *http.Request) {
+		hook, ok := hooks[r.PathValue("subject")]
+		if !ok {
+			writeError(w, http.StatusNotFound, 0, "hook not found")
+			return
+		}
+		hookHandler(nc, js, hook, w, r)
+	})
+	if cfg.Token != "" {
+		api := http.NewServeMux()
+		handleGateway(api, nc)
+		handleOpenAI(api, nc)
+		mux.Handle("/v1/", requireToken(cfg.Token, api))
+	}
+	return mux
+}
+
+// requireToken responds with 401 unless the request has the bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, 0, "invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hookHandler runs the module of the subscribe event of the hook with the request body.
+// It responds with the module output, or with the run ID of the submitted run if the hook is asynchronous.
+// If the subject has no subscribe event, the body is published on the event subject and accepted.
+func hookHandler(nc *nats.Conn, js jetstream.JetStream, hook HookConfig, w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHookBodySize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 0, "failed to read body")
+		return
+	}
+	if hook.Secret != "" && !verifySignature(hook, r.Header, body) {
+		writeError(w, http.StatusUnauthorized, 0, "invalid signature")
+		return
+	}
+
+	ctx, span := tracer.Start(r.Context(), nc, "http.hook")
+	defer span.End()
+	runID := tracer.TraceID(ctx)
+	slog.Info("http hook", "subject", hook.Subject, "run_id", runID)
+
+	msg := &nats.Msg{
+		Subject: "event." + hook.Subject,
+		Data:    body,
+		Header:  span.Headers(),
+	}
+
+	evt, err := event.GetEvent(ctx, js, event.EventTypeSubscribe, hook.Subject)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		// the event may be consumed by a stream, so there is no run to respond.
+		if err := nc.PublishMsg(msg); err != nil {
+			span.SetError(err)
+			writeError(w, http.StatusBadGateway, 0, "failed to publish event")
+			return
+		}
+		writeJSON(w, http.StatusAccepted, HookResponse{})
+		return
+	}
+	if err != nil {
+		span.SetError(err)
+		writeError(w, http.StatusBadGateway, 0, "failed to get event")
+		return
+	}
+
+	if hook.Async || r.Header.Get("Prefer") == "respond-async" {
+		submitHook(ctx, nc, span, evt, body, w)
+		return
+	}
+
+	timeout, err := time.ParseDuration(hook.Timeout)
+	if err != nil {
+		timeout, _ = time.ParseDuration(defaultHookTimeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resp, err := nc.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		span.SetError(err)
+		writeError(w, http.StatusGatewayTimeout, 0, "event request failed")
+		return
+	}
+	if err := errors.FromHeaders(resp.Header); err != nil {
+		span.SetError(err)
+		status, code, message := serviceError(err)
+		writeError(w, status, code, message)
+		return
+	}
+	span.SetResponse(resp.Data)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Jumon-Run-Id", runID)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(resp.Data)
+}
+
+// submitHook submits the module run of the event with the body, and responds with the run ID.
+// The run is recorded by the module service, so its state and result can be requested by the run ID.
+func submitHook(ctx context.Context, nc *nats.Conn, span *tracer.SpanTracer, evt *event.Event, body []byte, w http.ResponseWriter) {
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+
+	resp, err := nc.RequestMsgWithContext(ctx, &nats.Msg{
+		Subject: "module.submit." + evt.Module,
+		Data:    body,
+		Header:  span.Headers(),
+	})
+	if err != nil {
+		span.SetError(err)
+		writeError(w, http.StatusBadGateway, 0, "submit module failed")
+		return
+	}
+	if err := errors.FromHeaders(resp.Header); err != nil {
+		span.SetError(err)
+		status, code, message := serviceError(err)
+		writeError(w, status, code, message)
+		return
+	}
+	var submitted module.SubmitResponse
+	if err := json.Unmarshal(resp.Data, &submitted); err != nil {
+		span.SetError(err)
+		writeError(w, http.StatusBadGateway, 0, "invalid submit response")
+		return
+	}
+	slog.Info("http hook", "subject", evt.Subject, "status", "submitted", "run_id", submitted.RunID)
+	writeJSON(w, http.StatusAccepted, HookResponse{RunID: submitted.RunID})
+}
+
+// verifySignature verifies the HMAC-SHA256 signature of the body.
+func verifySignature(hook HookConfig, h http.Header, body []byte) bool {
+	header := hook.SignatureHeader
+	if header == "" {
+		header = defaultSignatureHeader
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(h.Get(header), "sha256="))
+	if err != nil || len(sig) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// serviceError returns the HTTP status, the code and the message of the service error of a NATS response.
+// e.g. 404400 -> 404. The code already in HTTP status is returned as is.
+func serviceError(err error) (status int, code int, message string) {
+	var se *errors.ServiceError
+	if !errors.As(err, &se) {
+		return http.StatusInternalServerError, 0, err.Error()
+	}
+	status = se.Code
+	if status >= 1000 {
+		status /= 1000
+	}
+	if status < 400 || status > 599 {
+		status = http.StatusInternalServerError
+	}
+	return status, se.Code, se.Description
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("http", "status", "write response failed", "error", err)
+	}
+}
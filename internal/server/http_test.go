@@ -0,0 +1,274 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jumonmd/jumon/event"
+	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/jumonmd/jumon/internal/testutil"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/jumonmd/jumon/module"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestLoadHTTPConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.toml")
+	data := `
+listen = "127.0.0.1:8080"
+token = "t0ken"
+
+[[hooks]]
+subject = "github.issue"
+secret = "s3cret"
+
+[[hooks]]
+subject = "jobs"
+async = true
+timeout = "30s"
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	got, err := LoadHTTPConfig(path)
+	if err != nil {
+		t.Fatalf("LoadHTTPConfig() error = %v", err)
+	}
+	want := &HTTPConfig{
+		Listen: "127.0.0.1:8080",
+		Token:  "t0ken",
+		Hooks: []HookConfig{
+			{Subject: "github.issue", Secret: "s3cret"},
+			{Subject: "jobs", Async: true, Timeout: "30s"},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("config mismatch (-want +got):\n%s", diff)
+	}
+
+	// missing file disables the listener
+	got, err = LoadHTTPConfig(filepath.Join(t.TempDir(), "none.toml"))
+	if err != nil {
+		t.Fatalf("LoadHTTPConfig() error = %v", err)
+	}
+	if got.Listen != "" {
+		t.Errorf("expected empty listen, got %s", got.Listen)
+	}
+}
+
+func TestHookHandler(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	evtsvc, err := event.NewService(nc, js)
+	if err != nil {
+		t.Fatalf("failed to create event service: %v", err)
+	}
+	defer evtsvc.Stop()
+
+	// mock module service, it echoes the input or fails for the "invalid" module.
+	// The submitted input is sent to the channel.
+	submitted := make(chan string, 1)
+	modsvc, err := micro.AddService(nc, micro.Config{
+		Name:    "test-module",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "module.>",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				if r.Subject() == "module.run.invalid" {
+					r.Error("400401", "invalid input", nil)
+					return
+				}
+				if r.Subject() == "module.submit.echo" {
+					submitted <- string(r.Data())
+					r.RespondJSON(module.SubmitResponse{RunID: "run-1"})
+					return
+				}
+				r.Respond(r.Data())
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create module service: %v", err)
+	}
+	defer modsvc.Stop()
+
+	for _, evt := range []*event.Event{
+		{Type: event.EventTypeSubscribe, Subject: "github.issue", Module: "echo"},
+		{Type: event.EventTypeSubscribe, Subject: "invalid", Module: "invalid"},
+	} {
+		if err := event.PutEvent(t.Context(), js, evt); err != nil {
+			t.Fatalf("failed to put event: %v", err)
+		}
+	}
+
+	cfg := &HTTPConfig{
+		Hooks: []HookConfig{
+			{Subject: "github.issue", Secret: "s3cret"},
+			{Subject: "invalid"},
+			{Subject: "jobs"},
+		},
+	}
+	srv := httptest.NewServer(newHTTPHandler(nc, js, cfg))
+	defer srv.Close()
+
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	post := func(path, body string, header map[string]string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(bytes.TrimSpace(data))
+	}
+
+	t.Run("sync", func(t *testing.T) {
+		body := `{"title":"bug"}`
+		status, got := post("/hooks/github.issue", body, map[string]string{"X-Hub-Signature-256": sign(body)})
+		if status != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", status, got)
+		}
+		if got != body {
+			t.Errorf("body = %s, want %s", got, body)
+		}
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		status, _ := post("/hooks/github.issue", `{}`, map[string]string{"X-Hub-Signature-256": sign(`{"x":1}`)})
+		if status != http.StatusUnauthorized {
+			t.Errorf("status = %d, want 401", status)
+		}
+	})
+
+	t.Run("gateway without token", func(t *testing.T) {
+		status, _ := post("/v1/tools/run", `{}`, nil)
+		if status != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", status)
+		}
+	})
+
+	t.Run("unknown hook", func(t *testing.T) {
+		status, _ := post("/hooks/unknown", `{}`, nil)
+		if status != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", status)
+		}
+	})
+
+	t.Run("module error", func(t *testing.T) {
+		status, _ := post("/hooks/invalid", `{}`, nil)
+		if status != http.StatusBadRequest {
+			t.Errorf("status = %d, want 400", status)
+		}
+	})
+
+	t.Run("async", func(t *testing.T) {
+		body := `{"title":"bug"}`
+		status, got := post("/hooks/github.issue", body, map[string]string{
+			"X-Hub-Signature-256": sign(body),
+			"Prefer":              "respond-async",
+		})
+		if status != http.StatusAccepted {
+			t.Fatalf("status = %d, body = %s", status, got)
+		}
+		if got != `{"run_id":"run-1"}` {
+			t.Errorf("body = %s, want the submitted run id", got)
+		}
+		select {
+		case input := <-submitted:
+			if input != body {
+				t.Errorf("submitted input = %s, want %s", input, body)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("module was not submitted")
+		}
+	})
+
+	t.Run("no subscribe event", func(t *testing.T) {
+		sub, err := nc.SubscribeSync("event.jobs")
+		if err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		defer sub.Unsubscribe()
+
+		status, body := post("/hooks/jobs", `{"id":1}`, nil)
+		if status != http.StatusAccepted {
+			t.Fatalf("status = %d, body = %s", status, body)
+		}
+		var resp HookResponse
+		if err := json.Unmarshal([]byte(body), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatalf("event was not published: %v", err)
+		}
+		if string(msg.Data) != `{"id":1}` {
+			t.Errorf("data = %s", msg.Data)
+		}
+		if resp.RunID != "" {
+			t.Errorf("run id = %s, want empty", resp.RunID)
+		}
+		if got := tracer.TraceID(tracer.NewContext(msg.Header)); got == "" {
+			t.Errorf("trace id is not propagated")
+		}
+	})
+}
+
+func TestServiceError(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      nats.Header
+		wantStatus  int
+		wantCode    int
+		wantMessage string
+	}{
+		{"service code", nats.Header{"Nats-Service-Error-Code": {"400301"}, "Nats-Service-Error": {"invalid input"}}, 400, 400301, "invalid input"},
+		{"http status", nats.Header{"Nats-Service-Error-Code": {"404"}, "Nats-Service-Error": {"not found"}}, 404, 404, "not found"},
+		{"not a status", nats.Header{"Nats-Service-Error-Code": {"200100"}, "Nats-Service-Error": {"odd"}}, 500, 200100, "odd"},
+		{"not a number", nats.Header{"Nats-Service-Error-Code": {"bad"}, "Nats-Service-Error": {"oops"}}, 500, 0, "bad: oops"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, code, message := serviceError(errors.FromHeaders(tt.header))
+			if status != tt.wantStatus || code != tt.wantCode || message != tt.wantMessage {
+				t.Errorf("serviceError() = %d, %d, %q, want %d, %d, %q", status, code, message, tt.wantStatus, tt.wantCode, tt.wantMessage)
+			}
+		})
+	}
+}
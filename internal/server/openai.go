@@ -0,0 +1,361 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/jumonmd/jumon/module"
+	"github.com/nats-io/nats.go"
+)
+
+// CompletionRequest is the request of the OpenAI compatible chat completions.
+// The model is the module name, optionally with the script name. e.g. "jumonmd/jumon/example/hello#sayname".
+type CompletionRequest struct {
+	Model    string              `json:"model"`
+	Messages []CompletionMessage `json:"messages"`
+	Stream   bool                `json:"stream,omitempty"`
+}
+
+// CompletionMessage is a message of the OpenAI compatible chat completions.
+// The content is a string or an array of content parts.
+type CompletionMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// Completion is the response or the stream chunk of the OpenAI compatible chat completions.
+type Completion struct {
+	ID      string             `json:"id"`
+	Object  string             `json:"object"`
+	Created int64              `json:"created"`
+	Model   string             `json:"model"`
+	Choices []CompletionChoice `json:"choices"`
+}
+
+// CompletionChoice is a choice of the completion.
+// Message is set for the response and Delta is set for the stream chunk.
+type CompletionChoice struct {
+	Index        int                `json:"index"`
+	Message      *CompletionContent `json:"message,omitempty"`
+	Delta        *CompletionContent `json:"delta,omitempty"`
+	FinishReason *string            `json:"finish_reason"`
+}
+
+// CompletionContent is the message or the delta of the choice.
+type CompletionContent struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content"`
+}
+
+// Model is a model of the OpenAI compatible models list.
+type Model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type openAIError struct {
+	Error openAIErrorBody `json:"error"`
+}
+
+type openAIErrorBody struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    int    `json:"code,omitempty"`
+}
+
+// handleOpenAI registers the OpenAI compatible endpoints which expose the modules as models.
+func handleOpenAI(mux *http.ServeMux, nc *nats.Conn) {
+	mux.HandleFunc("POST /v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		completionsHandler(nc, w, r)
+	})
+	mux.HandleFunc("GET /v1/models", func(w http.ResponseWriter, r *http.Request) {
+		modelsHandler(nc, w, r)
+	})
+}
+
+// completionsHandler runs the module of the model with the last user message as the input
+// and the messages before it as the history of the conversation.
+// A JSON object or array content is passed to the module as is, and other content is passed as a JSON string.
+func completionsHandler(nc *nats.Conn, w http.ResponseWriter, r *http.Request) {
+	var req CompletionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, 0, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.Model == "" {
+		writeOpenAIError(w, http.StatusBadRequest, 0, "model is required")
+		return
+	}
+	chatreq, err := completionInput(req.Messages)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, 0, err.Error())
+		return
+	}
+	input, err := json.Marshal(chatreq)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, 0, err.Error())
+		return
+	}
+
+	ctx, span := tracer.Start(tracer.NewContext(r.Header), nc, "http.completion")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, runTimeout)
+	defer cancel()
+
+	base := Completion{
+		ID:      "chatcmpl-" + tracer.TraceID(ctx),
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+	}
+	msg := &nats.Msg{
+		Subject: "module.chat." + req.Model,
+		Data:    input,
+		Header:  span.Headers(),
+	}
+
+	if req.Stream {
+		streamCompletion(ctx, nc, w, msg, base)
+		return
+	}
+
+	resp, err := nc.RequestMsgWithContext(ctx, msg)
+	if err != nil {
+		span.SetError(err)
+		writeOpenAIError(w, http.StatusBadGateway, 0, err.Error())
+		return
+	}
+	if err := errors.FromHeaders(resp.Header); err != nil {
+		status, code, message := serviceError(err)
+		writeOpenAIError(w, status, code, message)
+		return
+	}
+	span.SetResponse(resp.Data)
+
+	stop := string(chat.FinishReasonStop)
+	base.Object = "chat.completion"
+	base.Choices = []CompletionChoice{{
+		Message:      &CompletionContent{Role: "assistant", Content: completionContent(resp.Data)},
+		FinishReason: &stop,
+	}}
+	writeJSON(w, http.StatusOK, base)
+}
+
+// streamCompletion runs the module with the stream-to header and writes the chat stream responses as chunks.
+// The output which is not streamed by the time of the module response is written as a single chunk.
+func streamCompletion(ctx context.Context, nc *nats.Conn, w http.ResponseWriter, msg *nats.Msg, base Completion) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, 0, "streaming is not supported")
+		return
+	}
+
+	streamTo := nats.NewInbox()
+	chunks := make(chan *nats.Msg, 256)
+	sub, err := nc.ChanSubscribe(streamTo, chunks)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, 0, err.Error())
+		return
+	}
+	defer sub.Unsubscribe()
+	msg.Header.Set(string(tracer.ContextKeyStreamTo), streamTo)
+
+	type result struct {
+		resp *nats.Msg
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := nc.RequestMsgWithContext(ctx, msg)
+		done <- result{resp, err}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	base.Object = "chat.completion.chunk"
+	writeChunk := func(delta CompletionContent, finish *string) {
+		chunk := base
+		chunk.Choices = []CompletionChoice{{Delta: &delta, FinishReason: finish}}
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		flusher.Flush()
+	}
+	writeChunk(CompletionContent{Role: "assistant"}, nil)
+
+	// streamed is the text of the chunks written so far.
+	streamed := ""
+	writeStream := func(m *nats.Msg) {
+		var sr chat.StreamResponse
+		if err := json.Unmarshal(m.Data, &sr); err != nil || sr.Content == "" {
+			return
+		}
+		// the providers stream the content deltas as "text", e.g. not the tool call deltas.
+		if sr.Type != "" && sr.Type != "text" {
+			return
+		}
+		streamed += sr.Content
+		writeChunk(CompletionContent{Content: sr.Content}, nil)
+	}
+
+	for {
+		select {
+		case m := <-chunks:
+			writeStream(m)
+		case res := <-done:
+			// write the chunks received before the module output.
+			for len(chunks) > 0 {
+				writeStream(<-chunks)
+			}
+			if res.err == nil {
+				res.err = errors.FromHeaders(res.resp.Header)
+			}
+			if res.err != nil {
+				slog.Error("http completion", "status", "run module failed", "error", res.err)
+				data, _ := json.Marshal(openAIError{Error: openAIErrorBody{Message: res.err.Error(), Type: "server_error"}})
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				flusher.Flush()
+				return
+			}
+			// the chunks are published apart from the module output and can arrive after it,
+			// so the rest of the output which is not streamed yet is written instead of them.
+			if rest, ok := strings.CutPrefix(completionContent(res.resp.Data), streamed); ok && rest != "" {
+				writeChunk(CompletionContent{Content: rest}, nil)
+			}
+			stop := string(chat.FinishReasonStop)
+			writeChunk(CompletionContent{}, &stop)
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			flusher.Flush()
+			return
+		}
+	}
+}
+
+// modelsHandler lists the stored modules as models.
+func modelsHandler(nc *nats.Conn, w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), storeTimeout)
+	defer cancel()
+
+	resp, err := nc.RequestWithContext(ctx, "module.list", nil)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadGateway, 0, err.Error())
+		return
+	}
+	if err := errors.FromHeaders(resp.Header); err != nil {
+		status, code, message := serviceError(err)
+		writeOpenAIError(w, status, code, message)
+		return
+	}
+	var names []string
+	if err := json.Unmarshal(resp.Data, &names); err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, 0, err.Error())
+		return
+	}
+
+	models := []Model{}
+	for _, name := range names {
+		models = append(models, Model{ID: name, Object: "model", OwnedBy: "jumon"})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"object": "list", "data": models})
+}
+
+// completionRoles are the chat roles of the message roles in the history.
+var completionRoles = map[string]chat.MessageRole{
+	"system":    chat.MessageRoleSystem,
+	"developer": chat.MessageRoleSystem,
+	"user":      chat.MessageRoleHuman,
+	"assistant": chat.MessageRoleAI,
+}
+
+// completionInput returns the module chat request with the last user message as the input
+// and the messages before it as the history.
+func completionInput(messages []CompletionMessage) (*module.ChatRequest, error) {
+	last := -1
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			last = i
+			break
+		}
+	}
+	if last < 0 {
+		return nil, fmt.Errorf("user message is required")
+	}
+
+	req := &module.ChatRequest{}
+	for _, m := range messages[:last] {
+		role, ok := completionRoles[m.Role]
+		if !ok {
+			return nil, fmt.Errorf("unsupported message role: %s", m.Role)
+		}
+		text, err := messageText(m.Content)
+		if err != nil {
+			return nil, err
+		}
+		req.History = append(req.History, chat.NewTextMessage(role, text))
+	}
+
+	text, err := messageText(messages[last].Content)
+	if err != nil {
+		return nil, err
+	}
+	// the input is passed as is like jumon run, e.g. a JSON object or a plain text.
+	req.Input = []byte(text)
+	return req, nil
+}
+
+// messageText returns the text of the message content, which is a string or text content parts.
+func messageText(content json.RawMessage) (string, error) {
+	// the content of an assistant message with the tool calls may be omitted.
+	if len(content) == 0 {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return "", fmt.Errorf("invalid message content: %w", err)
+	}
+	for _, part := range parts {
+		if part.Type == "text" {
+			text += part.Text
+		}
+	}
+	return text, nil
+}
+
+// completionContent returns the message content of the module output.
+// A JSON string output is unquoted, and other output is returned as JSON text.
+func completionContent(output []byte) string {
+	var s string
+	if err := json.Unmarshal(output, &s); err == nil {
+		return s
+	}
+	return string(output)
+}
+
+func writeOpenAIError(w http.ResponseWriter, status, code int, message string) {
+	typ := "invalid_request_error"
+	if status >= http.StatusInternalServerError {
+		typ = "server_error"
+	}
+	writeJSON(w, status, openAIError{Error: openAIErrorBody{Message: message, Type: typ, Code: code}})
+}
@@ -0,0 +1,284 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jumonmd/gengo/chat"
+	chatsvc "github.com/jumonmd/jumon/chat"
+	"github.com/jumonmd/jumon/internal/testutil"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/jumonmd/jumon/module"
+	"github.com/nats-io/nats.go/micro"
+)
+
+func TestCompletionInput(t *testing.T) {
+	tests := []struct {
+		name        string
+		messages    string
+		want        string
+		wantHistory []chat.Message
+		wantErr     bool
+	}{
+		{
+			name:        "text",
+			messages:    `[{"role":"system","content":"be kind"},{"role":"user","content":"hello"}]`,
+			want:        `hello`,
+			wantHistory: []chat.Message{chat.NewTextMessage(chat.MessageRoleSystem, "be kind")},
+		},
+		{
+			name:     "json object",
+			messages: `[{"role":"user","content":"{\"name\":\"jumon\"}"}]`,
+			want:     `{"name":"jumon"}`,
+		},
+		{
+			name:     "content parts",
+			messages: `[{"role":"user","content":[{"type":"text","text":"hi "},{"type":"text","text":"there"}]}]`,
+			want:     `hi there`,
+		},
+		{
+			name:     "conversation",
+			messages: `[{"role":"user","content":"first"},{"role":"assistant","content":"ok"},{"role":"user","content":"second"}]`,
+			want:     `second`,
+			wantHistory: []chat.Message{
+				chat.NewTextMessage(chat.MessageRoleHuman, "first"),
+				chat.NewTextMessage(chat.MessageRoleAI, "ok"),
+			},
+		},
+		{
+			name:     "unsupported role",
+			messages: `[{"role":"tool","content":"42"},{"role":"user","content":"hello"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "no user message",
+			messages: `[{"role":"system","content":"be kind"}]`,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var messages []CompletionMessage
+			if err := json.Unmarshal([]byte(tt.messages), &messages); err != nil {
+				t.Fatalf("failed to unmarshal messages: %v", err)
+			}
+			got, err := completionInput(messages)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("completionInput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if string(got.Input) != tt.want {
+				t.Errorf("completionInput() input = %s, want %s", got.Input, tt.want)
+			}
+			if diff := cmp.Diff(tt.wantHistory, got.History); diff != "" {
+				t.Errorf("completionInput() history mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCompletions(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// the chat service streams the responses of the test llm as the providers do.
+	// the conversation is answered with the number of the messages.
+	testllm := testutil.NewMockOpenAIServerFunc(func(req testutil.ChatCompletionRequest) string {
+		if len(req.Messages) > 1 {
+			return fmt.Sprintf("%d messages", len(req.Messages))
+		}
+		return "hello world"
+	})
+	defer testllm.Close()
+	chtsvc, err := chatsvc.NewService(nc)
+	if err != nil {
+		t.Fatalf("failed to create chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	// mock module service, it answers with a chat generation which is streamed if stream-to is set.
+	modsvc, err := micro.AddService(nc, micro.Config{
+		Name:    "test-module",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "module.>",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				if r.Subject() == "module.list" {
+					r.RespondJSON([]string{"test/echo"})
+					return
+				}
+				if r.Subject() == "module.chat.test/late" {
+					// the chunk after the first one arrives after the module output.
+					streamTo := r.Headers().Get("stream-to")
+					_ = nc.Publish(streamTo, (&chat.StreamResponse{Type: "text", Content: "hello "}).JSON())
+					_ = nc.Flush()
+					r.RespondJSON("hello world")
+					time.Sleep(50 * time.Millisecond)
+					_ = nc.Publish(streamTo, (&chat.StreamResponse{Type: "text", Content: "world"}).JSON())
+					return
+				}
+				if r.Subject() != "module.chat.test/echo" {
+					r.Error("404400", "module not found", nil)
+					return
+				}
+				var chatreq module.ChatRequest
+				if err := json.Unmarshal(r.Data(), &chatreq); err != nil {
+					r.Error("400401", err.Error(), nil)
+					return
+				}
+				req := &chat.Request{
+					Model:    "gpt-4o-mini",
+					Messages: append(chatreq.History, chat.NewTextMessage(chat.MessageRoleHuman, string(chatreq.Input))),
+				}
+				resp, err := chatsvc.Generate(tracer.NewContext(r.Headers()), nc, req, chat.WithBaseURL(testllm.URL))
+				if err != nil {
+					r.Error("500400", err.Error(), nil)
+					return
+				}
+				r.RespondJSON(resp.Messages[len(resp.Messages)-1].ContentString())
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create module service: %v", err)
+	}
+	defer modsvc.Stop()
+
+	srv := httptest.NewServer(newHTTPHandler(nc, js, &HTTPConfig{Token: "test-token"}))
+	defer srv.Close()
+
+	post := func(body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/chat/completions", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer test-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		return resp
+	}
+
+	t.Run("completion", func(t *testing.T) {
+		resp := post(`{"model":"test/echo","messages":[{"role":"user","content":"hi"}]}`)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d", resp.StatusCode)
+		}
+		var got Completion
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if got.Object != "chat.completion" || len(got.Choices) != 1 {
+			t.Fatalf("unexpected completion: %+v", got)
+		}
+		if got.Choices[0].Message.Content != "hello world" {
+			t.Errorf("content = %s, want hello world", got.Choices[0].Message.Content)
+		}
+	})
+
+	t.Run("conversation", func(t *testing.T) {
+		resp := post(`{"model":"test/echo","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"},{"role":"user","content":"bye"}]}`)
+		defer resp.Body.Close()
+		var got Completion
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(got.Choices) != 1 || got.Choices[0].Message.Content != "3 messages" {
+			t.Errorf("unexpected completion: %+v", got)
+		}
+	})
+
+	t.Run("unknown model", func(t *testing.T) {
+		resp := post(`{"model":"none","messages":[{"role":"user","content":"hi"}]}`)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", resp.StatusCode)
+		}
+	})
+
+	for _, model := range []string{"test/echo", "test/late"} {
+		t.Run("stream "+model, func(t *testing.T) {
+			resp := post(`{"model":"` + model + `","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+			defer resp.Body.Close()
+
+			var content []string
+			var finish string
+			done := false
+			sc := bufio.NewScanner(resp.Body)
+			for sc.Scan() {
+				data, ok := strings.CutPrefix(sc.Text(), "data: ")
+				if !ok {
+					continue
+				}
+				if data == "[DONE]" {
+					done = true
+					break
+				}
+				var chunk Completion
+				if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+					t.Fatalf("failed to decode chunk: %v", err)
+				}
+				delta := chunk.Choices[0].Delta
+				if delta.Content != "" {
+					content = append(content, delta.Content)
+				}
+				if chunk.Choices[0].FinishReason != nil {
+					finish = *chunk.Choices[0].FinishReason
+				}
+			}
+			if !done {
+				t.Error("stream did not end with [DONE]")
+			}
+			// the rest of the output is written when the chunks arrive after it.
+			if diff := cmp.Diff([]string{"hello ", "world"}, content); diff != "" {
+				t.Errorf("chunks mismatch (-want +got):\n%s", diff)
+			}
+			if finish != "stop" {
+				t.Errorf("finish reason = %s, want stop", finish)
+			}
+		})
+	}
+
+	t.Run("models", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/models", nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer test-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var got struct {
+			Data []Model `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		want := []Model{{ID: "test/echo", Object: "model", OwnedBy: "jumon"}}
+		if diff := cmp.Diff(want, got.Data); diff != "" {
+			t.Errorf("models mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -87,6 +87,13 @@ func Serve(disableTelemetry bool) error {
 	}
 	defer stopServices(svcs)
 
+	// Setup HTTP listener
+	hs, err := setupHTTPServer(nc, js)
+	if err != nil {
+		return fmt.Errorf("setup http server: %w", err)
+	}
+	defer shutdownHTTPServer(hs)
+
 	slog.Debug("server", "isDebug", isDebug, "disableTelemetry", disableTelemetry)
 
 	slog.Info("server is ready")
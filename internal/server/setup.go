@@ -10,6 +10,7 @@ import (
 
 	"github.com/jumonmd/jumon/chat"
 	"github.com/jumonmd/jumon/event"
+	"github.com/jumonmd/jumon/internal/secret"
 	"github.com/jumonmd/jumon/internal/version"
 	"github.com/jumonmd/jumon/module"
 	"github.com/jumonmd/jumon/script"
@@ -99,6 +100,12 @@ func setupServices(nc *nats.Conn, js jetstream.JetStream, obs jetstream.ObjectSt
 	}
 	services = append(services, eventsvc)
 
+	secretsvc, err := secret.NewService(nc)
+	if err != nil {
+		return nil, fmt.Errorf("secret service create error: %w", err)
+	}
+	services = append(services, secretsvc)
+
 	return services, nil
 }
 
@@ -117,6 +124,13 @@ func setupKV(ctx context.Context, js jetstream.JetStream) error {
 	if err != nil {
 		return fmt.Errorf("config kv create error: %w", err)
 	}
+	_, err = js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      secret.Bucket,
+		Description: "sealed secrets for jumon",
+	})
+	if err != nil {
+		return fmt.Errorf("secret kv create error: %w", err)
+	}
 	_, err = js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
 		Bucket:      "event",
 		Description: "events for jumon",
@@ -124,6 +138,22 @@ func setupKV(ctx context.Context, js jetstream.JetStream) error {
 	if err != nil {
 		return fmt.Errorf("event kv create error: %w", err)
 	}
+	_, err = js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      module.RunBucket,
+		Description: "asynchronous runs for jumon",
+		TTL:         7 * 24 * time.Hour,
+	})
+	if err != nil {
+		return fmt.Errorf("run kv create error: %w", err)
+	}
+	_, err = js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{
+		Bucket:      script.CheckpointBucket,
+		Description: "script checkpoints for jumon",
+		TTL:         7 * 24 * time.Hour,
+	})
+	if err != nil {
+		return fmt.Errorf("checkpoint kv create error: %w", err)
+	}
 
 	return nil
 }
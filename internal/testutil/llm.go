@@ -5,8 +5,10 @@ package testutil
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 )
 
 type ChatMessage struct {
@@ -16,6 +18,7 @@ type ChatMessage struct {
 
 type ChatCompletionRequest struct {
 	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
 }
 
 type ChatCompletionChoice struct {
@@ -35,6 +38,13 @@ type MockOpenAIServer struct {
 // NewMockOpenAIServer creates a new mock OpenAI API server.
 // It extracts the last word from the input as a name and returns "Hello! {name}. How can I help you.".
 func NewMockOpenAIServer() *MockOpenAIServer {
+	return NewMockOpenAIServerFunc(func(req ChatCompletionRequest) string {
+		return "hello"
+	})
+}
+
+// NewMockOpenAIServerFunc creates a new mock OpenAI API server which answers with the content returned by fn.
+func NewMockOpenAIServerFunc(fn func(req ChatCompletionRequest) string) *MockOpenAIServer {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Only handle chat completion endpoint
 		if r.URL.Path == "/v1/chat/completions" {
@@ -49,12 +59,17 @@ func NewMockOpenAIServer() *MockOpenAIServer {
 				return
 			}
 
+			if req.Stream {
+				writeStream(w, fn(req))
+				return
+			}
+
 			resp := ChatCompletionResponse{
 				Choices: []ChatCompletionChoice{
 					{
 						Message: ChatMessage{
 							Role:    "ai",
-							Content: "hello",
+							Content: fn(req),
 						},
 						FinishReason: "stop",
 					},
@@ -75,6 +90,20 @@ func NewMockOpenAIServer() *MockOpenAIServer {
 	}
 }
 
+// writeStream writes the content as the server-sent events of the chat completion chunks, a chunk per word.
+func writeStream(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	for _, word := range strings.SplitAfter(content, " ") {
+		data, _ := json.Marshal(map[string]any{
+			"object":  "chat.completion.chunk",
+			"choices": []map[string]any{{"index": 0, "delta": map[string]string{"content": word}}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
 func (m *MockOpenAIServer) Close() {
 	if m.Server != nil {
 		m.Server.Close()
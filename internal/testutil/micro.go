@@ -5,6 +5,7 @@ package testutil
 
 import (
 	"log/slog"
+	"sync"
 
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
@@ -26,3 +27,26 @@ func NewMicroServer(nc *nats.Conn, subject string, resp []byte) (micro.Service,
 		},
 	})
 }
+
+// NewMicroServerSequence creates a test NATS micro server that responds with resps in order.
+// The last response is repeated once all responses are used.
+func NewMicroServerSequence(nc *nats.Conn, subject string, resps [][]byte) (micro.Service, error) {
+	var mu sync.Mutex
+	count := 0
+	return micro.AddService(nc, micro.Config{
+		Name:        "test-service",
+		Version:     "0.1.0",
+		Description: "test service",
+		Endpoint: &micro.EndpointConfig{
+			Subject: subject,
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				mu.Lock()
+				resp := resps[min(count, len(resps)-1)]
+				count++
+				mu.Unlock()
+				slog.Debug("test-service received request", "subject", r.Subject())
+				r.Respond(resp)
+			}),
+		},
+	})
+}
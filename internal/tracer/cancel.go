@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package tracer
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/nats-io/nats.go"
+)
+
+const cancelSubject = "cancel"
+
+// ErrCancelled is the cause of the context cancelled by the cancel request of the trace.
+var ErrCancelled = errors.New("run cancelled")
+
+// CancelSubject returns the subject to cancel all the spans of the trace ID.
+func CancelSubject(traceID string) string {
+	return cancelSubject + "." + traceID
+}
+
+// Cancel requests to cancel all the spans of the trace ID.
+func Cancel(nc *nats.Conn, traceID string) error {
+	return nc.Publish(CancelSubject(traceID), nil)
+}
+
+// WithCancel returns the context which is cancelled with ErrCancelled when the cancel of the trace is requested.
+// The errors of the span after the cancellation are recorded with the cancelled status.
+// stop must be called when the span work is done.
+func (t *SpanTracer) WithCancel(ctx context.Context) (_ context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	t.ctx = ctx
+
+	sub, err := t.nc.Subscribe(CancelSubject(t.span.TraceID), func(*nats.Msg) {
+		slog.Info("tracer", "event", "cancel", "trace_id", t.span.TraceID, "name", t.span.Name)
+		cancel(ErrCancelled)
+	})
+	if err != nil {
+		slog.Warn("tracer", "event", "subscribe cancel", "error", err)
+	}
+	return ctx, func() {
+		if sub != nil {
+			_ = sub.Unsubscribe()
+		}
+		cancel(nil)
+	}
+}
+
+// Cancelled reports whether the context is cancelled by the cancel request of the trace.
+func Cancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrCancelled)
+}
+
+// WithTraceID returns the context with a traceparent of the trace ID.
+// It is used to continue the trace of the previous run, e.g. to resume it.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	tp := newTraceParent(true)
+	tp.TraceID = traceID
+	return context.WithValue(ctx, ContextKeyTraceParent, tp.String())
+}
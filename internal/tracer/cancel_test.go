@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jumonmd/jumon/internal/testutil"
+)
+
+func TestWithCancel(t *testing.T) {
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	ctx, span := Start(context.Background(), nc, "parent")
+	ctx, stop := span.WithCancel(ctx)
+	defer stop()
+
+	// the child span of the trace is cancelled with the parent.
+	_, child := Start(NewContext(HeadersFromContext(ctx)), nc, "child")
+	cctx, cstop := child.WithCancel(context.Background())
+	defer cstop()
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	if err := Cancel(nc, span.TraceID()); err != nil {
+		t.Fatalf("failed to cancel: %v", err)
+	}
+	for _, c := range []context.Context{ctx, cctx} {
+		select {
+		case <-c.Done():
+		case <-time.After(time.Second):
+			t.Fatal("context is not cancelled")
+		}
+		if !Cancelled(c) {
+			t.Errorf("cause = %v, want ErrCancelled", context.Cause(c))
+		}
+	}
+
+	child.SetError(fmt.Errorf("run child: %w", context.Canceled))
+	if child.span.Status != StatusError || child.span.Attributes[AttributeCancelled] != "true" {
+		t.Errorf("status = %d, attributes = %v, want cancelled error", child.span.Status, child.span.Attributes)
+	}
+
+	// stop does not cancel the context as ErrCancelled.
+	_, other := Start(context.Background(), nc, "other")
+	octx, ostop := other.WithCancel(context.Background())
+	ostop()
+	if Cancelled(octx) {
+		t.Error("stopped context must not be cancelled by the cancel request")
+	}
+}
@@ -18,18 +18,20 @@ type ContextKey string
 const (
 	ContextKeyTraceParent ContextKey = "traceparent"
 	ContextKeyNotifyTo    ContextKey = "notify-to"
+	ContextKeyStreamTo    ContextKey = "stream-to"
 )
 
 type Headers interface {
 	Get(name string) string
 }
 
-// NewContext creates a new context with the traceparent and notify-to headers.
+// NewContext creates a new context with the traceparent, notify-to and stream-to headers.
 func NewContext(h Headers) context.Context {
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, ContextKeyTraceParent, h.Get("traceparent"))
 	ctx = context.WithValue(ctx, ContextKeyNotifyTo, h.Get("notify-to"))
-	slog.Debug("new context", "traceparent", h.Get("traceparent"), "notify-to", h.Get("notify-to"))
+	ctx = context.WithValue(ctx, ContextKeyStreamTo, h.Get("stream-to"))
+	slog.Debug("new context", "traceparent", h.Get("traceparent"), "notify-to", h.Get("notify-to"), "stream-to", h.Get("stream-to"))
 	return ctx
 }
 
@@ -38,6 +40,7 @@ func HeadersFromContext(ctx context.Context) nats.Header {
 	return nats.Header{
 		"traceparent": {ContextValueTraceParent(ctx)},
 		"notify-to":   {ContextValueNotifyTo(ctx)},
+		"stream-to":   {ContextValueStreamTo(ctx)},
 	}
 }
 
@@ -56,6 +59,21 @@ func ContextValueNotifyTo(ctx context.Context) string {
 	return notifyTo
 }
 
+// WithStreamTo returns the context with the subject where the chat stream responses are published.
+// An empty subject disables the streaming.
+func WithStreamTo(ctx context.Context, streamTo string) context.Context {
+	return context.WithValue(ctx, ContextKeyStreamTo, streamTo)
+}
+
+// ContextValueStreamTo returns the subject where the chat stream responses are published.
+func ContextValueStreamTo(ctx context.Context) string {
+	streamTo, ok := ctx.Value(ContextKeyStreamTo).(string)
+	if !ok {
+		return ""
+	}
+	return streamTo
+}
+
 func ContextValueTraceParent(ctx context.Context) string {
 	tp, ok := ctx.Value(ContextKeyTraceParent).(string)
 	if !ok {
@@ -63,3 +81,13 @@ func ContextValueTraceParent(ctx context.Context) string {
 	}
 	return tp
 }
+
+// TraceID returns the trace ID of the traceparent in the context.
+// It returns an empty string if the context has no valid traceparent.
+func TraceID(ctx context.Context) string {
+	tp, err := extractTraceparent(ContextValueTraceParent(ctx))
+	if err != nil {
+		return ""
+	}
+	return tp.TraceID
+}
@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package tracer
+
+import (
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestContextHeaders(t *testing.T) {
+	h := nats.Header{}
+	h.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	h.Set("notify-to", "n1")
+	h.Set("stream-to", "_INBOX.s1")
+
+	ctx := NewContext(h)
+	if got := TraceID(ctx); got != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("TraceID() = %s", got)
+	}
+
+	// the values are passed to the next span
+	ctx, span := Start(ctx, nil, "test")
+	for _, got := range []nats.Header{HeadersFromContext(ctx), span.Headers()} {
+		if got.Get("notify-to") != "n1" || got.Get("stream-to") != "_INBOX.s1" {
+			t.Errorf("headers = %v", got)
+		}
+		if TraceID(NewContext(got)) != "0af7651916cd43dd8448eb211c80319c" {
+			t.Errorf("trace id is not passed: %v", got)
+		}
+	}
+
+	if got := HeadersFromContext(WithStreamTo(ctx, "")).Get("stream-to"); got != "" {
+		t.Errorf("stream-to = %q, want empty", got)
+	}
+}
@@ -20,6 +20,8 @@ const traceSubject = "trace"
 
 type SpanTracer struct {
 	nc *nats.Conn
+	// ctx is the context of the span to detect the cancellation.
+	ctx context.Context
 	// current span
 	span *Span
 	// nextTransparent is the next transparent passthrough to the next header.
@@ -27,6 +29,8 @@ type SpanTracer struct {
 	// notifyTo is the subject to notify the span end event.
 	// if empty, the span end event is not notified.
 	notifyTo string
+	// streamTo is the subject to publish the chat stream responses.
+	streamTo string
 }
 
 type Notification struct {
@@ -34,7 +38,7 @@ type Notification struct {
 	SpanID   string `json:"span_id"`
 	ParentID string `json:"parent_id"`
 	// On is the event type.
-	//  e.g. "request", "response", "error".
+	//  e.g. "request", "response", "error", "cancelled".
 	On      string    `json:"on"`
 	Date    time.Time `json:"date"`
 	Name    string    `json:"name"`
@@ -46,6 +50,7 @@ type Notification struct {
 func Start(ctx context.Context, nc *nats.Conn, name string) (context.Context, *SpanTracer) {
 	traceparent := ContextValueTraceParent(ctx)
 	notifyTo := ContextValueNotifyTo(ctx)
+	streamTo := ContextValueStreamTo(ctx)
 	tp, err := extractTraceparent(traceparent)
 	if err != nil {
 		// if traceparent is not provided, create a new one
@@ -57,7 +62,7 @@ func Start(ctx context.Context, nc *nats.Conn, name string) (context.Context, *S
 
 	tp.ParentID = span.SpanID
 	ctx = context.WithValue(ctx, ContextKeyTraceParent, tp.String())
-	return ctx, &SpanTracer{nc: nc, span: span, nextTransparent: tp.String(), notifyTo: notifyTo}
+	return ctx, &SpanTracer{nc: nc, ctx: ctx, span: span, nextTransparent: tp.String(), notifyTo: notifyTo, streamTo: streamTo}
 }
 
 func (t *SpanTracer) SetRequest(data any) {
@@ -82,8 +87,11 @@ func (t *SpanTracer) SetResponse(data any) {
 }
 
 func (t *SpanTracer) SetError(err error) {
-	slog.Error("error", "message", err.Error())
+	slog.Error("error", "message", Redact(err.Error()))
 	t.span.Status = StatusError
+	if Cancelled(t.ctx) {
+		t.span.SetAttribute(AttributeCancelled, "true")
+	}
 	t.span.SetAttribute("error", err.Error())
 	t.span.StatusCode = parseStatuCode(err)
 	err = t.Notify(err)
@@ -92,6 +100,11 @@ func (t *SpanTracer) SetError(err error) {
 	}
 }
 
+// SetAttribute sets the attribute of the span. e.g. the decision of a condition.
+func (t *SpanTracer) SetAttribute(key, value string) {
+	t.span.SetAttribute(key, value)
+}
+
 func (t *SpanTracer) End() {
 	t.span.End()
 	subject := fmt.Sprintf("%s.%s.%s", traceSubject, t.span.TraceID, t.span.SpanID)
@@ -101,16 +114,23 @@ func (t *SpanTracer) End() {
 	}
 }
 
+// TraceID returns the trace ID of the span.
+func (t *SpanTracer) TraceID() string {
+	return t.span.TraceID
+}
+
 func (t *SpanTracer) Headers() map[string][]string {
 	return map[string][]string{
 		string(ContextKeyTraceParent): {t.nextTransparent},
 		string(ContextKeyNotifyTo):    {t.notifyTo},
+		string(ContextKeyStreamTo):    {t.streamTo},
 	}
 }
 
 func (t *SpanTracer) NextContext(ctx context.Context) context.Context {
 	ctx = context.WithValue(ctx, ContextKeyTraceParent, t.nextTransparent)
-	return context.WithValue(ctx, ContextKeyNotifyTo, t.notifyTo)
+	ctx = context.WithValue(ctx, ContextKeyNotifyTo, t.notifyTo)
+	return context.WithValue(ctx, ContextKeyStreamTo, t.streamTo)
 }
 
 func convertToString(data any) string {
@@ -142,7 +162,7 @@ func (t *SpanTracer) Notify(data any) error {
 		return nil
 	}
 
-	content := convertToString(data)
+	content := Redact(convertToString(data))
 	n := Notification{
 		TraceID:  t.span.TraceID,
 		SpanID:   t.span.SpanID,
@@ -157,6 +177,9 @@ func (t *SpanTracer) Notify(data any) error {
 	}
 	if t.span.Status == StatusError {
 		n.On = "error"
+		if t.span.Attributes[AttributeCancelled] == "true" {
+			n.On = "cancelled"
+		}
 	}
 	nd, err := json.Marshal(n)
 	if err != nil {
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package tracer
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// redacted replaces the secret values in the spans and the notifications.
+const redacted = "[REDACTED]"
+
+// secrets are the secret values resolved in this process, longest first.
+var secrets = struct {
+	sync.RWMutex
+	values []string
+}{}
+
+// AddSecret registers the secret values to be redacted from the spans and the notifications.
+func AddSecret(values ...string) {
+	secrets.Lock()
+	defer secrets.Unlock()
+	for _, v := range values {
+		if v == "" || slices.Contains(secrets.values, v) {
+			continue
+		}
+		secrets.values = append(secrets.values, v)
+	}
+	// the longer values are replaced first not to leave a part of them.
+	sort.Slice(secrets.values, func(i, j int) bool {
+		return len(secrets.values[i]) > len(secrets.values[j])
+	})
+}
+
+// Redact replaces the registered secret values in s.
+func Redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, v := range secrets.values {
+		s = strings.ReplaceAll(s, v, redacted)
+	}
+	return s
+}
@@ -0,0 +1,22 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package tracer
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	AddSecret("sk-abc", "sk-abcdef", "")
+
+	got := Redact(`{"url":"https://example.com/v1?key=sk-abcdef","token":"sk-abc"}`)
+	want := `{"url":"https://example.com/v1?key=[REDACTED]","token":"[REDACTED]"}`
+	if got != want {
+		t.Errorf("Redact() = %s, want %s", got, want)
+	}
+
+	span := NewSpan("trace", "", "tool.run", SpanKindInternal)
+	span.SetAttribute("request", "Bearer sk-abc")
+	if got := span.Attributes["request"]; got != "Bearer [REDACTED]" {
+		t.Errorf("SetAttribute() = %s", got)
+	}
+}
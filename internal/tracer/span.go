@@ -76,6 +76,9 @@ const (
 	StatusError Status = 2
 )
 
+// AttributeCancelled is the attribute of the error span cancelled by the cancel request of the trace.
+const AttributeCancelled = "cancelled"
+
 // Span is based on OpenTelemetry Span.
 type Span struct {
 	TraceID    string            `json:"trace_id"`
@@ -129,5 +132,5 @@ func (s *Span) SetStatusError() {
 }
 
 func (s *Span) SetAttribute(key, value string) {
-	s.Attributes[key] = value
+	s.Attributes[key] = Redact(value)
 }
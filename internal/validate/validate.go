@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+// Package validate validates JSON data against JSON schemas.
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jumonmd/gengo/jsonschema"
+	sjsonschema "github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// Error is a schema validation error with the failing locations of the data.
+type Error struct {
+	Violations []Violation
+}
+
+// Violation is a failing location of the data.
+type Violation struct {
+	// Pointer is the JSON pointer to the failing value. e.g. "/items/0/name".
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	parts := []string{}
+	for _, v := range e.Violations {
+		parts = append(parts, fmt.Sprintf("at '%s': %s", v.Pointer, v.Message))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// JSON validates data against the schema.
+// It returns an [*Error] naming each failing JSON pointer if the data does not match the schema.
+func JSON(schema jsonschema.Schema, data []byte) error {
+	if len(schema) == 0 {
+		return nil
+	}
+	if !json.Valid(data) {
+		return &Error{Violations: []Violation{{Pointer: "", Message: "invalid JSON"}}}
+	}
+
+	err := schema.Validate(data)
+	if err == nil {
+		return nil
+	}
+
+	var verr *sjsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return fmt.Errorf("validate schema: %w", err)
+	}
+
+	out := verr.BasicOutput()
+	verrs := &Error{}
+	for _, unit := range out.Errors {
+		if unit.Error == nil {
+			continue
+		}
+		msg, err := json.Marshal(unit.Error)
+		if err != nil {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(msg, &s); err != nil {
+			continue
+		}
+		verrs.Violations = append(verrs.Violations, Violation{Pointer: unit.InstanceLocation, Message: s})
+	}
+	if len(verrs.Violations) == 0 {
+		verrs.Violations = append(verrs.Violations, Violation{Pointer: "", Message: verr.Error()})
+	}
+
+	return verrs
+}
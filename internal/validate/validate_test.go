@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package validate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jumonmd/gengo/jsonschema"
+)
+
+func TestJSON(t *testing.T) {
+	schema := jsonschema.Schema{
+		"type": "object",
+		"properties": map[string]any{
+			"name": map[string]any{"type": "string"},
+			"age":  map[string]any{"type": "integer"},
+		},
+		"required": []any{"name"},
+	}
+
+	tests := []struct {
+		name        string
+		schema      jsonschema.Schema
+		data        string
+		wantPointer string
+		wantErr     bool
+	}{
+		{
+			name:   "valid",
+			schema: schema,
+			data:   `{"name":"jumon","age":1}`,
+		},
+		{
+			name:        "missing property",
+			schema:      schema,
+			data:        `{"age":1}`,
+			wantPointer: "",
+			wantErr:     true,
+		},
+		{
+			name:        "wrong type",
+			schema:      schema,
+			data:        `{"name":"jumon","age":"one"}`,
+			wantPointer: "/age",
+			wantErr:     true,
+		},
+		{
+			name:        "invalid json",
+			schema:      schema,
+			data:        `hello`,
+			wantPointer: "",
+			wantErr:     true,
+		},
+		{
+			name:   "empty schema",
+			schema: nil,
+			data:   `hello`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := JSON(tt.schema, []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("JSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				return
+			}
+
+			var verr *Error
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected *Error, got %T", err)
+			}
+			if verr.Violations[0].Pointer != tt.wantPointer {
+				t.Errorf("expected pointer %q, got %q (%v)", tt.wantPointer, verr.Violations[0].Pointer, err)
+			}
+		})
+	}
+}
@@ -31,10 +31,58 @@ var CLI struct {
 	} `cmd:"" help:"Initialize the JUMON.md."`
 
 	Run struct {
-		Name  string `arg:"" name:"url_or_path" help:"URL or Path to the jumon script."`
-		Input string `arg:"" optional:"" name:"input" help:"Input to the module."`
+		Name      string   `arg:"" name:"url_or_path" help:"URL or Path to the jumon script."`
+		Input     string   `arg:"" optional:"" name:"input" help:"Input to the module."`
+		Detach    bool     `help:"Run the module in the background and print the run ID." default:"false"`
+		NoStream  bool     `help:"Print the model responses after each step instead of streaming them." default:"false"`
+		File      []string `help:"File to attach to the first message, as path or name=path. Images are supported, and PDFs and audio with the Gemini models." sep:"none"`
+		InputFile string   `help:"Read the input from the file. stdin is read when it is not a terminal and no input is given."`
+		Var       []string `help:"Set the key=value variable to the JSON input. The value is typed by the input schema." sep:"none"`
+		Script    string   `help:"Name of the script to run instead of the #script suffix."`
+		Output    string   `help:"Print only the final output to stdout as json, text or raw. The progress is printed to stderr." enum:",json,text,raw" default:"" placeholder:"MODE"`
 	} `cmd:"" help:"Run the module."`
 
+	Status struct {
+		ID string `arg:"" name:"id" help:"Run ID."`
+	} `cmd:"" help:"Show the status and the output of the run."`
+
+	Resume struct {
+		ID string `arg:"" name:"run-id" help:"Run ID."`
+	} `cmd:"" help:"Resume the run from the first unfinished step and print the run ID."`
+
+	Cancel struct {
+		ID string `arg:"" name:"run-id" help:"Run ID."`
+	} `cmd:"" help:"Cancel the running run."`
+
+	Config struct {
+		Get struct {
+			Key   string `arg:"" name:"key" help:"Config key."`
+			Scope string `help:"Resolve the value for the scope, e.g. module=<name>."`
+		} `cmd:"" help:"Show the config value."`
+		Set struct {
+			Key   string `arg:"" name:"key" help:"Config key."`
+			Value string `arg:"" name:"value" help:"Config value."`
+			Scope string `help:"Set the value only for the scope, e.g. module=<name>. Only DefaultModel, MaxToolCalls and ToolTimeout can be set for a module."`
+		} `cmd:"" help:"Set the config value."`
+		Unset struct {
+			Key   string `arg:"" name:"key" help:"Config key."`
+			Scope string `help:"Unset the value of the scope, e.g. module=<name>."`
+		} `cmd:"" help:"Unset the config value to use the default."`
+		List struct{} `cmd:"" help:"List the config keys and values."`
+	} `cmd:"" help:"Manage the server config."`
+
+	Secret struct {
+		Set struct {
+			Name   string   `arg:"" name:"name" help:"Secret name, e.g. OPENAI_API_KEY."`
+			Value  string   `arg:"" name:"value" optional:"" help:"Secret value. Read from stdin if omitted."`
+			Module []string `help:"Module which can refer the secret in its tool arguments. Repeat for more modules." sep:"none"`
+		} `cmd:"" help:"Set the secret."`
+		List struct{} `cmd:"" help:"List the secret names and their modules."`
+		Rm   struct {
+			Name string `arg:"" name:"name" help:"Secret name."`
+		} `cmd:"" help:"Remove the secret."`
+	} `cmd:"" help:"Manage the secrets referred as {{secret.NAME}}."`
+
 	Version struct{} `cmd:"" help:"Show the version."`
 }
 
@@ -56,21 +104,60 @@ func main() {
 		}
 	case "init <name>":
 		err = module.InitModule(CLI.Init.Name)
-	case "run <url_or_path>":
-		cfg, err := client.LoadConfig(client.DefaultConfigPath())
+	case "run <url_or_path>", "run <url_or_path> <input>":
+		cfg, cerr := client.LoadConfig(client.DefaultConfigPath())
+		if cerr != nil {
+			log.Println(cerr)
+		}
+		if werr := client.WaitServer(os.Args[0], cfg.ServerURL); werr != nil {
+			log.Println(werr)
+		}
+		var input []byte
+		input, err = client.ReadInput(CLI.Run.Input, CLI.Run.InputFile, os.Stdin)
 		if err != nil {
-			log.Println(err)
+			break
 		}
-		if err := client.WaitServer(os.Args[0], cfg.ServerURL); err != nil {
-			log.Println(err)
+		opts := client.RunOptions{
+			Stream: !CLI.Run.NoStream,
+			Files:  CLI.Run.File,
+			Script: CLI.Run.Script,
+			Vars:   CLI.Run.Var,
+			Output: CLI.Run.Output,
 		}
-		if err := client.Run(CLI.Run.Name, []byte(CLI.Run.Input)); err != nil {
-			log.Println(err)
+		run := client.Run
+		if CLI.Run.Detach {
+			if len(CLI.Run.File) > 0 {
+				err = fmt.Errorf("--file is not supported with --detach")
+				break
+			}
+			run = client.Submit
 		}
+		err = run(CLI.Run.Name, input, opts)
+	case "status <id>":
+		err = client.Status(CLI.Status.ID)
+	case "resume <run-id>":
+		err = client.Resume(CLI.Resume.ID)
+	case "cancel <run-id>":
+		err = client.Cancel(CLI.Cancel.ID)
+	case "config get <key>":
+		err = client.ConfigGet(CLI.Config.Get.Key, CLI.Config.Get.Scope)
+	case "config set <key> <value>":
+		err = client.ConfigSet(CLI.Config.Set.Key, CLI.Config.Set.Value, CLI.Config.Set.Scope)
+	case "config unset <key>":
+		err = client.ConfigUnset(CLI.Config.Unset.Key, CLI.Config.Unset.Scope)
+	case "config list":
+		err = client.ConfigList()
+	case "secret set <name>", "secret set <name> <value>":
+		err = client.SecretSet(CLI.Secret.Set.Name, CLI.Secret.Set.Value, CLI.Secret.Set.Module, os.Stdin)
+	case "secret list":
+		err = client.SecretList()
+	case "secret rm <name>":
+		err = client.SecretRm(CLI.Secret.Rm.Name)
 	case "version":
 		fmt.Println(version.Version)
 	}
 	if err != nil {
 		log.Println(err)
+		os.Exit(client.ExitCode(err))
 	}
 }
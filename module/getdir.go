@@ -10,11 +10,13 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/jumonmd/jumon/event"
 	"github.com/nats-io/nats.go/jetstream"
 )
 
 // GetByDir loads a module from a local directory and stores it.
-func GetByDir(ctx context.Context, kv jetstream.KeyValue, dir string) (*Module, error) {
+// The events defined in the module are reconciled into the event key value store.
+func GetByDir(ctx context.Context, js jetstream.JetStream, dir string) (*Module, error) {
 	slog.Info("jumon get by dir", "dir", dir)
 	moddata, err := os.ReadFile(filepath.Join(dir, "JUMON.md"))
 	if err != nil {
@@ -29,6 +31,15 @@ func GetByDir(ctx context.Context, kv jetstream.KeyValue, dir string) (*Module,
 		return nil, fmt.Errorf("validate module: %w", err)
 	}
 
+	err = event.SyncEvents(ctx, js, mod.Name, mod.Events)
+	if err != nil {
+		return nil, fmt.Errorf("sync events: %w", err)
+	}
+
+	kv, err := js.KeyValue(ctx, "module")
+	if err != nil {
+		return nil, fmt.Errorf("get keyvalue: %w", err)
+	}
 	_, err = kv.Put(ctx, mod.Name, moddata)
 	if err != nil {
 		return nil, fmt.Errorf("put module failed: %w", err)
@@ -17,7 +17,7 @@ import (
 )
 
 // GetByGit fetches a module from a git repository and stores it to the keyvalue store.
-func GetByGit(ctx context.Context, kv jetstream.KeyValue, module string) (*Module, error) {
+func GetByGit(ctx context.Context, js jetstream.JetStream, module string) (*Module, error) {
 	slog.Info("jumon get by git", "module", module)
 	repo, path, err := getVCSPath(module)
 	if err != nil {
@@ -34,7 +34,7 @@ func GetByGit(ctx context.Context, kv jetstream.KeyValue, module string) (*Modul
 		return nil, fmt.Errorf("failed to git sparse checkout: %w", err)
 	}
 	slog.Debug("checkout directory", "dir", checkoutDir)
-	return GetByDir(ctx, kv, checkoutDir)
+	return GetByDir(ctx, js, checkoutDir)
 }
 
 // getVCSPath extracts repository URL and path from a module path.
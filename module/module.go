@@ -6,6 +6,7 @@ package module
 import (
 	"fmt"
 
+	"github.com/jumonmd/jumon/event"
 	"github.com/jumonmd/jumon/script"
 	"github.com/jumonmd/jumon/tool"
 )
@@ -22,6 +23,8 @@ type Module struct {
 	Name    string           `json:"module"`
 	Scripts []*script.Script `json:"scripts"`
 	Tools   []tool.Tool      `json:"tools,omitempty"`
+	// Events are the events defined in the module. They are owned by the module.
+	Events []event.Event `json:"events,omitempty"`
 }
 
 func (m *Module) Validate() error {
@@ -35,10 +38,44 @@ func (m *Module) Validate() error {
 		if s.Name == "" {
 			return fmt.Errorf("script name is required")
 		}
+		if err := s.ValidateVars(); err != nil {
+			return fmt.Errorf("script %s: %w", s.Name, err)
+		}
+		if err := s.Config.Validate(); err != nil {
+			return fmt.Errorf("script %s: %w", s.Name, err)
+		}
+	}
+	for _, e := range m.Events {
+		if err := e.Validate(); err != nil {
+			return fmt.Errorf("event %s: %w", e.Subject, err)
+		}
+	}
+	// a script subscribed to its own output would run again for each output forever.
+	for _, p := range m.Events {
+		if p.Type != event.EventTypePublish {
+			continue
+		}
+		for _, e := range m.Events {
+			if e.Type == event.EventTypePublish || e.Type == event.EventTypeSchedule {
+				continue
+			}
+			if e.Subject == p.Subject && eventScript(e) == eventScript(p) {
+				return fmt.Errorf("event %s: script %s subscribes to its own output", e.Subject, eventScript(e))
+			}
+		}
 	}
 	return nil
 }
 
+// eventScript returns the script name of the event module, or "main" if it is not specified.
+func eventScript(e event.Event) string {
+	_, name := extractModScriptName(e.Module)
+	if name == "" {
+		return "main"
+	}
+	return name
+}
+
 // GetScript returns the script with the given name or the main script if name is empty.
 func (m *Module) GetScript(name string) *script.Script {
 	if name == "" {
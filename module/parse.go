@@ -4,11 +4,17 @@
 package module
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"strconv"
 	"strings"
 
+	"github.com/goccy/go-yaml"
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/gengo/jsonschema"
+	"github.com/jumonmd/jumon/event"
 	"github.com/jumonmd/jumon/internal/frontmatter"
 	"github.com/jumonmd/jumon/script"
 	"github.com/jumonmd/jumon/tool"
@@ -39,6 +45,10 @@ func ParseMarkdown(markdown []byte) (*Module, error) {
 	}
 
 	mod.Name = fm.Name
+	for i := range mod.Events {
+		mod.Events[i].Owner = mod.Name
+		mod.Events[i].Module = mod.Name + mod.Events[i].Module
+	}
 
 	return mod, nil
 }
@@ -76,6 +86,11 @@ func handleLevel3Heading(mod *Module, node *ast.Heading, r text.Reader, currentS
 			Name:    name,
 			Content: content,
 		}
+		err := parseScriptMeta(newScript)
+		if err != nil {
+			slog.Error("failed to parse script metadata", "script", name, "error", err)
+			return fmt.Errorf("parse script metadata %s: %w", name, err)
+		}
 		mod.Scripts = append(mod.Scripts, newScript)
 
 	case SectionTools:
@@ -105,10 +120,118 @@ func handleLevel3Heading(mod *Module, node *ast.Heading, r text.Reader, currentS
 
 		tl.Name = name
 		mod.Tools = append(mod.Tools, tl)
+
+	case SectionEvents:
+		content := getNodeHeadingContent(node, r)
+		m := getMap([]byte(content))
+		evt := event.Event{
+			Type:       event.Type(m["type"]),
+			Subject:    name,
+			DeadLetter: m["dead_letter"],
+			Schedule:   m["schedule"],
+			Timezone:   m["timezone"],
+		}
+		if v := m["max_deliver"]; v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid max_deliver of event %s: %w", name, err)
+			}
+			evt.MaxDeliver = n
+		}
+		if evt.Type == "" {
+			evt.Type = event.EventTypeSubscribe
+		}
+		// module name is prepended after the frontmatter is parsed.
+		if m["script"] != "" {
+			evt.Module = "#" + m["script"]
+		}
+		slog.Debug("parsed event", "event", evt)
+		mod.Events = append(mod.Events, evt)
+	}
+	return nil
+}
+
+// scriptMeta is the metadata block of a script.
+type scriptMeta struct {
+	Description  string            `json:"description"`
+	Model        string            `json:"model"`
+	ModelConfig  *chat.ModelConfig `json:"model_config"`
+	InputSchema  jsonschema.Schema `json:"input_schema"`
+	OutputSchema jsonschema.Schema `json:"output_schema"`
+	Config       script.Config     `json:"config"`
+}
+
+// parseScriptMeta sets the script fields from the leading metadata block and strips it from the content.
+// The metadata block is a fenced code block with the "yaml" or "json" language.
+// e.g.
+// > ### main
+// > ```yaml
+// > model: gpt-4o-mini
+// > config:
+// >   timeout_seconds: 60
+// > ```
+// > 1. Say hello.
+func parseScriptMeta(scr *script.Script) error {
+	lang, block, body := splitMetaBlock(scr.Content)
+	if lang == "" {
+		return nil
+	}
+
+	data := []byte(block)
+	if lang != "json" {
+		var err error
+		data, err = yaml.YAMLToJSON(data)
+		if err != nil {
+			return fmt.Errorf("convert yaml: %w", err)
+		}
+	}
+
+	meta := scriptMeta{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&meta); err != nil {
+		return fmt.Errorf("unmarshal metadata: %w", err)
+	}
+	if meta.InputSchema != nil && !meta.InputSchema.IsValid() {
+		return fmt.Errorf("invalid input schema")
+	}
+	if meta.OutputSchema != nil && !meta.OutputSchema.IsValid() {
+		return fmt.Errorf("invalid output schema")
 	}
+
+	scr.Description = meta.Description
+	scr.Model = meta.Model
+	scr.ModelConfig = meta.ModelConfig
+	scr.InputSchema = meta.InputSchema
+	scr.OutputSchema = meta.OutputSchema
+	scr.Config = meta.Config
+	scr.Content = body
 	return nil
 }
 
+// splitMetaBlock splits the leading fenced metadata block from the content.
+// lang is empty if the content does not start with a yaml or json block.
+func splitMetaBlock(content string) (lang, block, body string) {
+	if !strings.HasPrefix(content, "```") {
+		return "", "", content
+	}
+
+	lines := strings.Split(content, "\n")
+	lang = strings.TrimSpace(strings.TrimPrefix(lines[0], "```"))
+	if lang != "yaml" && lang != "yml" && lang != "json" {
+		return "", "", content
+	}
+
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "```" {
+			block = strings.Join(lines[1:i], "\n")
+			body = strings.TrimSpace(strings.Join(lines[i+1:], "\n"))
+			return lang, block, body
+		}
+	}
+	return "", "", content
+}
+
 func parseMarkdown(doc ast.Node, r text.Reader) (*Module, error) {
 	mod := &Module{}
 	currentSection := ""
@@ -10,6 +10,9 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/gengo/jsonschema"
+	"github.com/jumonmd/jumon/event"
 	"github.com/jumonmd/jumon/script"
 	"github.com/jumonmd/jumon/tool"
 	"github.com/yuin/goldmark"
@@ -124,6 +127,50 @@ func TestParse(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:     "events",
+			testFile: "events.md",
+			want: &Module{
+				Name: "events",
+				Scripts: []*script.Script{
+					{Name: "main", Content: "1. Summarize the issue."},
+					{Name: "notify", Content: "1. Write a notification."},
+				},
+				Events: []event.Event{
+					{Type: event.EventTypeSubscribe, Subject: "github.issue", Module: "events", Owner: "events"},
+					{Type: event.EventTypeSubscribe, Subject: "slack.message", Module: "events#notify", Owner: "events"},
+					{Type: event.EventTypeConsume, Subject: "jobs.issue", Module: "events#main", Owner: "events", MaxDeliver: 3, DeadLetter: "jobs.dead"},
+					{Type: event.EventTypeSchedule, Subject: "daily.summary", Module: "events#main", Owner: "events", Schedule: "0 9 * * mon-fri", Timezone: "Asia/Tokyo"},
+				},
+			},
+		},
+		{
+			name:     "script metadata",
+			testFile: "scriptmeta.md",
+			want: &Module{
+				Name: "scriptmeta",
+				Scripts: []*script.Script{
+					{
+						Name:        "main",
+						Description: "Greets the user.",
+						Model:       "gpt-4o-mini",
+						ModelConfig: &chat.ModelConfig{Temperature: 0.5},
+						InputSchema: jsonschema.Schema{
+							"type":       "object",
+							"properties": map[string]any{"name": map[string]any{"type": "string"}},
+						},
+						Config:  script.Config{TimeoutSeconds: 60},
+						Content: "1. Say hello to the user.",
+					},
+					{
+						Name:         "other",
+						Model:        "claude-3-5-haiku-latest",
+						OutputSchema: jsonschema.Schema{"type": "string"},
+						Content:      "- do something",
+					},
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -152,6 +199,16 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParseScriptMetaError(t *testing.T) {
+	scr := &script.Script{
+		Name:    "main",
+		Content: "```yaml\nmodle: gpt-4o-mini\n```\n1. Say hello.",
+	}
+	if err := parseScriptMeta(scr); err == nil {
+		t.Errorf("expected unknown field error, got nil")
+	}
+}
+
 func TestGetCodeBlock(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package module
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/jumonmd/jumon/event"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// HeaderTraceID is the header of the published message which has the originating trace ID.
+	HeaderTraceID = event.HeaderTraceID
+	// HeaderModule is the header of the published message which has the originating module URL.
+	HeaderModule = event.HeaderModule
+)
+
+// publishOutput publishes the output of the script to the subjects of the publish events of the module.
+// The publish events are owned by the module, so they are taken from the module instead of the event store.
+// The event module without a script name matches the main script of the module.
+// The output is published to "event.<subject>", so it can be subscribed or consumed by other events.
+func publishOutput(ctx context.Context, nc *nats.Conn, mod *Module, scriptname string, output json.RawMessage) error {
+	for _, evt := range mod.Events {
+		if evt.Type != event.EventTypePublish {
+			continue
+		}
+		if eventScript(evt) != scriptname {
+			continue
+		}
+
+		msg := &nats.Msg{
+			Subject: "event." + evt.Subject,
+			Data:    output,
+			Header:  tracer.HeadersFromContext(ctx),
+		}
+		msg.Header.Set(HeaderTraceID, tracer.TraceID(ctx))
+		msg.Header.Set(HeaderModule, mod.Name+"#"+scriptname)
+
+		slog.Info("publish event", "subject", msg.Subject, "module", mod.Name, "script", scriptname)
+		err := nc.PublishMsg(msg)
+		if err != nil {
+			return fmt.Errorf("publish %s: %w", msg.Subject, err)
+		}
+	}
+	return nil
+}
@@ -12,11 +12,13 @@ import (
 
 	"github.com/jumonmd/jumon/internal/config"
 	"github.com/jumonmd/jumon/script"
+	"github.com/jumonmd/jumon/tool"
 	"github.com/nats-io/nats.go"
 )
 
 // Run executes a module with the given module URL using NATS service.
-func Run(ctx context.Context, nc *nats.Conn, modurl string, input []byte) (json.RawMessage, error) {
+// The files are attached to the first message of the script.
+func Run(ctx context.Context, nc *nats.Conn, modurl string, input []byte, files ...script.File) (json.RawMessage, error) {
 	modname, scriptname := extractModScriptName(modurl)
 	slog.Debug("run module", "modurl", modurl)
 
@@ -31,7 +33,7 @@ func Run(ctx context.Context, nc *nats.Conn, modurl string, input []byte) (json.
 		return nil, ErrScriptNotFound.Wrap(fmt.Errorf("script not found: %s", scriptname))
 	}
 
-	defaultModel, err := config.Get(ctx, nc, config.DefaultModel)
+	defaultModel, err := config.Get(ctx, nc, config.DefaultModel, config.ModuleScope(modname))
 	if err != nil {
 		return nil, fmt.Errorf("get default model: %w", err)
 	}
@@ -39,11 +41,31 @@ func Run(ctx context.Context, nc *nats.Conn, modurl string, input []byte) (json.
 		slog.Debug("using default model", "model", defaultModel)
 		scr.Model = defaultModel
 	}
+	if scr.Config.MaxToolCalls == 0 {
+		scr.Config.MaxToolCalls, err = config.GetInt(ctx, nc, config.MaxToolCalls, config.ModuleScope(modname))
+		if err != nil {
+			return nil, fmt.Errorf("get max tool calls: %w", err)
+		}
+	}
 	// currently, input is expected to be JSON
 	scr.SetInput(input)
+	scr.Files = append(scr.Files, files...)
+	if err := scr.ValidateInput(); err != nil {
+		return nil, ErrInvalidInput.Wrap(err)
+	}
 
 	scr.Tools = append(mod.Tools, scr.Tools...)
-	return script.Run(ctx, nc, scr)
+	// the secrets granted to the module are resolved in its tool calls.
+	output, err := script.Run(tool.WithModule(ctx, modname), nc, scr)
+	if err != nil {
+		return nil, err
+	}
+
+	// the run succeeded even if the output could not be published.
+	if err := publishOutput(ctx, nc, mod, scr.Name, output); err != nil {
+		slog.Error("run module", "status", "publish output failed", "modurl", modurl, "error", err)
+	}
+	return output, nil
 }
 
 // Get returns a module with the given module name with resolved tools and scripts.
@@ -63,7 +85,7 @@ func Get(ctx context.Context, nc *nats.Conn, modname string) (*Module, error) {
 		return nil, fmt.Errorf("prepare import tools: %w", err)
 	}
 
-	defaultModel, err := config.Get(ctx, nc, config.DefaultModel)
+	defaultModel, err := config.Get(ctx, nc, config.DefaultModel, config.ModuleScope(modname))
 	if err != nil {
 		return nil, fmt.Errorf("get default model: %w", err)
 	}
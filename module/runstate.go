@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package module
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// RunBucket is the key value store of the asynchronous run records.
+const RunBucket = "run"
+
+const (
+	// runHeartbeat is the interval to update the record of a running run to show that it is alive.
+	runHeartbeat = 30 * time.Second
+	// staleRunAfter is the time after which an unfinished run without updates is regarded as interrupted.
+	staleRunAfter = 3 * runHeartbeat
+)
+
+type RunState string
+
+const (
+	RunStateQueued    RunState = "queued"
+	RunStateRunning   RunState = "running"
+	RunStateSucceeded RunState = "succeeded"
+	RunStateFailed    RunState = "failed"
+	RunStateCancelled RunState = "cancelled"
+)
+
+// RunRecord is the state of an asynchronous run.
+// The ID is the trace ID of the run.
+type RunRecord struct {
+	ID     string   `json:"id"`
+	Module string   `json:"module"`
+	State  RunState `json:"state"`
+	// Input is the module input to resume the run.
+	Input json.RawMessage `json:"input,omitempty"`
+	// Output is the module output of the succeeded run.
+	Output json.RawMessage `json:"output,omitempty"`
+	// ErrorCode is the service error code of the failed or cancelled run.
+	ErrorCode int       `json:"error_code,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// revision is the key value revision of the record returned by GetRun.
+	revision uint64
+}
+
+// Finished reports whether the run has succeeded, failed or been cancelled.
+func (r *RunRecord) Finished() bool {
+	return r.State == RunStateSucceeded || r.State == RunStateFailed || r.State == RunStateCancelled
+}
+
+// Stale reports whether the unfinished run has not been updated for a while,
+// e.g. the server stopped during the run, so that it can be resumed.
+func (r *RunRecord) Stale(now time.Time) bool {
+	return !r.Finished() && now.Sub(r.UpdatedAt) > staleRunAfter
+}
+
+// heartbeat puts the copy of the running run record periodically until stop is called.
+func heartbeat(ctx context.Context, nc *nats.Conn, rec RunRecord) (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(runHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := putRun(ctx, nc, &rec); err != nil {
+					slog.Warn("module.submit", "status", "heartbeat failed", "run_id", rec.ID, "error", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// putRun puts the run record into the key value store.
+func putRun(ctx context.Context, nc *nats.Conn, rec *RunRecord) error {
+	kv, err := runKeyValue(ctx, nc)
+	if err != nil {
+		return err
+	}
+	rec.UpdatedAt = time.Now()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal run: %w", err)
+	}
+	if _, err := kv.Put(ctx, rec.ID, data); err != nil {
+		return fmt.Errorf("put run: %w", err)
+	}
+	return nil
+}
+
+// GetRun returns the run record of the run ID.
+func GetRun(ctx context.Context, nc *nats.Conn, id string) (*RunRecord, error) {
+	kv, err := runKeyValue(ctx, nc)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := kv.Get(ctx, id)
+	if errors.Is(err, jetstream.ErrKeyNotFound) || errors.Is(err, jetstream.ErrInvalidKey) {
+		return nil, ErrRunNotFound.Wrap(fmt.Errorf("%s", id))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get run: %w", err)
+	}
+	rec := &RunRecord{}
+	if err := json.Unmarshal(entry.Value(), rec); err != nil {
+		return nil, fmt.Errorf("unmarshal run: %w", err)
+	}
+	rec.revision = entry.Revision()
+	return rec, nil
+}
+
+// claimRun puts the run record returned by GetRun as running only if it is not updated since then,
+// so that a run is resumed only once by the concurrent requests.
+func claimRun(ctx context.Context, nc *nats.Conn, rec *RunRecord) error {
+	kv, err := runKeyValue(ctx, nc)
+	if err != nil {
+		return err
+	}
+	rec.State = RunStateRunning
+	rec.ErrorCode = 0
+	rec.Error = ""
+	rec.UpdatedAt = time.Now()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal run: %w", err)
+	}
+	_, err = kv.Update(ctx, rec.ID, data, rec.revision)
+	if errors.Is(err, jetstream.ErrKeyExists) {
+		return ErrRunResumed.Wrap(fmt.Errorf("%s", rec.ID))
+	}
+	if err != nil {
+		return fmt.Errorf("update run: %w", err)
+	}
+	return nil
+}
+
+func runKeyValue(ctx context.Context, nc *nats.Conn) (jetstream.KeyValue, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("get jetstream: %w", err)
+	}
+	kv, err := js.KeyValue(ctx, RunBucket)
+	if err != nil {
+		return nil, fmt.Errorf("get keyvalue: %w", err)
+	}
+	return kv, nil
+}
@@ -5,26 +5,48 @@ package module
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/event"
 	"github.com/jumonmd/jumon/internal/errors"
 	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/jumonmd/jumon/script"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/nats-io/nats.go/micro"
 )
 
 var (
 	// ErrValidateModule is returned when module validation fails.
 	ErrValidateModule = errors.New(400400, "validate module failed")
+	// ErrInvalidInput is returned when the input does not match the script input schema.
+	ErrInvalidInput = errors.New(400401, "invalid input")
 	// ErrModuleNotFound is returned when a requested module doesn't exist.
 	ErrModuleNotFound = errors.New(404400, "module not found")
 	// ErrScriptNotFound is returned when a script within a module doesn't exist.
 	ErrScriptNotFound = errors.New(404401, "script not found")
+	// ErrRunNotFound is returned when a requested run doesn't exist.
+	ErrRunNotFound = errors.New(404402, "run not found")
+	// ErrRunNotFinished is returned when the result of an unfinished run is requested.
+	ErrRunNotFinished = errors.New(409400, "run not finished")
+	// ErrRunSucceeded is returned when a succeeded run is resumed.
+	ErrRunSucceeded = errors.New(409401, "run already succeeded")
+	// ErrRunFinished is returned when a finished run is cancelled.
+	ErrRunFinished = errors.New(409402, "run already finished")
+	// ErrRunResumed is returned when the run is resumed by another request at the same time.
+	ErrRunResumed = errors.New(409404, "run already resumed")
+	// ErrEventConflict is returned when a module defines an event which is owned by another module.
+	ErrEventConflict = errors.New(409403, "event owned by another module")
 	// ErrRunModule is returned when module execution fails.
 	ErrRunModule = errors.New(500400, "run module failed")
+	// ErrStoreModule is returned when the module store operation fails.
+	ErrStoreModule = errors.New(500401, "store module failed")
 )
 
 // NewService creates a NATS microservice that handles module operations.
@@ -40,9 +62,36 @@ func NewService(nc *nats.Conn) (micro.Service, error) {
 				if strings.HasPrefix(r.Subject(), "module.run") {
 					go runHandler(nc, r)
 				}
+				if strings.HasPrefix(r.Subject(), "module.chat") {
+					go chatHandler(nc, r)
+				}
+				if strings.HasPrefix(r.Subject(), "module.submit") {
+					go submitHandler(nc, r)
+				}
+				if strings.HasPrefix(r.Subject(), "module.resume") {
+					go resumeHandler(nc, r)
+				}
+				if strings.HasPrefix(r.Subject(), "module.cancel") {
+					go cancelHandler(nc, r)
+				}
+				if strings.HasPrefix(r.Subject(), "module.status") {
+					go statusHandler(nc, r)
+				}
+				if strings.HasPrefix(r.Subject(), "module.result") {
+					go resultHandler(nc, r)
+				}
 				if strings.HasPrefix(r.Subject(), "module.put") {
 					go putHandler(nc, r)
 				}
+				if strings.HasPrefix(r.Subject(), "module.get") {
+					go getHandler(nc, r)
+				}
+				if r.Subject() == "module.list" {
+					go listHandler(nc, r)
+				}
+				if strings.HasPrefix(r.Subject(), "module.delete") {
+					go deleteHandler(nc, r)
+				}
 			}),
 		},
 	})
@@ -55,10 +104,36 @@ func NewService(nc *nats.Conn) (micro.Service, error) {
 	return svc, nil
 }
 
+// ChatRequest is the request of the module chat.
+// The module runs with the input as the continuation of the conversation of the history.
+type ChatRequest struct {
+	// Input is the module input such as a JSON object or a plain text.
+	Input   []byte         `json:"input"`
+	History []chat.Message `json:"history,omitempty"`
+}
+
 // runHandler run given module with input.
 // it returns the output of the module.
 func runHandler(nc *nats.Conn, r micro.Request) {
-	modurl := strings.TrimPrefix(r.Subject(), "module.run.")
+	serveRun(nc, r, strings.TrimPrefix(r.Subject(), "module.run."), r.Data(), nil)
+}
+
+// chatHandler runs given module with the input of the ChatRequest after its history.
+// it returns the output of the module like runHandler.
+func chatHandler(nc *nats.Conn, r micro.Request) {
+	req := ChatRequest{}
+	if err := json.Unmarshal(r.Data(), &req); err != nil {
+		r.Error(ErrInvalidInput.ServiceError(fmt.Errorf("unmarshal chat request: %w", err)))
+		return
+	}
+	serveRun(nc, r, strings.TrimPrefix(r.Subject(), "module.chat."), req.Input, func(ctx context.Context) context.Context {
+		// the completion streams only the text of the final output.
+		return script.WithFinalStream(script.WithHistory(ctx, req.History))
+	})
+}
+
+// serveRun runs the module and responds with its output. The run context is modified by withContext if it is not nil.
+func serveRun(nc *nats.Conn, r micro.Request, modurl string, input []byte, withContext func(context.Context) context.Context) {
 	if modurl == "" {
 		r.Error(ErrModuleNotFound.ServiceError(fmt.Errorf("module url is empty")))
 		return
@@ -67,11 +142,18 @@ func runHandler(nc *nats.Conn, r micro.Request) {
 
 	ctx, span := tracer.Start(tracer.NewContext(r.Headers()), nc, "module.run")
 	defer span.End()
+	ctx, stop := span.WithCancel(ctx)
+	defer stop()
 
-	resp, err := Run(ctx, nc, modurl, r.Data())
+	if withContext != nil {
+		ctx = withContext(ctx)
+	}
+
+	resp, err := Run(ctx, nc, modurl, input)
 	if err != nil {
-		span.SetError(ErrRunModule.Wrap(err))
-		r.Error(ErrRunModule.ServiceError(err))
+		serr, cause := runError(err)
+		span.SetError(serr.Wrap(cause))
+		r.Error(serr.ServiceError(cause))
 		return
 	}
 
@@ -79,6 +161,196 @@ func runHandler(nc *nats.Conn, r micro.Request) {
 	slog.Info("module.run", "status", "finished", "modurl", modurl)
 }
 
+// runError returns the service error of the run error and its cause.
+// Client errors are returned with their own codes, and others with ErrRunModule.
+func runError(err error) (*errors.ServiceError, error) {
+	for _, e := range []*errors.ServiceError{ErrInvalidInput, ErrModuleNotFound, ErrScriptNotFound} {
+		if errors.Is(err, e) {
+			return e, errors.Unwrap(err)
+		}
+	}
+	return ErrRunModule, err
+}
+
+// SubmitResponse is the response of the module submit.
+type SubmitResponse struct {
+	RunID string `json:"run_id"`
+}
+
+// submitHandler runs given module asynchronously.
+// It returns the run ID at once, and the run state is stored in the run key value store.
+func submitHandler(nc *nats.Conn, r micro.Request) {
+	modurl := strings.TrimPrefix(r.Subject(), "module.submit.")
+	if modurl == "" {
+		r.Error(ErrModuleNotFound.ServiceError(fmt.Errorf("module url is empty")))
+		return
+	}
+
+	ctx, span := tracer.Start(tracer.NewContext(r.Headers()), nc, "module.run")
+	defer span.End()
+
+	rec := &RunRecord{
+		ID:        span.TraceID(),
+		Module:    modurl,
+		State:     RunStateQueued,
+		Input:     r.Data(),
+		CreatedAt: time.Now(),
+	}
+	if err := putRun(ctx, nc, rec); err != nil {
+		span.SetError(ErrStoreModule.Wrap(err))
+		r.Error(ErrStoreModule.ServiceError(err))
+		return
+	}
+	r.RespondJSON(SubmitResponse{RunID: rec.ID})
+	slog.Info("module.submit", "status", "submitted", "modurl", modurl, "run_id", rec.ID)
+
+	runAsync(ctx, nc, span, rec)
+}
+
+// resumeHandler resumes the failed or interrupted run from the first unfinished step of the checkpoint.
+// A queued or running run is interrupted if its record is stale, and otherwise is not resumed.
+// It returns the run ID at once like submitHandler.
+func resumeHandler(nc *nats.Conn, r micro.Request) {
+	rec, ok := getRunRecord(nc, r, "module.resume.")
+	if !ok {
+		return
+	}
+	if rec.State == RunStateSucceeded {
+		r.Error(ErrRunSucceeded.ServiceError(fmt.Errorf("%s", rec.ID)))
+		return
+	}
+	// a queued or running run is resumed only if it is interrupted without updates.
+	if !rec.Finished() && !rec.Stale(time.Now()) {
+		r.Error(ErrRunNotFinished.ServiceError(fmt.Errorf("%s is %s", rec.ID, rec.State)))
+		return
+	}
+
+	claimCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	err := claimRun(claimCtx, nc, rec)
+	cancel()
+	if errors.Is(err, ErrRunResumed) {
+		r.Error(ErrRunResumed.ServiceError(errors.Unwrap(err)))
+		return
+	}
+	if err != nil {
+		r.Error(ErrStoreModule.ServiceError(err))
+		return
+	}
+
+	// the resumed run continues the trace of the run ID to be cancelled by it.
+	ctx, span := tracer.Start(tracer.WithTraceID(tracer.NewContext(r.Headers()), rec.ID), nc, "module.run")
+	defer span.End()
+
+	r.RespondJSON(SubmitResponse{RunID: rec.ID})
+	slog.Info("module.resume", "status", "resumed", "modurl", rec.Module, "run_id", rec.ID)
+
+	runAsync(ctx, nc, span, rec)
+}
+
+// runAsync runs the module of the run record with checkpoints and stores the run state.
+func runAsync(ctx context.Context, nc *nats.Conn, span *tracer.SpanTracer, rec *RunRecord) {
+	ctx, stop := span.WithCancel(ctx)
+	defer stop()
+
+	rec.State = RunStateRunning
+	if err := putRun(ctx, nc, rec); err != nil {
+		slog.Error("module.submit", "status", "put run failed", "run_id", rec.ID, "error", err)
+	}
+
+	stopHeartbeat := heartbeat(ctx, nc, *rec)
+	resp, err := Run(script.WithCheckpoint(ctx, rec.ID), nc, rec.Module, rec.Input)
+	stopHeartbeat()
+	if err != nil {
+		serr, cause := runError(err)
+		span.SetError(serr.Wrap(cause))
+		rec.State = RunStateFailed
+		if tracer.Cancelled(ctx) {
+			rec.State = RunStateCancelled
+		}
+		rec.ErrorCode = serr.Code
+		rec.Error = serr.Description + ": " + cause.Error()
+	} else {
+		span.SetResponse(resp)
+		rec.State = RunStateSucceeded
+		rec.Output = resp
+	}
+	// the state of the cancelled run is also stored.
+	if err := putRun(context.WithoutCancel(ctx), nc, rec); err != nil {
+		slog.Error("module.submit", "status", "put run failed", "run_id", rec.ID, "error", err)
+	}
+	slog.Info("module.submit", "status", "finished", "modurl", rec.Module, "run_id", rec.ID, "state", rec.State)
+}
+
+// cancelHandler requests to cancel the run, which cancels all the requests with the trace ID of the run.
+// The run ID may also be the trace ID of a synchronous run, which has no run record.
+func cancelHandler(nc *nats.Conn, r micro.Request) {
+	id := strings.TrimPrefix(r.Subject(), "module.cancel.")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rec, err := GetRun(ctx, nc, id)
+	if err != nil && !errors.Is(err, ErrRunNotFound) {
+		r.Error(ErrStoreModule.ServiceError(err))
+		return
+	}
+	if rec != nil && rec.Finished() {
+		r.Error(ErrRunFinished.ServiceError(fmt.Errorf("run %s is %s", rec.ID, rec.State)))
+		return
+	}
+
+	if err := tracer.Cancel(nc, id); err != nil {
+		r.Error(ErrRunModule.ServiceError(fmt.Errorf("publish cancel: %w", err)))
+		return
+	}
+	slog.Info("module.cancel", "status", "requested", "run_id", id)
+	r.RespondJSON(SubmitResponse{RunID: id})
+}
+
+// statusHandler returns the run record without the output.
+func statusHandler(nc *nats.Conn, r micro.Request) {
+	rec, ok := getRunRecord(nc, r, "module.status.")
+	if !ok {
+		return
+	}
+	rec.Output = nil
+	rec.Input = nil
+	r.RespondJSON(rec)
+}
+
+// resultHandler returns the output of the succeeded run, or the error of the failed run.
+func resultHandler(nc *nats.Conn, r micro.Request) {
+	rec, ok := getRunRecord(nc, r, "module.result.")
+	if !ok {
+		return
+	}
+	switch rec.State {
+	case RunStateSucceeded:
+		r.Respond(rec.Output)
+	case RunStateFailed, RunStateCancelled:
+		r.Error(strconv.Itoa(rec.ErrorCode), rec.Error, nil)
+	default:
+		r.Error(ErrRunNotFinished.ServiceError(fmt.Errorf("run %s is %s", rec.ID, rec.State)))
+	}
+}
+
+// getRunRecord returns the run record of the ID in the subject, or responds the error.
+func getRunRecord(nc *nats.Conn, r micro.Request, prefix string) (*RunRecord, bool) {
+	id := strings.TrimPrefix(r.Subject(), prefix)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rec, err := GetRun(ctx, nc, id)
+	if errors.Is(err, ErrRunNotFound) {
+		r.Error(ErrRunNotFound.ServiceError(errors.Unwrap(err)))
+		return nil, false
+	}
+	if err != nil {
+		r.Error(ErrStoreModule.ServiceError(err))
+		return nil, false
+	}
+	return rec, true
+}
+
 func putHandler(nc *nats.Conn, r micro.Request) {
 	slog.Info("module.put", "status", "started")
 	modurl := strings.TrimPrefix(r.Subject(), "module.put.")
@@ -100,6 +372,10 @@ func putHandler(nc *nats.Conn, r micro.Request) {
 		r.Error(ErrModuleNotFound.ServiceError(fmt.Errorf("parse module: %w", err)))
 		return
 	}
+	if err := mod.Validate(); err != nil {
+		r.Error(ErrValidateModule.ServiceError(err))
+		return
+	}
 
 	slog.Info("module.put", "status", "parsed", "mod", mod.Name, "scripts", len(mod.Scripts))
 
@@ -108,6 +384,22 @@ func putHandler(nc *nats.Conn, r micro.Request) {
 	// 	r.Error(ErrModuleNotFound.ServiceError(fmt.Errorf("marshal module: %w", err)))
 	// 	return
 	// }
+	// the events are synced first so that the module is not stored if they conflict.
+	js, err := jetstream.New(nc)
+	if err != nil {
+		r.Error(ErrRunModule.ServiceError(fmt.Errorf("get jetstream: %w", err)))
+		return
+	}
+	err = event.SyncEvents(ctx, js, mod.Name, mod.Events)
+	if errors.Is(err, event.ErrEventConflict) {
+		r.Error(ErrEventConflict.ServiceError(err))
+		return
+	}
+	if err != nil {
+		r.Error(ErrRunModule.ServiceError(fmt.Errorf("sync events: %w", err)))
+		return
+	}
+
 	_, err = modkv.Put(ctx, mod.Name, r.Data())
 	if err != nil {
 		r.Error(ErrModuleNotFound.ServiceError(fmt.Errorf("put module: %w", err)))
@@ -116,3 +408,79 @@ func putHandler(nc *nats.Conn, r micro.Request) {
 	r.Respond(nil, micro.WithHeaders(r.Headers()))
 	slog.Info("module.put", "status", "finished", "modurl", modurl)
 }
+
+// getHandler returns the JUMON.md of the stored module.
+func getHandler(nc *nats.Conn, r micro.Request) {
+	name := strings.TrimPrefix(r.Subject(), "module.get.")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	modkv, err := keyvalue(ctx, nc)
+	if err != nil {
+		r.Error(ErrStoreModule.ServiceError(fmt.Errorf("get keyvalue: %w", err)))
+		return
+	}
+	entry, err := modkv.Get(ctx, name)
+	if errors.Is(err, jetstream.ErrKeyNotFound) || errors.Is(err, jetstream.ErrInvalidKey) {
+		r.Error(ErrModuleNotFound.ServiceError(fmt.Errorf("%s", name)))
+		return
+	}
+	if err != nil {
+		r.Error(ErrStoreModule.ServiceError(fmt.Errorf("get module: %w", err)))
+		return
+	}
+	r.Respond(entry.Value())
+}
+
+// listHandler returns the names of the stored modules.
+func listHandler(nc *nats.Conn, r micro.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	modkv, err := keyvalue(ctx, nc)
+	if err != nil {
+		r.Error(ErrStoreModule.ServiceError(fmt.Errorf("get keyvalue: %w", err)))
+		return
+	}
+	names, err := modkv.Keys(ctx)
+	if errors.Is(err, jetstream.ErrNoKeysFound) {
+		names = []string{}
+	} else if err != nil {
+		r.Error(ErrStoreModule.ServiceError(fmt.Errorf("list modules: %w", err)))
+		return
+	}
+	r.RespondJSON(names)
+}
+
+// deleteHandler deletes the stored module and the events owned by the module.
+func deleteHandler(nc *nats.Conn, r micro.Request) {
+	name := strings.TrimPrefix(r.Subject(), "module.delete.")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	modkv, err := keyvalue(ctx, nc)
+	if err != nil {
+		r.Error(ErrStoreModule.ServiceError(fmt.Errorf("get keyvalue: %w", err)))
+		return
+	}
+	if _, err := modkv.Get(ctx, name); err != nil {
+		r.Error(ErrModuleNotFound.ServiceError(fmt.Errorf("%s", name)))
+		return
+	}
+	if err := modkv.Delete(ctx, name); err != nil {
+		r.Error(ErrStoreModule.ServiceError(fmt.Errorf("delete module: %w", err)))
+		return
+	}
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		r.Error(ErrStoreModule.ServiceError(fmt.Errorf("get jetstream: %w", err)))
+		return
+	}
+	if err := event.SyncEvents(ctx, js, name, nil); err != nil {
+		r.Error(ErrStoreModule.ServiceError(fmt.Errorf("delete events: %w", err)))
+		return
+	}
+	r.Respond(nil)
+	slog.Info("module.delete", "status", "finished", "modurl", name)
+}
@@ -7,11 +7,19 @@ import (
 	"encoding/json"
 	"log/slog"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/event"
+	"github.com/jumonmd/jumon/internal/errors"
 	"github.com/jumonmd/jumon/internal/testutil"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/jumonmd/jumon/script"
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nats.go/micro"
 )
 
 func TestModuleService(t *testing.T) {
@@ -91,3 +99,451 @@ module: test/module
 		t.Fatalf("expected hello, got %v", string(result))
 	}
 }
+
+func TestPublishEvent(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// setup kv
+	for _, bucket := range []string{"module", "config", "event"} {
+		_, err = js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: bucket})
+		if err != nil {
+			t.Fatalf("failed to create kv: %v", err)
+		}
+	}
+
+	testresp := chat.Response{
+		Model:        "gpt-4o-mini",
+		FinishReason: "stop",
+		Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, "hello")},
+	}
+	respdata, err := json.Marshal(testresp)
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+	chtsvc, err := testutil.NewMicroServer(nc, "chat.generate", respdata)
+	if err != nil {
+		t.Fatalf("failed to create mock chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	modmd := `
+---
+module: test/publish
+---
+
+## Scripts
+### main
+1. say hello
+
+### other
+1. say bye
+
+## Events
+### greeted
+type: publish
+
+### other.done
+type: publish
+script: other
+`
+	mod, err := ParseMarkdown([]byte(modmd))
+	if err != nil {
+		t.Fatalf("failed to parse module: %v", err)
+	}
+	modkv, err := js.KeyValue(t.Context(), "module")
+	if err != nil {
+		t.Fatalf("failed to get kv: %v", err)
+	}
+	if _, err := modkv.Put(t.Context(), mod.Name, []byte(modmd)); err != nil {
+		t.Fatalf("failed to put module: %v", err)
+	}
+	if err := event.SyncEvents(t.Context(), js, mod.Name, mod.Events); err != nil {
+		t.Fatalf("failed to sync events: %v", err)
+	}
+
+	sub, err := nc.SubscribeSync("event.>")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+
+	ctx, span := tracer.Start(t.Context(), nc, "test")
+	defer span.End()
+	if _, err := Run(ctx, nc, "test/publish", nil); err != nil {
+		t.Fatalf("failed to run module: %v", err)
+	}
+
+	msg, err := sub.NextMsg(time.Second)
+	if err != nil {
+		t.Fatalf("no published message: %v", err)
+	}
+	if msg.Subject != "event.greeted" {
+		t.Errorf("subject = %s, want event.greeted", msg.Subject)
+	}
+	if string(msg.Data) != `"hello"` {
+		t.Errorf("data = %s, want \"hello\"", msg.Data)
+	}
+	if got := msg.Header.Get(HeaderTraceID); got == "" || got != tracer.TraceID(ctx) {
+		t.Errorf("trace id header = %q, want %q", got, tracer.TraceID(ctx))
+	}
+	if got := msg.Header.Get(HeaderModule); got != "test/publish#main" {
+		t.Errorf("module header = %s, want test/publish#main", got)
+	}
+
+	// the other script's event must not be published
+	if msg, err := sub.NextMsg(100 * time.Millisecond); err == nil {
+		t.Errorf("unexpected message on %s", msg.Subject)
+	}
+
+	// the script subscribed to its own output is invalid
+	loop, err := ParseMarkdown([]byte(modmd + `
+### greeted
+type: subscribe
+`))
+	if err != nil {
+		t.Fatalf("failed to parse module: %v", err)
+	}
+	if err := loop.Validate(); err == nil {
+		t.Errorf("Validate() of self subscribed module expected error, got nil")
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// setup kv
+	for _, bucket := range []string{"module", "config", RunBucket, script.CheckpointBucket} {
+		_, err = js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: bucket})
+		if err != nil {
+			t.Fatalf("failed to create kv: %v", err)
+		}
+	}
+
+	svc, err := NewService(nc)
+	if err != nil {
+		t.Fatalf("failed to create module service: %v", err)
+	}
+	defer svc.Stop()
+
+	respdata, err := json.Marshal(chat.Response{
+		Model:        "gpt-4o-mini",
+		FinishReason: "stop",
+		Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, "hello")},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal response: %v", err)
+	}
+	chtsvc, err := testutil.NewMicroServer(nc, "chat.generate", respdata)
+	if err != nil {
+		t.Fatalf("failed to create mock chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	modkv, err := js.KeyValue(t.Context(), "module")
+	if err != nil {
+		t.Fatalf("failed to get kv: %v", err)
+	}
+	modmd := "---\nmodule: test/submit\n---\n\n## Scripts\n### main\n1. say hello\n"
+	if _, err := modkv.Put(t.Context(), "test/submit", []byte(modmd)); err != nil {
+		t.Fatalf("failed to put module: %v", err)
+	}
+
+	request := func(subject string) *nats.Msg {
+		t.Helper()
+		resp, err := nc.Request(subject, nil, time.Second)
+		if err != nil {
+			t.Fatalf("request %s failed: %v", subject, err)
+		}
+		return resp
+	}
+
+	submit := func(modurl string) string {
+		t.Helper()
+		var sub SubmitResponse
+		if err := json.Unmarshal(request("module.submit."+modurl).Data, &sub); err != nil {
+			t.Fatalf("failed to unmarshal submit response: %v", err)
+		}
+		if sub.RunID == "" {
+			t.Fatal("empty run id")
+		}
+		return sub.RunID
+	}
+
+	wait := func(id string) *RunRecord {
+		t.Helper()
+		for range 50 {
+			rec := &RunRecord{}
+			if err := json.Unmarshal(request("module.status."+id).Data, rec); err != nil {
+				t.Fatalf("failed to unmarshal status: %v", err)
+			}
+			if rec.Finished() {
+				return rec
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("run %s did not finish", id)
+		return nil
+	}
+
+	t.Run("succeeded", func(t *testing.T) {
+		id := submit("test/submit")
+		rec := wait(id)
+		if rec.State != RunStateSucceeded || rec.Module != "test/submit" {
+			t.Errorf("unexpected status: %+v", rec)
+		}
+		if len(rec.Output) != 0 {
+			t.Errorf("status must not include the output: %s", rec.Output)
+		}
+		if got := string(request("module.result." + id).Data); got != `"hello"` {
+			t.Errorf("result = %s, want \"hello\"", got)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		id := submit("test/submit#none")
+		rec := wait(id)
+		if rec.State != RunStateFailed || rec.ErrorCode != ErrScriptNotFound.Code {
+			t.Errorf("unexpected status: %+v", rec)
+		}
+		resp := request("module.result." + id)
+		if got := resp.Header.Get("Nats-Service-Error-Code"); got != "404401" {
+			t.Errorf("result error code = %s, want 404401", got)
+		}
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		id := submit("test/submit")
+		wait(id)
+		resp := request("module.resume." + id)
+		if got := resp.Header.Get("Nats-Service-Error-Code"); got != "409401" {
+			t.Errorf("resume succeeded run error code = %s, want 409401", got)
+		}
+
+		id = submit("test/submit#none")
+		wait(id)
+		var sub SubmitResponse
+		if err := json.Unmarshal(request("module.resume."+id).Data, &sub); err != nil {
+			t.Fatalf("failed to unmarshal resume response: %v", err)
+		}
+		if sub.RunID != id {
+			t.Errorf("resume run id = %s, want %s", sub.RunID, id)
+		}
+		if rec := wait(id); rec.State != RunStateFailed {
+			t.Errorf("unexpected status: %+v", rec)
+		}
+	})
+
+	t.Run("resume unfinished", func(t *testing.T) {
+		runkv, err := js.KeyValue(t.Context(), RunBucket)
+		if err != nil {
+			t.Fatalf("failed to get kv: %v", err)
+		}
+		put := func(rec RunRecord) {
+			t.Helper()
+			data, _ := json.Marshal(rec)
+			if _, err := runkv.Put(t.Context(), rec.ID, data); err != nil {
+				t.Fatalf("failed to put run: %v", err)
+			}
+		}
+
+		for _, state := range []RunState{RunStateQueued, RunStateRunning} {
+			put(RunRecord{ID: "active", Module: "test/submit", State: state, UpdatedAt: time.Now()})
+			resp := request("module.resume.active")
+			if got := resp.Header.Get("Nats-Service-Error-Code"); got != "409400" {
+				t.Errorf("resume %s run error code = %s, want 409400", state, got)
+			}
+		}
+
+		// the run stuck in running, e.g. by a server restart, is resumed.
+		put(RunRecord{ID: "stale", Module: "test/submit", State: RunStateRunning, UpdatedAt: time.Now().Add(-2 * staleRunAfter)})
+		var sub SubmitResponse
+		if err := json.Unmarshal(request("module.resume.stale").Data, &sub); err != nil || sub.RunID != "stale" {
+			t.Fatalf("resume stale run = %+v, %v", sub, err)
+		}
+		if rec := wait("stale"); rec.State != RunStateSucceeded {
+			t.Errorf("unexpected status: %+v", rec)
+		}
+	})
+
+	t.Run("resume concurrently", func(t *testing.T) {
+		runkv, err := js.KeyValue(t.Context(), RunBucket)
+		if err != nil {
+			t.Fatalf("failed to get kv: %v", err)
+		}
+		data, _ := json.Marshal(RunRecord{ID: "race", Module: "test/submit", State: RunStateFailed, UpdatedAt: time.Now()})
+		if _, err := runkv.Put(t.Context(), "race", data); err != nil {
+			t.Fatalf("failed to put run: %v", err)
+		}
+
+		// the losers see the claimed run, or the run resumed by the winner.
+		codes := make(chan string, 10)
+		var wg sync.WaitGroup
+		for range cap(codes) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := nc.Request("module.resume.race", nil, time.Second)
+				if err != nil {
+					codes <- err.Error()
+					return
+				}
+				codes <- resp.Header.Get("Nats-Service-Error-Code")
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		resumed := 0
+		for code := range codes {
+			switch code {
+			case "":
+				resumed++
+			case "409400", "409401", "409404":
+			default:
+				t.Errorf("resume error code = %s, want 409400, 409401 or 409404", code)
+			}
+		}
+		if resumed != 1 {
+			t.Errorf("resumed %d times, want 1", resumed)
+		}
+		wait("race")
+
+		// the run read by both requests is claimed only by the first one.
+		first, err := GetRun(t.Context(), nc, "race")
+		if err != nil {
+			t.Fatalf("failed to get run: %v", err)
+		}
+		second, err := GetRun(t.Context(), nc, "race")
+		if err != nil {
+			t.Fatalf("failed to get run: %v", err)
+		}
+		if err := claimRun(t.Context(), nc, first); err != nil {
+			t.Fatalf("failed to claim run: %v", err)
+		}
+		if err := claimRun(t.Context(), nc, second); !errors.Is(err, ErrRunResumed) {
+			t.Errorf("claimRun() error = %v, want ErrRunResumed", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		resp := request("module.status.unknown")
+		if got := resp.Header.Get("Nats-Service-Error-Code"); got != "404402" {
+			t.Errorf("status error code = %s, want 404402", got)
+		}
+	})
+}
+
+func TestCancel(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// setup kv
+	for _, bucket := range []string{"module", "config", RunBucket, script.CheckpointBucket} {
+		_, err = js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: bucket})
+		if err != nil {
+			t.Fatalf("failed to create kv: %v", err)
+		}
+	}
+
+	svc, err := NewService(nc)
+	if err != nil {
+		t.Fatalf("failed to create module service: %v", err)
+	}
+	defer svc.Stop()
+
+	// mock chat service which never responds, and records the cancellation of the trace.
+	cancelled := make(chan struct{})
+	chtsvc, err := micro.AddService(nc, micro.Config{
+		Name:    "test-chat",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "chat.generate",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				ctx, span := tracer.Start(tracer.NewContext(r.Headers()), nc, "chat.generate")
+				ctx, stop := span.WithCancel(ctx)
+				go func() {
+					defer stop()
+					<-ctx.Done()
+					if tracer.Cancelled(ctx) {
+						close(cancelled)
+					}
+				}()
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create mock chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	modkv, err := js.KeyValue(t.Context(), "module")
+	if err != nil {
+		t.Fatalf("failed to get kv: %v", err)
+	}
+	modmd := "---\nmodule: test/cancel\n---\n\n## Scripts\n### main\n1. wait forever\n"
+	if _, err := modkv.Put(t.Context(), "test/cancel", []byte(modmd)); err != nil {
+		t.Fatalf("failed to put module: %v", err)
+	}
+
+	resp, err := nc.Request("module.submit.test/cancel", nil, time.Second)
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	var sub SubmitResponse
+	if err := json.Unmarshal(resp.Data, &sub); err != nil {
+		t.Fatalf("failed to unmarshal submit response: %v", err)
+	}
+
+	// wait for the chat request of the run.
+	time.Sleep(100 * time.Millisecond)
+	resp, err = nc.Request("module.cancel."+sub.RunID, nil, time.Second)
+	if err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	if code := resp.Header.Get("Nats-Service-Error-Code"); code != "" {
+		t.Fatalf("cancel error: %s %s", code, resp.Header.Get("Nats-Service-Error"))
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("chat request is not cancelled")
+	}
+
+	var rec *RunRecord
+	for range 50 {
+		rec, err = GetRun(t.Context(), nc, sub.RunID)
+		if err != nil {
+			t.Fatalf("failed to get run: %v", err)
+		}
+		if rec.Finished() {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if rec.State != RunStateCancelled {
+		t.Errorf("state = %s, want %s", rec.State, RunStateCancelled)
+	}
+
+	// the finished run cannot be cancelled.
+	resp, err = nc.Request("module.cancel."+sub.RunID, nil, time.Second)
+	if err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	if got := resp.Header.Get("Nats-Service-Error-Code"); got != "409402" {
+		t.Errorf("cancel finished run error code = %s, want 409402", got)
+	}
+}
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package script
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jumonmd/gengo/chat"
+)
+
+// annotationPattern matches the inline annotation at the beginning of the step.
+// e.g. "[model: claude-3-haiku, temperature: 0.2] Summarize the tickets".
+var annotationPattern = regexp.MustCompile(`^\[\s*([A-Za-z_]+\s*:\s*[^,\]]+(?:\s*,\s*[A-Za-z_]+\s*:\s*[^,\]]+)*)\s*\]\s*`)
+
+// parseAnnotation parses the inline annotation of the step text and returns the text without it.
+// The annotation sets the model and the model config of the step. Zero values of the model config
+// are rejected, because they are omitted in the provider requests and would be silently ignored.
+func parseAnnotation(text string) (model string, config *chat.ModelConfig, rest string, err error) {
+	m := annotationPattern.FindStringSubmatch(text)
+	if m == nil {
+		return "", nil, text, nil
+	}
+	rest = text[len(m[0]):]
+
+	for _, pair := range strings.Split(m[1], ",") {
+		key, value, _ := strings.Cut(pair, ":")
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if key == "model" {
+			model = value
+			continue
+		}
+		if config == nil {
+			config = &chat.ModelConfig{}
+		}
+		if key == "max_tokens" {
+			n, perr := strconv.ParseInt(value, 10, 32)
+			if perr != nil {
+				return "", nil, "", fmt.Errorf("invalid annotation %s: %w", key, perr)
+			}
+			if n == 0 {
+				return "", nil, "", errZeroAnnotation(key)
+			}
+			config.MaxTokens = int32(n)
+			continue
+		}
+
+		f, perr := strconv.ParseFloat(value, 32)
+		if perr != nil {
+			return "", nil, "", fmt.Errorf("invalid annotation %s: %w", key, perr)
+		}
+		if float32(f) == 0 {
+			return "", nil, "", errZeroAnnotation(key)
+		}
+		switch key {
+		case "temperature":
+			config.Temperature = float32(f)
+		case "top_p":
+			config.TopP = float32(f)
+		case "presence_penalty":
+			config.PresencePenalty = float32(f)
+		case "frequency_penalty":
+			config.FrequencyPenalty = float32(f)
+		default:
+			return "", nil, "", fmt.Errorf("unknown annotation: %s", key)
+		}
+	}
+	return model, config, rest, nil
+}
+
+func errZeroAnnotation(key string) error {
+	return fmt.Errorf("invalid annotation %s: 0 is omitted in the provider requests and can not override the default", key)
+}
+
+// mergeModelConfig returns the base config overridden by the non-zero values of the step config.
+func mergeModelConfig(base, step *chat.ModelConfig) chat.ModelConfig {
+	var cfg chat.ModelConfig
+	if base != nil {
+		cfg = *base
+	}
+	if step == nil {
+		return cfg
+	}
+	if step.MaxTokens != 0 {
+		cfg.MaxTokens = step.MaxTokens
+	}
+	if step.Temperature != 0 {
+		cfg.Temperature = step.Temperature
+	}
+	if step.TopP != 0 {
+		cfg.TopP = step.TopP
+	}
+	if step.PresencePenalty != 0 {
+		cfg.PresencePenalty = step.PresencePenalty
+	}
+	if step.FrequencyPenalty != 0 {
+		cfg.FrequencyPenalty = step.FrequencyPenalty
+	}
+	return cfg
+}
+
+// overrideModel sets the model and the model config of the step annotation to the request.
+func (s *Step) overrideModel(req *chat.Request) {
+	if s.Model != "" {
+		req.Model = s.Model
+	}
+	if s.ModelConfig != nil {
+		req.Config = mergeModelConfig(&req.Config, s.ModelConfig)
+	}
+}
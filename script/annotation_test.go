@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package script
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jumonmd/gengo/chat"
+)
+
+func TestParseAnnotation(t *testing.T) {
+	tests := []struct {
+		text       string
+		wantModel  string
+		wantConfig *chat.ModelConfig
+		wantRest   string
+		wantErr    bool
+	}{
+		{text: "Summarize the tickets", wantRest: "Summarize the tickets"},
+		{text: "[model: claude-3-haiku] Summarize", wantModel: "claude-3-haiku", wantRest: "Summarize"},
+		{
+			text:       "[model: claude-3-haiku, temperature: 0.2, max_tokens: 100] Summarize *it*",
+			wantModel:  "claude-3-haiku",
+			wantConfig: &chat.ModelConfig{Temperature: 0.2, MaxTokens: 100},
+			wantRest:   "Summarize *it*",
+		},
+		{text: "[top_p: 0.9]If: the customer is angry", wantConfig: &chat.ModelConfig{TopP: 0.9}, wantRest: "If: the customer is angry"},
+		{text: "[ ] not an annotation", wantRest: "[ ] not an annotation"},
+		{text: "[link](https://example.com)", wantRest: "[link](https://example.com)"},
+		{text: "[seed: 1] Summarize", wantErr: true},
+		{text: "[temperature: hot] Summarize", wantErr: true},
+		// zero values can not be sent to the providers.
+		{text: "[model: claude-3-haiku, temperature: 0] Summarize", wantErr: true},
+		{text: "[max_tokens: 0] Summarize", wantErr: true},
+		{text: "[frequency_penalty: 0.0] Summarize", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			model, config, rest, err := parseAnnotation(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAnnotation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if model != tt.wantModel {
+				t.Errorf("model = %q, want %q", model, tt.wantModel)
+			}
+			if diff := cmp.Diff(tt.wantConfig, config); diff != "" {
+				t.Errorf("config mismatch (-want +got):\n%s", diff)
+			}
+			if rest != tt.wantRest {
+				t.Errorf("rest = %q, want %q", rest, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestStepOverrideModel(t *testing.T) {
+	req := &chat.Request{Model: "gpt-4o-mini", Config: chat.ModelConfig{Temperature: 0.7, MaxTokens: 1000}}
+	step := &Step{Model: "claude-3-haiku", ModelConfig: &chat.ModelConfig{Temperature: 0.2}}
+	step.overrideModel(req)
+
+	if req.Model != "claude-3-haiku" {
+		t.Errorf("model = %q, want %q", req.Model, "claude-3-haiku")
+	}
+	want := chat.ModelConfig{Temperature: 0.2, MaxTokens: 1000}
+	if diff := cmp.Diff(want, req.Config); diff != "" {
+		t.Errorf("config mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestStepsZeroAnnotation(t *testing.T) {
+	scr := &Script{Content: "- [model: claude-3-haiku, temperature: 0] Summarize\n"}
+	_, _, err := scr.Steps()
+	if err == nil || !strings.Contains(err.Error(), "temperature: 0 is omitted") {
+		t.Errorf("Steps() error = %v, want zero annotation error", err)
+	}
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// CheckpointBucket is the key value store of the script checkpoints.
+const CheckpointBucket = "checkpoint"
+
+type checkpointKey struct{}
+
+// Checkpoint is the conversation history of a script run after the completed steps.
+type Checkpoint struct {
+	RunID  string `json:"run_id"`
+	Script string `json:"script"`
+	// Steps is the number of the completed steps.
+	Steps    int            `json:"steps"`
+	Messages []chat.Message `json:"messages"`
+	// Outputs is the outputs of the completed steps by the step number for the template variables.
+	Outputs   map[int]json.RawMessage `json:"outputs,omitempty"`
+	UpdatedAt time.Time               `json:"updated_at"`
+}
+
+// WithCheckpoint returns the context which makes Run checkpoint each completed step under the run ID.
+// If a checkpoint of the run ID exists, Run resumes from the first unfinished step.
+func WithCheckpoint(ctx context.Context, runID string) context.Context {
+	return context.WithValue(ctx, checkpointKey{}, runID)
+}
+
+func checkpointID(ctx context.Context) string {
+	id, _ := ctx.Value(checkpointKey{}).(string)
+	return id
+}
+
+// getCheckpoint returns the checkpoint of the run ID, or nil if it does not exist.
+func getCheckpoint(ctx context.Context, nc *nats.Conn, runID string) (*Checkpoint, error) {
+	kv, err := checkpointKeyValue(ctx, nc)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := kv.Get(ctx, runID)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get checkpoint: %w", err)
+	}
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(entry.Value(), cp); err != nil {
+		return nil, fmt.Errorf("unmarshal checkpoint: %w", err)
+	}
+	return cp, nil
+}
+
+func putCheckpoint(ctx context.Context, nc *nats.Conn, cp *Checkpoint) error {
+	kv, err := checkpointKeyValue(ctx, nc)
+	if err != nil {
+		return err
+	}
+	cp.UpdatedAt = time.Now()
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("marshal checkpoint: %w", err)
+	}
+	if _, err := kv.Put(ctx, cp.RunID, data); err != nil {
+		return fmt.Errorf("put checkpoint: %w", err)
+	}
+	return nil
+}
+
+func deleteCheckpoint(ctx context.Context, nc *nats.Conn, runID string) error {
+	kv, err := checkpointKeyValue(ctx, nc)
+	if err != nil {
+		return err
+	}
+	if err := kv.Delete(ctx, runID); err != nil {
+		return fmt.Errorf("delete checkpoint: %w", err)
+	}
+	return nil
+}
+
+func checkpointKeyValue(ctx context.Context, nc *nats.Conn) (jetstream.KeyValue, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("get jetstream: %w", err)
+	}
+	kv, err := js.KeyValue(ctx, CheckpointBucket)
+	if err != nil {
+		return nil, fmt.Errorf("get keyvalue: %w", err)
+	}
+	return kv, nil
+}
@@ -0,0 +1,308 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/gengo/jsonschema"
+	chatsvc "github.com/jumonmd/jumon/chat"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// StepTypeIf is the step which runs its children only when the condition is true.
+	// e.g. "- If: the customer is angry" or "- If: `$.score >= 80`".
+	StepTypeIf = "if"
+	// StepTypeElse is the step which runs its children when the condition of the previous if step is false.
+	StepTypeElse = "else"
+)
+
+var (
+	conditionPrefixes = regexp.MustCompile(`(?i)^(if|else)\s*[:：]`)
+	predicatePath     = regexp.MustCompile(`^\$((\.[^.\[\s=!<>]+)|(\[\d+\]))*`)
+	predicateOps      = []string{"==", "!=", ">=", "<=", ">", "<"}
+)
+
+// stepType returns the step type of the list item text.
+func stepType(text string) string {
+	if forEachPattern.MatchString(strings.TrimSpace(text)) {
+		return StepTypeForEach
+	}
+	m := conditionPrefixes.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil {
+		return ""
+	}
+	return strings.ToLower(m[1])
+}
+
+// Condition returns the condition of the if step.
+func (s *Step) Condition() string {
+	return strings.TrimSpace(conditionPrefixes.ReplaceAllString(strings.TrimSpace(s.Content), ""))
+}
+
+// validateSteps checks that every else step follows an if step and the for each steps are valid.
+func validateSteps(steps []*Step) error {
+	for i, step := range steps {
+		if step.Type == StepTypeElse && (i == 0 || steps[i-1].Type != StepTypeIf) {
+			return fmt.Errorf("else step must follow an if step: %s", step.Content)
+		}
+		if step.Type == StepTypeForEach {
+			if _, err := step.ForEach(); err != nil {
+				return err
+			}
+			if len(step.Children) == 0 {
+				return fmt.Errorf("for each step has no steps: %s", step.Content)
+			}
+		}
+		if err := validateSteps(step.Children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCondition decides the condition of the if step and returns the children of the branch to run.
+// A condition starting with "$" is a predicate on the last output, and others are decided by the model.
+func runCondition(ctx context.Context, nc *nats.Conn, scr *Script, step, elseStep *Step, history *chat.Request, vars *vars) ([]*Step, error) {
+	ctx, span := tracer.Start(ctx, nc, "script.step.run")
+	defer span.End()
+
+	cond, err := vars.render(step.Condition())
+	if err != nil {
+		span.SetError(fmt.Errorf("render condition: %w", err))
+		return nil, fmt.Errorf("render condition: %w", err)
+	}
+	span.SetAttribute("condition", cond)
+
+	var result bool
+	if expr := strings.Trim(cond, "`"); strings.HasPrefix(expr, "$") {
+		span.SetAttribute("decided_by", "predicate")
+		result, err = evalPredicate(expr, lastOutput(history))
+	} else {
+		span.SetAttribute("decided_by", "model")
+		result, err = decideCondition(ctx, nc, scr, step, cond, history)
+	}
+	if err != nil {
+		span.SetError(fmt.Errorf("decide condition: %w", err))
+		return nil, fmt.Errorf("decide condition: %w", err)
+	}
+
+	slog.Debug("run condition", "condition", cond, "result", result)
+	span.SetAttribute("decision", strconv.FormatBool(result))
+	span.SetResponse(result)
+
+	if result {
+		return step.Children, nil
+	}
+	if elseStep != nil {
+		return elseStep.Children, nil
+	}
+	return nil, nil
+}
+
+// decideCondition asks the model whether the condition is true in the current conversation.
+// The question and answer are not added to the history, and the answer is not streamed.
+func decideCondition(ctx context.Context, nc *nats.Conn, scr *Script, step *Step, cond string, history *chat.Request) (bool, error) {
+	ctx = tracer.WithStreamTo(ctx, "")
+	req := &chat.Request{
+		Model:    scr.Model,
+		Messages: append(history.Messages[:len(history.Messages):len(history.Messages)], chat.NewTextMessage(chat.MessageRoleHuman, fmt.Sprintf(conditionPromptTemplate, cond))),
+		ResponseSchema: jsonschema.Schema{
+			"type": "object",
+			"properties": map[string]any{
+				"result": map[string]any{"type": "boolean"},
+				"reason": map[string]any{"type": "string"},
+			},
+			"required": []any{"result"},
+		},
+	}
+	if scr.ModelConfig != nil {
+		req.Config = *scr.ModelConfig
+	}
+	step.overrideModel(req)
+
+	resp, err := chatsvc.Generate(ctx, nc, req)
+	if err != nil {
+		return false, fmt.Errorf("chat generate: %w", err)
+	}
+	if len(resp.Messages) == 0 {
+		return false, fmt.Errorf("no decision")
+	}
+
+	var decision struct {
+		Result bool   `json:"result"`
+		Reason string `json:"reason"`
+	}
+	content := resp.Messages[len(resp.Messages)-1].ContentString()
+	if err := json.Unmarshal([]byte(content), &decision); err != nil {
+		return false, fmt.Errorf("unmarshal decision: %w", err)
+	}
+	slog.Debug("decide condition", "condition", cond, "result", decision.Result, "reason", decision.Reason)
+	return decision.Result, nil
+}
+
+const conditionPromptTemplate = `Decide whether the following condition is true in the conversation so far.
+
+Condition: %s
+
+Answer with only the JSON object {"result": true or false, "reason": "short reason"}.`
+
+// lastOutput returns the output of the last AI message, or nil if there is none.
+func lastOutput(history *chat.Request) json.RawMessage {
+	for i := len(history.Messages) - 1; i >= 0; i-- {
+		if history.Messages[i].Role != chat.MessageRoleAI || history.Messages[i].IsToolCall() {
+			continue
+		}
+		output, err := finalOutput(history.Messages[i])
+		if err != nil {
+			return nil
+		}
+		return output
+	}
+	return nil
+}
+
+// evalPredicate evaluates the predicate on the JSON output.
+// The predicate is a JSON path optionally compared with a JSON value.
+// e.g. "$.sentiment == \"angry\"", "$.items[0].score >= 80" or "$.done".
+// A path without comparison is true when the value is not empty, false or zero.
+func evalPredicate(expr string, output json.RawMessage) (bool, error) {
+	path := predicatePath.FindString(expr)
+	if path == "" {
+		return false, fmt.Errorf("invalid predicate: %s", expr)
+	}
+	rest := strings.TrimSpace(expr[len(path):])
+
+	var root any
+	if len(output) > 0 {
+		if err := json.Unmarshal(output, &root); err != nil {
+			return false, fmt.Errorf("unmarshal output: %w", err)
+		}
+	}
+	value, ok := lookupPath(root, path)
+
+	if rest == "" {
+		return ok && truthy(value), nil
+	}
+
+	for _, op := range predicateOps {
+		literal, found := strings.CutPrefix(rest, op)
+		if !found {
+			continue
+		}
+		literal = strings.TrimSpace(literal)
+		var want any
+		if err := json.Unmarshal([]byte(literal), &want); err != nil {
+			// unquoted string
+			want = literal
+		}
+		if !ok {
+			return op == "!=", nil
+		}
+		return compare(value, op, want)
+	}
+	return false, fmt.Errorf("invalid predicate operator: %s", rest)
+}
+
+// lookupPath returns the value of the JSON path such as "$.a.b[0]".
+func lookupPath(root any, path string) (any, bool) {
+	value := root
+	rest := strings.TrimPrefix(path, "$")
+	for rest != "" {
+		if strings.HasPrefix(rest, "[") {
+			end := strings.Index(rest, "]")
+			index, err := strconv.Atoi(rest[1:end])
+			arr, ok := value.([]any)
+			if err != nil || !ok || index >= len(arr) {
+				return nil, false
+			}
+			value = arr[index]
+			rest = rest[end+1:]
+			continue
+		}
+
+		rest = strings.TrimPrefix(rest, ".")
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		value, ok = obj[rest[:end]]
+		if !ok {
+			return nil, false
+		}
+		rest = rest[end:]
+	}
+	return value, true
+}
+
+func compare(value any, op string, want any) (bool, error) {
+	switch op {
+	case "==":
+		return reflect.DeepEqual(value, want), nil
+	case "!=":
+		return !reflect.DeepEqual(value, want), nil
+	}
+
+	switch v := value.(type) {
+	case float64:
+		w, ok := want.(float64)
+		if !ok {
+			return false, fmt.Errorf("compare number with %v", want)
+		}
+		return compareOrdered(v, op, w), nil
+	case string:
+		w, ok := want.(string)
+		if !ok {
+			return false, fmt.Errorf("compare string with %v", want)
+		}
+		return compareOrdered(v, op, w), nil
+	}
+	return false, fmt.Errorf("value is not comparable: %v", value)
+}
+
+func compareOrdered[T float64 | string](v T, op string, w T) bool {
+	switch op {
+	case ">":
+		return v > w
+	case ">=":
+		return v >= w
+	case "<":
+		return v < w
+	case "<=":
+		return v <= w
+	}
+	return false
+}
+
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	}
+	return true
+}
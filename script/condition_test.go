@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package script
+
+import (
+	"testing"
+)
+
+func TestStepType(t *testing.T) {
+	tests := []struct {
+		text     string
+		wantType string
+		wantCond string
+	}{
+		{text: "If: the customer is angry", wantType: StepTypeIf, wantCond: "the customer is angry"},
+		{text: "if：`$.score >= 80`", wantType: StepTypeIf, wantCond: "`$.score >= 80`"},
+		{text: "Else:", wantType: StepTypeElse, wantCond: ""},
+		{text: "Say hello", wantType: "", wantCond: "Say hello"},
+		{text: "Check if: the answer is polite", wantType: "", wantCond: "Check if: the answer is polite"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := stepType(tt.text); got != tt.wantType {
+				t.Errorf("stepType() = %q, want %q", got, tt.wantType)
+			}
+			step := &Step{Content: tt.text}
+			if got := step.Condition(); got != tt.wantCond {
+				t.Errorf("Condition() = %q, want %q", got, tt.wantCond)
+			}
+		})
+	}
+}
+
+func TestEvalPredicate(t *testing.T) {
+	output := []byte(`{"sentiment":"angry","score":85,"done":false,"items":[{"name":"a"}],"tags":[]}`)
+	tests := []struct {
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{expr: `$.sentiment == "angry"`, want: true},
+		{expr: `$.sentiment == angry`, want: true},
+		{expr: `$.sentiment != "angry"`, want: false},
+		{expr: `$.score >= 80`, want: true},
+		{expr: `$.score < 80`, want: false},
+		{expr: `$.items[0].name == "a"`, want: true},
+		{expr: `$.items[1].name == "a"`, want: false},
+		{expr: `$.missing != 1`, want: true},
+		{expr: `$.done`, want: false},
+		{expr: `$.tags`, want: false},
+		{expr: `$.items`, want: true},
+		{expr: `$.score > "high"`, wantErr: true},
+		{expr: `$.score ~ 1`, wantErr: true},
+		{expr: `score == 1`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := evalPredicate(tt.expr, output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("evalPredicate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("evalPredicate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
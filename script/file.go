@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package script
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/internal/dataurl"
+)
+
+// inputFileName is the name of the binary input attached as a file.
+const inputFileName = "input"
+
+// File is a file attached to the first message of the script run. e.g. an image, PDF or audio.
+type File struct {
+	// Name is the name of the file which the steps refer to. e.g. "receipt.png".
+	Name string `json:"name"`
+	// URL is the content of the file as a data URL.
+	URL string `json:"url"`
+}
+
+// AddFile attaches the file to the script.
+// The MIME type is detected from the extension of the name, or from the content if it is unknown.
+func (s *Script) AddFile(name string, data []byte) {
+	mimetype, _, _ := strings.Cut(mime.TypeByExtension(filepath.Ext(name)), ";")
+	if mimetype == "" {
+		mimetype, _, _ = strings.Cut(http.DetectContentType(data), ";")
+	}
+	s.Files = append(s.Files, File{Name: name, URL: dataurl.Encode(mimetype, data)})
+}
+
+// fileParts returns the content parts of the attached files and the binary input.
+// Each file is preceded by a text part with its name so that the steps can refer to it.
+func fileParts(scr *Script) ([]chat.ContentPart, error) {
+	files := scr.Files
+	if scr.InputURL != "" {
+		_, mimetype, err := dataurl.Decode(scr.InputURL)
+		if err != nil {
+			return nil, fmt.Errorf("decode input: %w", err)
+		}
+		if !isTextMIME(mimetype) {
+			files = append([]File{{Name: inputFileName, URL: scr.InputURL}}, files...)
+		}
+	}
+
+	parts := []chat.ContentPart{}
+	for _, f := range files {
+		data, mimetype, err := dataurl.Decode(f.URL)
+		if err != nil {
+			return nil, fmt.Errorf("decode file %s: %w", f.Name, err)
+		}
+		label := chat.ContentPart{Type: "text", Text: fmt.Sprintf("FILE %s (%s):", f.Name, mimetype)}
+
+		switch {
+		case isTextMIME(mimetype):
+			label.Text += "\n" + string(data)
+			parts = append(parts, label)
+		case isImageMIME(mimetype):
+			parts = append(parts, label, chat.ContentPart{Type: "image", DataURL: f.URL})
+		case mimetype == "application/pdf" || strings.HasPrefix(mimetype, "audio/"):
+			parts = append(parts, label, chat.ContentPart{Type: "file", DataURL: f.URL})
+		default:
+			return nil, fmt.Errorf("unsupported file type %s: %s", mimetype, f.Name)
+		}
+	}
+	return parts, nil
+}
+
+func isImageMIME(mimetype string) bool {
+	switch mimetype {
+	case "image/png", "image/jpeg", "image/webp", "image/gif":
+		return true
+	}
+	return false
+}
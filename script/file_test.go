@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package script
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/internal/dataurl"
+)
+
+func TestFileParts(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	pdf := []byte("%PDF-1.4\n")
+
+	scr := &Script{}
+	scr.SetInput(png)
+	scr.AddFile("receipt.pdf", pdf)
+	scr.AddFile("notes.txt", []byte("buy milk"))
+
+	got, err := fileParts(scr)
+	if err != nil {
+		t.Fatalf("fileParts() error = %v", err)
+	}
+	want := []chat.ContentPart{
+		{Type: "text", Text: "FILE input (image/png):"},
+		{Type: "image", DataURL: dataurl.Encode("image/png", png)},
+		{Type: "text", Text: "FILE receipt.pdf (application/pdf):"},
+		{Type: "file", DataURL: dataurl.Encode("application/pdf", pdf)},
+		{Type: "text", Text: "FILE notes.txt (text/plain):\nbuy milk"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("fileParts() mismatch (-want +got):\n%s", diff)
+	}
+
+	// the text input is not attached
+	scr = &Script{}
+	scr.SetInput([]byte(`{"name":"jumon"}`))
+	if got, err := fileParts(scr); err != nil || len(got) != 0 {
+		t.Errorf("fileParts() = %v, %v, want no parts", got, err)
+	}
+
+	scr.AddFile("archive.zip", []byte("PK\x03\x04"))
+	if _, err := fileParts(scr); err == nil {
+		t.Error("expected unsupported file type error, got nil")
+	}
+}
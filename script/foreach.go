@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package script
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/nats-io/nats.go"
+)
+
+// StepTypeForEach is the step which runs its children once per element of a JSON array in the last output.
+// e.g. "- For each ticket in $.tickets:" or "- For each url in `$.urls` (parallel 4):".
+// The outputs of the iterations are collected into a JSON array as the output of the step.
+// The children can refer to the element by the item name. e.g. "{{ticket.title}}".
+const StepTypeForEach = "foreach"
+
+const defaultMaxParallel = 4
+
+// forEachItemName matches the item names which can be used as the template variables. e.g. "{{ticket.title}}".
+var forEachItemName = regexp.MustCompile(`^[A-Za-z_][\w\-]*$`)
+
+var forEachPattern = regexp.MustCompile("(?i)^for each\\s+(\\S+)\\s+in\\s+`?(\\$[^`\\s]*)`?(?:\\s+\\(parallel(?:\\s+(\\d+))?\\))?\\s*[:：]")
+
+// ForEach is the parsed for each step.
+type ForEach struct {
+	// Item is the name of the element. e.g. "ticket".
+	Item string
+	// Path is the JSON path of the array in the last output. e.g. "$.tickets".
+	Path string
+	// Parallel is the maximum number of the iterations run concurrently.
+	// 1 runs the iterations sequentially.
+	Parallel int
+}
+
+// ForEach returns the parsed for each step.
+func (s *Step) ForEach() (*ForEach, error) {
+	m := forEachPattern.FindStringSubmatch(strings.TrimSpace(s.Content))
+	if m == nil {
+		return nil, fmt.Errorf("invalid for each step: %s", s.Content)
+	}
+	fe := &ForEach{Item: m[1], Path: m[2], Parallel: 1}
+	if !forEachItemName.MatchString(fe.Item) || slices.Contains(varScopes, fe.Item) {
+		return nil, fmt.Errorf("invalid for each item name: %s", fe.Item)
+	}
+	if predicatePath.FindString(fe.Path) != fe.Path {
+		return nil, fmt.Errorf("invalid for each path: %s", fe.Path)
+	}
+	if strings.Contains(strings.ToLower(m[0]), "(parallel") {
+		fe.Parallel = defaultMaxParallel
+		if m[3] != "" {
+			n, err := strconv.Atoi(m[3])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid for each parallel limit: %s", m[3])
+			}
+			fe.Parallel = n
+		}
+	}
+	return fe, nil
+}
+
+// runForEach runs the children of the for each step once per element, and appends the step and
+// the collected outputs to the history. Each iteration runs with its own copy of the history.
+func runForEach(ctx context.Context, nc *nats.Conn, scr *Script, step *Step, history *chat.Request, vars *vars) error {
+	ctx, span := tracer.Start(ctx, nc, "script.step.run")
+	defer span.End()
+
+	fe, err := step.ForEach()
+	if err != nil {
+		span.SetError(err)
+		return err
+	}
+	items, err := forEachItems(fe.Path, lastOutput(history))
+	if err != nil {
+		span.SetError(err)
+		return err
+	}
+	span.SetAttribute("items", strconv.Itoa(len(items)))
+	span.SetAttribute("parallel", strconv.Itoa(fe.Parallel))
+	span.SetRequest(step.Markdown())
+	slog.Debug("run for each", "item", fe.Item, "path", fe.Path, "items", len(items), "parallel", fe.Parallel)
+
+	// the first failed iteration cancels the others, and its error is the cause of the context.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	outputs := make([]json.RawMessage, len(items))
+	sem := make(chan struct{}, fe.Parallel)
+	var wg sync.WaitGroup
+	for i, item := range items {
+		if err := acquire(ctx, sem); err != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			output, err := runIteration(ctx, nc, scr, step, fe, i, item, history, vars)
+			if err != nil {
+				cancel(fmt.Errorf("iteration %d: %w", i, err))
+				return
+			}
+			outputs[i] = output
+		}()
+	}
+	wg.Wait()
+
+	if err := context.Cause(ctx); err != nil {
+		span.SetError(err)
+		return fmt.Errorf("for each %s: %w", fe.Item, err)
+	}
+
+	results, err := json.Marshal(outputs)
+	if err != nil {
+		span.SetError(err)
+		return fmt.Errorf("marshal for each results: %w", err)
+	}
+	md, err := vars.withItem(fe.Item, nil).render(step.Markdown())
+	if err != nil {
+		span.SetError(err)
+		return fmt.Errorf("render step: %w", err)
+	}
+	history.Messages = append(history.Messages,
+		chat.NewTextMessage(chat.MessageRoleHuman, md),
+		chat.NewTextMessage(chat.MessageRoleAI, string(results)),
+	)
+	span.SetResponse(results)
+	return nil
+}
+
+// runIteration runs the children of the for each step for the item and returns the last output.
+func runIteration(ctx context.Context, nc *nats.Conn, scr *Script, step *Step, fe *ForEach, index int, item json.RawMessage, history *chat.Request, vars *vars) (json.RawMessage, error) {
+	ctx, span := tracer.Start(ctx, nc, "script.step.iteration")
+	defer span.End()
+	span.SetAttribute("index", strconv.Itoa(index))
+	span.SetRequest(item)
+
+	iteration := &chat.Request{Messages: slices.Clone(history.Messages)}
+	iteration.Messages = append(iteration.Messages, chat.NewTextMessage(chat.MessageRoleHuman, fmt.Sprintf(iterationPromptTemplate, fe.Item, index+1, string(item))))
+	if err := runSteps(ctx, nc, scr, step.Children, iteration, vars.withItem(fe.Item, item), false, nil); err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+
+	output := lastOutput(iteration)
+	if output == nil {
+		output = json.RawMessage("null")
+	}
+	span.SetResponse(output)
+	return output, nil
+}
+
+const iterationPromptTemplate = `Run the following steps for the %s #%d:
+%s`
+
+// forEachItems returns the elements of the JSON array at the path of the output.
+func forEachItems(path string, output json.RawMessage) ([]json.RawMessage, error) {
+	var root any
+	if len(output) > 0 {
+		if err := json.Unmarshal(output, &root); err != nil {
+			return nil, fmt.Errorf("unmarshal output: %w", err)
+		}
+	}
+	value, ok := lookupPath(root, path)
+	if !ok {
+		return nil, fmt.Errorf("for each path not found: %s", path)
+	}
+	arr, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("for each path is not an array: %s", path)
+	}
+
+	items := make([]json.RawMessage, len(arr))
+	for i, v := range arr {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("marshal item: %w", err)
+		}
+		items[i] = data
+	}
+	return items, nil
+}
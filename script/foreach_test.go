@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package script
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStepForEach(t *testing.T) {
+	tests := []struct {
+		content string
+		want    *ForEach
+		wantErr bool
+	}{
+		{content: "For each ticket in $.tickets:", want: &ForEach{Item: "ticket", Path: "$.tickets", Parallel: 1}},
+		{content: "for each url in `$.pages[0].urls`:", want: &ForEach{Item: "url", Path: "$.pages[0].urls", Parallel: 1}},
+		{content: "For each file in $ (parallel):", want: &ForEach{Item: "file", Path: "$", Parallel: defaultMaxParallel}},
+		{content: "For each file in $.files (parallel 2)：", want: &ForEach{Item: "file", Path: "$.files", Parallel: 2}},
+		{content: "For each file in $.files (parallel 0):", wantErr: true},
+		{content: "For each file in files:", wantErr: true},
+		{content: "For each input in $.files:", wantErr: true},
+		{content: "For each file.name in $.files:", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			step := &Step{Content: tt.content}
+			got, err := step.ForEach()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ForEach() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ForEach() mismatch (-want +got):\n%s", diff)
+			}
+			if !tt.wantErr && stepType(tt.content) != StepTypeForEach {
+				t.Errorf("stepType() = %q, want %q", stepType(tt.content), StepTypeForEach)
+			}
+		})
+	}
+}
+
+func TestForEachItems(t *testing.T) {
+	output := json.RawMessage(`{"tickets":[{"id":1},"two",3],"name":"list"}`)
+
+	got, err := forEachItems("$.tickets", output)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []json.RawMessage{json.RawMessage(`{"id":1}`), json.RawMessage(`"two"`), json.RawMessage(`3`)}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("items mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := forEachItems("$.name", output); err == nil {
+		t.Error("expected not an array error, got nil")
+	}
+	if _, err := forEachItems("$.missing", output); err == nil {
+		t.Error("expected path not found error, got nil")
+	}
+}
@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package script
+
+import (
+	"context"
+
+	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/jumon/internal/tracer"
+)
+
+type historyKey struct{}
+
+type finalStreamKey struct{}
+
+type streamToKey struct{}
+
+// WithHistory returns the context which makes Run continue the conversation of the messages.
+// e.g. the previous messages of the OpenAI compatible chat completions.
+func WithHistory(ctx context.Context, messages []chat.Message) context.Context {
+	return context.WithValue(ctx, historyKey{}, messages)
+}
+
+func historyMessages(ctx context.Context) []chat.Message {
+	messages, _ := ctx.Value(historyKey{}).([]chat.Message)
+	return messages
+}
+
+// WithFinalStream returns the context which makes Run stream only the chat of the final step,
+// so that the stream has the text of the final output. e.g. the OpenAI compatible chat completions.
+// Otherwise Run streams the chat of every step.
+func WithFinalStream(ctx context.Context) context.Context {
+	return context.WithValue(ctx, finalStreamKey{}, true)
+}
+
+// holdStream removes the stream-to subject from the context until finalStream if only the final step is streamed.
+func holdStream(ctx context.Context) context.Context {
+	if final, _ := ctx.Value(finalStreamKey{}).(bool); !final {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, streamToKey{}, tracer.ContextValueStreamTo(ctx))
+	return tracer.WithStreamTo(ctx, "")
+}
+
+// finalStream returns the context which streams the chat to the subject removed by holdStream.
+func finalStream(ctx context.Context) context.Context {
+	streamTo, ok := ctx.Value(streamToKey{}).(string)
+	if !ok {
+		return ctx
+	}
+	return tracer.WithStreamTo(ctx, streamTo)
+}
@@ -152,12 +152,20 @@ func parseListItem(node ast.Node, r text.Reader, currentLevel int, root, current
 		return nil, err
 	}
 
+	model, config, text, err := parseAnnotation(text)
+	if err != nil {
+		return nil, err
+	}
+
 	marker := getListItemMarker(node)
 	item := &Step{
-		Level:    currentLevel,
-		Marker:   marker,
-		Content:  text,
-		Children: []*Step{},
+		Level:       currentLevel,
+		Marker:      marker,
+		Type:        stepType(text),
+		Content:     text,
+		Model:       model,
+		ModelConfig: config,
+		Children:    []*Step{},
 	}
 
 	if item.Level > currentItem.Level {
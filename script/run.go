@@ -8,12 +8,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
-	"strings"
+	"sync"
 
 	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/gengo/jsonschema"
 	chatsvc "github.com/jumonmd/jumon/chat"
 	"github.com/jumonmd/jumon/internal/dataurl"
 	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/jumonmd/jumon/internal/validate"
 	"github.com/jumonmd/jumon/tool"
 	"github.com/nats-io/nats.go"
 )
@@ -24,6 +26,7 @@ func Run(ctx context.Context, nc *nats.Conn, scr *Script) (json.RawMessage, erro
 
 	ctx, span := tracer.Start(ctx, nc, "script.run")
 	defer span.End()
+	ctx = holdStream(ctx)
 
 	slog.Debug("parse steps", "script", scr.Content)
 	steps, preface, err := scr.Steps()
@@ -32,77 +35,190 @@ func Run(ctx context.Context, nc *nats.Conn, scr *Script) (json.RawMessage, erro
 		return nil, fmt.Errorf("parse steps: %w", err)
 	}
 
+	// the script continues the conversation of the history if any.
 	history := &chat.Request{
-		Messages: []chat.Message{},
+		Messages: append([]chat.Message{}, historyMessages(ctx)...),
+	}
+
+	vars, err := newVars(scr.InputURL)
+	if err != nil {
+		span.SetError(fmt.Errorf("parse input: %w", err))
+		return nil, fmt.Errorf("parse input: %w", err)
 	}
 
 	// construct initial prompt
-	initialPrompt, err := initialPrompt(preface, scr.InputURL)
+	initialPrompt, err := initialPrompt(preface, scr.InputURL, vars, !usesInputVars(scr.Content))
 	if err != nil {
 		span.SetError(fmt.Errorf("construct initial prompt: %w", err))
 		return nil, fmt.Errorf("construct initial prompt: %w", err)
 	}
 
-	if initialPrompt != "" {
-		history.Messages = append(history.Messages, chat.NewTextMessage(chat.MessageRoleHuman, initialPrompt))
+	files, err := fileParts(scr)
+	if err != nil {
+		span.SetError(fmt.Errorf("attach files: %w", err))
+		return nil, fmt.Errorf("attach files: %w", err)
+	}
+
+	if initialPrompt != "" || len(files) > 0 {
+		msg := chat.Message{Role: chat.MessageRoleHuman}
+		if initialPrompt != "" {
+			msg = chat.NewTextMessage(chat.MessageRoleHuman, initialPrompt)
+		}
+		msg.Content = append(msg.Content, files...)
+		history.Messages = append(history.Messages, msg)
 	}
 
-	// if no steps, create a single step with the initial prompt
+	// if no steps, create a single step with the preface. the input is in the initial prompt.
 	if len(steps) == 0 {
-		steps = []*Step{{Level: 1, Content: initialPrompt}}
+		steps = []*Step{{Level: 1, Content: preface}}
 	}
 
 	slog.Debug("initial prompt", "prompt", initialPrompt, "steps", len(steps))
 
-	for i, step := range steps {
-		ctx, sspan := tracer.Start(ctx, nc, "script.step.run")
-		defer sspan.End()
-		slog.Debug("run step", "index", i+1, "step", step.Content)
-
-		req := stepRequest(scr, step, history)
-		sspan.SetRequest(req)
-
-		history.Messages = append(history.Messages, req.Messages[len(req.Messages)-1])
-
-		// run step
-		slog.Debug("run step", "step", step.Markdown())
-		resp, err := runStep(ctx, nc, req, scr.Tools)
+	// restore the history of the completed steps.
+	runID := checkpointID(ctx)
+	completed := 0
+	if runID != "" {
+		cp, err := getCheckpoint(ctx, nc, runID)
 		if err != nil {
-			sspan.SetError(fmt.Errorf("run step: %w", err))
-			return nil, fmt.Errorf("run step: %w", err)
+			span.SetError(fmt.Errorf("restore checkpoint: %w", err))
+			return nil, fmt.Errorf("restore checkpoint: %w", err)
 		}
+		if cp != nil && cp.Script == scr.Name && cp.Steps <= len(steps) {
+			slog.Info("resume script", "name", scr.Name, "run_id", runID, "completed", cp.Steps)
+			history.Messages = cp.Messages
+			completed = cp.Steps
+			for n, output := range cp.Outputs {
+				vars.steps[n] = output
+			}
+		}
+	}
 
-		history.Messages = append(history.Messages, resp.Messages...)
-		sspan.SetResponse(resp)
+	err = runSteps(ctx, nc, scr, steps[completed:], history, vars, true, func(i int) {
+		// the output of the if and else steps is the output of the branch.
+		for n := len(vars.steps) + 1; n <= completed+i; n++ {
+			vars.steps[n] = lastOutput(history)
+		}
+		if runID == "" {
+			return
+		}
+		cp := &Checkpoint{RunID: runID, Script: scr.Name, Steps: completed + i, Messages: history.Messages, Outputs: vars.steps}
+		if err := putCheckpoint(ctx, nc, cp); err != nil {
+			slog.Error("run script", "status", "put checkpoint failed", "run_id", runID, "error", err)
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	output, err := finalOutput(history.Messages[len(history.Messages)-1])
+	output, err := validOutput(ctx, nc, scr, history)
 	if err != nil {
 		span.SetError(fmt.Errorf("final output: %w", err))
 		return nil, fmt.Errorf("final output: %w", err)
 	}
 	span.SetResponse(output)
 
+	if runID != "" {
+		if err := deleteCheckpoint(ctx, nc, runID); err != nil {
+			slog.Error("run script", "status", "delete checkpoint failed", "run_id", runID, "error", err)
+		}
+	}
 	return output, nil
 }
 
-// initialPrompt constructs the initial prompt from preface and input URL.
-func initialPrompt(preface, inputURL string) (string, error) {
-	initialPrompt := preface
-	if inputURL != "" {
+// runSteps runs the steps in order and calls done with the number of the completed steps.
+// An if step runs its children, or the children of the following else step if the condition is false.
+// A for each step runs its children once per element of the array in the last output.
+// If final is true, the last step is requested with the response schema.
+func runSteps(ctx context.Context, nc *nats.Conn, scr *Script, steps []*Step, history *chat.Request, vars *vars, final bool, done func(int)) error {
+	for i := 0; i < len(steps); i++ {
+		step := steps[i]
+		switch step.Type {
+		case StepTypeIf:
+			var elseStep *Step
+			if i+1 < len(steps) && steps[i+1].Type == StepTypeElse {
+				elseStep = steps[i+1]
+				i++
+			}
+			branch, err := runCondition(ctx, nc, scr, step, elseStep, history, vars)
+			if err != nil {
+				return err
+			}
+			if err := runSteps(ctx, nc, scr, branch, history, vars, final && i == len(steps)-1, nil); err != nil {
+				return err
+			}
+		case StepTypeForEach:
+			if err := runForEach(ctx, nc, scr, step, history, vars); err != nil {
+				return err
+			}
+		default:
+			if err := runScriptStep(ctx, nc, scr, step, history, vars, final && i == len(steps)-1); err != nil {
+				return err
+			}
+		}
+		if done != nil {
+			done(i + 1)
+		}
+	}
+	return nil
+}
+
+// runScriptStep runs the step and appends the request and response messages to the history.
+func runScriptStep(ctx context.Context, nc *nats.Conn, scr *Script, step *Step, history *chat.Request, vars *vars, last bool) error {
+	ctx, sspan := tracer.Start(ctx, nc, "script.step.run")
+	defer sspan.End()
+	slog.Debug("run step", "step", step.Content)
+
+	md, err := vars.render(step.Markdown())
+	if err != nil {
+		sspan.SetError(fmt.Errorf("render step: %w", err))
+		return fmt.Errorf("render step: %w", err)
+	}
+	req := stepRequest(scr, md, history)
+	step.overrideModel(req)
+	if last {
+		req.ResponseSchema = responseSchema(scr)
+		ctx = finalStream(ctx)
+	}
+	sspan.SetRequest(req)
+
+	history.Messages = append(history.Messages, req.Messages[len(req.Messages)-1])
+
+	// run step
+	slog.Debug("run step", "step", md)
+	resp, err := runStep(ctx, nc, req, scr.Tools, scr.Config)
+	if err != nil {
+		sspan.SetError(fmt.Errorf("run step: %w", err))
+		return fmt.Errorf("run step: %w", err)
+	}
+
+	history.Messages = append(history.Messages, resp.Messages...)
+	sspan.SetResponse(resp)
+	return nil
+}
+
+// initialPrompt constructs the initial prompt from the rendered preface and the input URL.
+// The input is appended unless withInput is false, e.g. the script refers to the input variables.
+func initialPrompt(preface, inputURL string, vars *vars, withInput bool) (string, error) {
+	initialPrompt, err := vars.render(preface)
+	if err != nil {
+		return "", fmt.Errorf("render preface: %w", err)
+	}
+	if inputURL != "" && withInput {
 		input, mimetype, err := dataurl.Decode(inputURL)
 		if err != nil {
 			return "", fmt.Errorf("decode input: %w", err)
 		}
-		if strings.HasPrefix(mimetype, "text/") || strings.HasPrefix(mimetype, "application/") {
+		// the binary input is attached as a file.
+		if len(input) > 0 && isTextMIME(mimetype) {
 			initialPrompt = fmt.Sprintf("%s\n\nINPUT:\n%s", initialPrompt, string(input))
 		}
 	}
 	return initialPrompt, nil
 }
 
-// stepRequest prepares the chat request message for a script step.
-func stepRequest(scr *Script, step *Step, history *chat.Request) *chat.Request {
+// newRequest prepares the chat request with the script model, tools and history.
+func newRequest(scr *Script, history *chat.Request) *chat.Request {
 	req := &chat.Request{
 		Model:    scr.Model,
 		Messages: history.Messages,
@@ -113,10 +229,16 @@ func stepRequest(scr *Script, step *Step, history *chat.Request) *chat.Request {
 	for _, tl := range scr.Tools {
 		req.Tools = append(req.Tools, tl.ChatTool())
 	}
+	return req
+}
+
+// stepRequest prepares the chat request message for the rendered markdown of a script step.
+func stepRequest(scr *Script, md string, history *chat.Request) *chat.Request {
+	req := newRequest(scr, history)
 
 	// msg is divided into a special check part and a normal content part.
-	msg := chat.NewTextMessage(chat.MessageRoleHuman, removeChecks(step.Markdown()))
-	if checks := parseChecks(step.Markdown()); checks != "" {
+	msg := chat.NewTextMessage(chat.MessageRoleHuman, removeChecks(md))
+	if checks := parseChecks(md); checks != "" {
 		msg.Content = append(msg.Content, chat.ContentPart{
 			Type: "check",
 			Text: checks,
@@ -127,38 +249,213 @@ func stepRequest(scr *Script, step *Step, history *chat.Request) *chat.Request {
 	return req
 }
 
-func runStep(ctx context.Context, nc *nats.Conn, req *chat.Request, tools []tool.Tool) (*chat.Response, error) {
+// runStep calls the model and runs the requested tools until the model returns no tool calls.
+// Each round is traced as its own span. It returns all messages generated in the step.
+func runStep(ctx context.Context, nc *nats.Conn, req *chat.Request, tools []tool.Tool, cfg Config) (*chat.Response, error) {
+	maxRounds := valueOr(cfg.MaxToolRounds, defaultMaxToolRounds)
+	// the tools are turned off, so the model is called once without them.
+	if maxRounds == 0 {
+		req.Tools = nil
+		tools = nil
+		maxRounds = 1
+	}
+	ctx = chatsvc.WithMaxCheckRetries(ctx, valueOr(cfg.MaxCheckRetries, defaultMaxCheckRetries))
+
+	stepResp := &chat.Response{}
+	for round := 1; round <= maxRounds; round++ {
+		resp, err := runRound(ctx, nc, req, tools, cfg, round)
+		if err != nil {
+			return nil, err
+		}
+
+		stepResp.Model = resp.Model
+		stepResp.FinishReason = resp.FinishReason
+		stepResp.Usage = resp.Usage
+		stepResp.Messages = append(stepResp.Messages, resp.Messages...)
+
+		if len(resp.ToolCalls()) == 0 {
+			return stepResp, nil
+		}
+	}
+
+	return nil, fmt.Errorf("max tool rounds exceeded: %d", maxRounds)
+}
+
+// runRound calls the model once and runs the tool calls of the response.
+// The generated messages and tool responses are appended to req for the next round.
+func runRound(ctx context.Context, nc *nats.Conn, req *chat.Request, tools []tool.Tool, cfg Config, round int) (*chat.Response, error) {
+	ctx, span := tracer.Start(ctx, nc, "script.step.round")
+	defer span.End()
+	slog.Debug("run round", "round", round)
+
+	span.SetRequest(req)
 	resp, err := chatsvc.Generate(ctx, nc, req)
 	if err != nil {
+		span.SetError(fmt.Errorf("chat generate: %w", err))
 		return nil, fmt.Errorf("chat generate: %w", err)
 	}
 
 	req.Messages = append(req.Messages, resp.Messages...)
-	for _, msg := range resp.ToolCalls() {
-		slog.Info("tool call", "tool", msg.ToolCall.Name, "args", msg.ToolCall.Arguments)
-		tl := tool.Tool{}
-		for _, t := range tools {
-			if t.Name != msg.ToolCall.Name {
-				continue
+
+	// the tool calls are not streamed, so that the deltas of the concurrent calls are not mixed.
+	toolResps := runToolCalls(tracer.WithStreamTo(ctx, ""), nc, resp.ToolCalls(), tools, cfg.MaxToolCalls)
+	req.Messages = append(req.Messages, toolResps...)
+	resp.Messages = append(resp.Messages, toolResps...)
+
+	span.SetResponse(resp)
+	return resp, nil
+}
+
+// runToolCalls runs the tool calls concurrently up to limit and returns the tool responses in call order.
+// A failed call is returned as an error tool response so that the model can handle it.
+func runToolCalls(ctx context.Context, nc *nats.Conn, calls []chat.Message, tools []tool.Tool, limit int) []chat.Message {
+	if limit <= 0 {
+		limit = defaultMaxToolCalls
+	}
+
+	resps := make([]chat.Message, len(calls))
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, msg := range calls {
+		// the calls waiting for the limit are not run after the context is done.
+		if err := acquire(ctx, sem); err != nil {
+			resps[i] = chat.NewToolResponseMessage(msg.ToolCall.Name, msg.ToolCall.ID, string(toolErrorOutput(err)))
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			output, err := runToolCall(ctx, nc, msg.ToolCall, tools)
+			if err != nil {
+				slog.Warn("tool call", "tool", msg.ToolCall.Name, "error", err)
+				output = toolErrorOutput(err)
 			}
-			tl = t
+			resps[i] = chat.NewToolResponseMessage(msg.ToolCall.Name, msg.ToolCall.ID, string(output))
+		}()
+	}
+	wg.Wait()
+
+	return resps
+}
+
+// acquire waits for a slot of the semaphore, or returns the error of the context if it is done first.
+func acquire(ctx context.Context, sem chan struct{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runToolCall runs a tool requested by the model.
+func runToolCall(ctx context.Context, nc *nats.Conn, call *chat.ToolCall, tools []tool.Tool) (json.RawMessage, error) {
+	slog.Info("tool call", "tool", call.Name, "args", call.Arguments)
+	tl := tool.Tool{}
+	for _, t := range tools {
+		if t.Name != call.Name {
+			continue
+		}
+		tl = t
+	}
+	if tl.Name == "" {
+		return nil, fmt.Errorf("tool not found: %s", call.Name)
+	}
+
+	tl.SetInput([]byte(call.Arguments))
+	output, err := tool.Run(ctx, nc, tl)
+	if err != nil {
+		return nil, fmt.Errorf("tool execute: %w", err)
+	}
+
+	slog.Debug("tool call response", "call", call, "response", string(output))
+	return output, nil
+}
+
+// toolErrorOutput returns the error as a tool response content.
+func toolErrorOutput(err error) json.RawMessage {
+	output, merr := json.Marshal(map[string]string{"error": err.Error()})
+	if merr != nil {
+		return json.RawMessage(`{"error":"tool call failed"}`)
+	}
+	return output
+}
+
+// responseSchema returns the output schema to be passed to the model as a structured output.
+// Providers accept only object schemas for structured outputs.
+func responseSchema(scr *Script) jsonschema.Schema {
+	if typ, ok := scr.OutputSchema["type"].(string); ok && typ == "object" {
+		return scr.OutputSchema
+	}
+	return nil
+}
+
+// validOutput returns the final output validated against the output schema.
+// If the validation fails, the errors are sent back to the model to repair the output
+// up to the configured attempts.
+func validOutput(ctx context.Context, nc *nats.Conn, scr *Script, history *chat.Request) (json.RawMessage, error) {
+	maxRepairs := valueOr(scr.Config.MaxRepairs, defaultMaxRepairs)
+
+	for attempt := 0; ; attempt++ {
+		output, err := finalOutput(history.Messages[len(history.Messages)-1])
+		if err != nil {
+			return nil, err
+		}
+
+		verr := validate.JSON(scr.OutputSchema, output)
+		if verr == nil {
+			return output, nil
 		}
-		if tl.Name == "" {
-			return nil, fmt.Errorf("tool not found: %s", msg.ToolCall.Name)
+		if attempt >= maxRepairs {
+			return nil, ErrValidateOutput.Wrap(verr)
 		}
-		tl.SetInput([]byte(msg.ToolCall.Arguments))
-		output, err := tool.Run(ctx, nc, tl)
+
+		slog.Info("repair output", "attempt", attempt+1, "error", verr)
+		err = repairOutput(ctx, nc, scr, history, verr)
 		if err != nil {
-			return nil, fmt.Errorf("tool execute: %w", err)
+			return nil, fmt.Errorf("repair output: %w", err)
 		}
+	}
+}
+
+// repairOutput asks the model to fix the output with the validation errors.
+func repairOutput(ctx context.Context, nc *nats.Conn, scr *Script, history *chat.Request, verr error) error {
+	ctx, span := tracer.Start(ctx, nc, "script.repair")
+	defer span.End()
+
+	req := newRequest(scr, history)
+	req.ResponseSchema = responseSchema(scr)
+	msg := chat.NewTextMessage(chat.MessageRoleHuman, fmt.Sprintf(repairPromptTemplate, verr.Error(), string(scr.OutputSchema.JSON())))
+	req.Messages = append(req.Messages, msg)
+	span.SetRequest(req)
 
-		slog.Debug("tool call response", "call", msg.ToolCall, "response", string(output))
-		resp.Messages = append(resp.Messages, chat.NewToolResponseMessage(msg.ToolCall.Name, msg.ToolCall.ID, string(output)))
+	history.Messages = append(history.Messages, msg)
+	resp, err := runStep(ctx, nc, req, scr.Tools, scr.Config)
+	if err != nil {
+		span.SetError(err)
+		return err
 	}
 
-	return resp, nil
+	history.Messages = append(history.Messages, resp.Messages...)
+	span.SetResponse(resp)
+	return nil
 }
 
+const repairPromptTemplate = `The output does not match the output schema.
+
+Errors:
+%s
+
+Output schema:
+%s
+
+Answer again with only the JSON that matches the output schema.`
+
 func finalOutput(resp chat.Message) (json.RawMessage, error) {
 	if resp.Role != chat.MessageRoleAI {
 		slog.Error("final output is not an AI message", "role", resp.Role, "message", resp.ContentString())
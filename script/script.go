@@ -12,13 +12,18 @@ import (
 	"github.com/jumonmd/gengo/chat"
 	"github.com/jumonmd/gengo/jsonschema"
 	"github.com/jumonmd/jumon/internal/dataurl"
+	"github.com/jumonmd/jumon/internal/validate"
 	"github.com/jumonmd/jumon/tool"
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/text"
 )
 
 const (
-	defaultTimeoutSeconds = 300
+	defaultTimeoutSeconds  = 300
+	defaultMaxToolRounds   = 10
+	defaultMaxToolCalls    = 4
+	defaultMaxRepairs      = 2
+	defaultMaxCheckRetries = 2
 )
 
 // Script is a definition for multi-step AI prompt.
@@ -39,10 +44,24 @@ type Script struct {
 	Content string `json:"content"`
 	// InputURL is the input as a data URL.
 	InputURL string `json:"input_url"`
+	// Files are attached to the first message with their names.
+	Files []File `json:"files,omitempty"`
 }
 
 type Config struct {
 	TimeoutSeconds int `json:"timeout_seconds"`
+	// MaxToolRounds is the maximum number of model calls in a step, 10 if not set.
+	// The step ends when the model returns no tool calls or the limit is reached.
+	// 0 turns off the tools, and the model is called once without them.
+	MaxToolRounds *int `json:"max_tool_rounds,omitempty"`
+	// MaxToolCalls is the maximum number of tool calls run concurrently in a step.
+	MaxToolCalls int `json:"max_tool_calls,omitempty"`
+	// MaxRepairs is the maximum number of attempts to repair an output that does not match the output schema, 2 if not set.
+	// 0 turns off the repairs.
+	MaxRepairs *int `json:"max_repairs,omitempty"`
+	// MaxCheckRetries is the maximum number of regenerations of a step response that fails the checks, 2 if not set.
+	// 0 turns off the regenerations, and the response which fails the checks is an error.
+	MaxCheckRetries *int `json:"max_check_retries,omitempty"`
 }
 
 // Step defines an executable step in a script. Steps can be nested to create hierarchical structures.
@@ -51,10 +70,15 @@ type Step struct {
 	Level int
 	// Marker is the markdown list marker. e.g. "-", "*", "1.", etc.
 	Marker string
-	// Type for the extension of the step.
-	Type     string
-	Content  string
-	Children []*Step
+	// Type for the extension of the step. e.g. "if", "else" or "foreach".
+	// The children of the conditional and for each steps run as steps.
+	Type    string
+	Content string
+	// Model and ModelConfig override the script model for this step only.
+	// They are set by the inline annotation. e.g. "- [model: claude-3-haiku, temperature: 0.2] Summarize".
+	Model       string
+	ModelConfig *chat.ModelConfig
+	Children    []*Step
 }
 
 // Symbol represents a definition in the script that can be referenced by name as a variable or tool.
@@ -72,6 +96,9 @@ func (s *Script) Steps() ([]*Step, string, error) {
 	if err != nil {
 		return nil, "", fmt.Errorf("parse list: %w", err)
 	}
+	if err := validateSteps(root.Children); err != nil {
+		return nil, "", err
+	}
 
 	return root.Children, preface, nil
 }
@@ -99,9 +126,12 @@ func (s *Step) Markdown() string {
 }
 
 // SetInput sets the input as a data URL.
-// mime is automatically detected.
+// mime is automatically detected, and a valid JSON is application/json.
 func (s *Script) SetInput(input []byte) {
 	mime := http.DetectContentType(input)
+	if json.Valid(input) {
+		mime = "application/json"
+	}
 	s.InputURL = dataurl.Encode(mime, input)
 }
 
@@ -118,6 +148,18 @@ func (s *Script) Symbols() ([]Symbols, error) {
 	return symbols, nil
 }
 
+// ValidateInput validates the input against the input schema.
+func (s *Script) ValidateInput() error {
+	if len(s.InputSchema) == 0 {
+		return nil
+	}
+	input, _, err := dataurl.Decode(s.InputURL)
+	if err != nil {
+		return fmt.Errorf("decode input: %w", err)
+	}
+	return validate.JSON(s.InputSchema, input)
+}
+
 func (s *Script) Validate() error {
 	if s.Name == "" {
 		return fmt.Errorf("name is required")
@@ -125,9 +167,31 @@ func (s *Script) Validate() error {
 	if s.Model == "" {
 		return fmt.Errorf("model is required")
 	}
+	return s.Config.Validate()
+}
+
+// Validate validates the limits of the config. The limits which are not set use the defaults.
+func (c Config) Validate() error {
+	if c.MaxToolRounds != nil && *c.MaxToolRounds < 0 {
+		return fmt.Errorf("max_tool_rounds must not be negative")
+	}
+	if c.MaxRepairs != nil && *c.MaxRepairs < 0 {
+		return fmt.Errorf("max_repairs must not be negative")
+	}
+	if c.MaxCheckRetries != nil && *c.MaxCheckRetries < 0 {
+		return fmt.Errorf("max_check_retries must not be negative")
+	}
 	return nil
 }
 
+// valueOr returns the value of p, or def if p is nil.
+func valueOr(p *int, def int) int {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
 // AsTool converts the script to a [tool.Tool].
 func (s *Script) AsTool() (tool.Tool, error) {
 	scrdata, err := json.Marshal(s)
@@ -45,6 +45,42 @@ func TestScriptValidate(t *testing.T) {
 			script:  Script{},
 			wantErr: true,
 		},
+		{
+			name: "tools turned off",
+			script: Script{
+				Name:   "test script",
+				Model:  "test model",
+				Config: Config{MaxToolRounds: intPtr(0)},
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative max repairs",
+			script: Script{
+				Name:   "test script",
+				Model:  "test model",
+				Config: Config{MaxRepairs: intPtr(-1)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max check retries",
+			script: Script{
+				Name:   "test script",
+				Model:  "test model",
+				Config: Config{MaxCheckRetries: intPtr(-1)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max tool rounds",
+			script: Script{
+				Name:   "test script",
+				Model:  "test model",
+				Config: Config{MaxToolRounds: intPtr(-1)},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -122,6 +158,52 @@ func TestScriptSteps(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "conditional steps",
+			script: Script{
+				Name: "test script",
+				Content: `
+- If: angry
+  - apologize
+- Else:
+  - thank
+`,
+			},
+			want: []*Step{
+				{
+					Level:   1,
+					Marker:  "-",
+					Type:    StepTypeIf,
+					Content: "If: angry",
+					Children: []*Step{
+						{Level: 2, Marker: "-", Content: "apologize", Children: []*Step{}},
+					},
+				},
+				{
+					Level:   1,
+					Marker:  "-",
+					Type:    StepTypeElse,
+					Content: "Else:",
+					Children: []*Step{
+						{Level: 2, Marker: "-", Content: "thank", Children: []*Step{}},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "else without if",
+			script: Script{
+				Name: "test script",
+				Content: `
+- step1
+- Else:
+  - thank
+`,
+			},
+			want:    nil,
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -193,3 +275,55 @@ func TestScriptAsTool(t *testing.T) {
 		})
 	}
 }
+
+func TestScriptValidateInput(t *testing.T) {
+	schema := jsonschema.Schema{
+		"type":       "object",
+		"properties": map[string]any{"name": map[string]any{"type": "string"}},
+		"required":   []any{"name"},
+	}
+
+	tests := []struct {
+		name    string
+		schema  jsonschema.Schema
+		input   string
+		wantErr bool
+	}{
+		{
+			name:   "valid input",
+			schema: schema,
+			input:  `{"name":"jumon"}`,
+		},
+		{
+			name:    "missing property",
+			schema:  schema,
+			input:   `{}`,
+			wantErr: true,
+		},
+		{
+			name:    "not json",
+			schema:  schema,
+			input:   `jumon`,
+			wantErr: true,
+		},
+		{
+			name:  "no schema",
+			input: `jumon`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scr := Script{Name: "test script", InputSchema: tt.schema}
+			scr.SetInput([]byte(tt.input))
+			err := scr.ValidateInput()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Script.ValidateInput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
@@ -12,13 +12,17 @@ import (
 
 	"github.com/jumonmd/jumon/internal/errors"
 	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/jumonmd/jumon/tool"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/micro"
 )
 
 var (
 	ErrValidateScript = errors.New(400300, "validate script failed")
+	ErrInvalidInput   = errors.New(400301, "invalid input")
+	ErrInvalidModule  = errors.New(400302, "invalid module")
 	ErrRunScript      = errors.New(500300, "run script failed")
+	ErrValidateOutput = errors.New(500301, "validate output failed")
 )
 
 // NewService creates a new script service.
@@ -49,9 +53,20 @@ func runHandler(nc *nats.Conn, r micro.Request) {
 
 	ctx, span := tracer.Start(tracer.NewContext(r.Headers()), nc, "script.run")
 	defer span.End()
+	ctx, stop := span.WithCancel(ctx)
+	defer stop()
+
+	// the script tools pass the module running them to the nested tool calls.
+	modname, err := tool.RequestModule(r)
+	if err != nil {
+		span.SetError(ErrInvalidModule.Wrap(err))
+		r.Error(ErrInvalidModule.ServiceError(err))
+		return
+	}
+	ctx = tool.WithModule(ctx, modname)
 
 	scr := &Script{}
-	err := json.Unmarshal(r.Data(), scr)
+	err = json.Unmarshal(r.Data(), scr)
 	if err != nil {
 		span.SetError(ErrValidateScript.Wrap(err))
 		r.Error(ErrValidateScript.ServiceError(err))
@@ -65,6 +80,13 @@ func runHandler(nc *nats.Conn, r micro.Request) {
 		return
 	}
 
+	err = scr.ValidateInput()
+	if err != nil {
+		span.SetError(ErrInvalidInput.Wrap(err))
+		r.Error(ErrInvalidInput.ServiceError(err))
+		return
+	}
+
 	span.SetRequest(scr)
 	timeoutSeconds := scr.Config.TimeoutSeconds
 	if timeoutSeconds == 0 {
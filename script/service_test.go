@@ -5,11 +5,27 @@ package script
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/jumonmd/gengo/chat"
+	"github.com/jumonmd/gengo/jsonschema"
+	chatsvc "github.com/jumonmd/jumon/chat"
+	"github.com/jumonmd/jumon/internal/config"
 	"github.com/jumonmd/jumon/internal/testutil"
+	"github.com/jumonmd/jumon/internal/tracer"
+	"github.com/jumonmd/jumon/tool"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nats.go/micro"
 )
 
 func TestScriptService(t *testing.T) {
@@ -83,3 +99,881 @@ func TestScriptService(t *testing.T) {
 		})
 	}
 }
+
+func TestRunToolRounds(t *testing.T) {
+	// setup test server
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// setup test chat service: a tool call first, then the final answer
+	toolcall := chat.Response{
+		Model:        "gpt-4o-mini",
+		FinishReason: "tool_use",
+		Messages:     []chat.Message{chat.NewToolCallMessage("weather", "call-1", `{"city":"tokyo"}`)},
+	}
+	answer := chat.Response{
+		Model:        "gpt-4o-mini",
+		FinishReason: "stop",
+		Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, "sunny")},
+	}
+	resps := [][]byte{}
+	for _, r := range []chat.Response{toolcall, answer} {
+		data, err := json.Marshal(r)
+		if err != nil {
+			t.Fatalf("failed to marshal response: %v", err)
+		}
+		resps = append(resps, data)
+	}
+	chtsvc, err := testutil.NewMicroServerSequence(nc, "chat.generate", resps)
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	// setup test tool service
+	toolsvc, err := testutil.NewMicroServer(nc, "tool.run", []byte(`"sunny"`))
+	if err != nil {
+		t.Fatalf("failed to create test tool service: %v", err)
+	}
+	defer toolsvc.Stop()
+
+	scr := &Script{
+		Name:    "weather",
+		Model:   "gpt-4o-mini",
+		Content: "1. Check the weather in tokyo",
+		Tools:   []tool.Tool{{Name: "weather", Type: "nats"}},
+	}
+
+	resp, err := Run(t.Context(), nc, scr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(resp) != `"sunny"` {
+		t.Errorf("expected %q, got %q", `"sunny"`, resp)
+	}
+
+	// the limit is reached when the model keeps calling tools
+	rounds := 1
+	scr.Config.MaxToolRounds = &rounds
+	chtsvc2, err := testutil.NewMicroServer(nc, "chat.generate", resps[0])
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	chtsvc.Stop()
+
+	_, err = Run(t.Context(), nc, scr)
+	if err == nil {
+		t.Errorf("expected max tool rounds error, got nil")
+	}
+	chtsvc2.Stop()
+
+	// 0 turns off the tools, the model is requested once without them.
+	rounds = 0
+	var requests []*chat.Request
+	chtsvc3, err := micro.AddService(nc, micro.Config{
+		Name:    "test-chat",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "chat.generate",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				req := &chat.Request{}
+				if err := json.Unmarshal(r.Data(), req); err != nil {
+					r.Error("400", err.Error(), nil)
+					return
+				}
+				requests = append(requests, req)
+				r.Respond(resps[1])
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	defer chtsvc3.Stop()
+
+	if _, err := Run(t.Context(), nc, scr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(requests) != 1 || len(requests[0].Tools) != 0 {
+		t.Errorf("expected a request without tools, got %d requests", len(requests))
+	}
+}
+
+func TestRunToolCalls(t *testing.T) {
+	// setup test server
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	toolsvc, err := testutil.NewMicroServer(nc, "tool.run", []byte(`"ok"`))
+	if err != nil {
+		t.Fatalf("failed to create test tool service: %v", err)
+	}
+	defer toolsvc.Stop()
+
+	calls := []chat.Message{
+		chat.NewToolCallMessage("first", "call-1", `{}`),
+		chat.NewToolCallMessage("missing", "call-2", `{}`),
+		chat.NewToolCallMessage("second", "call-3", `{}`),
+	}
+	tools := []tool.Tool{
+		{Name: "first", Type: "nats"},
+		{Name: "second", Type: "nats"},
+	}
+
+	resps := runToolCalls(t.Context(), nc, calls, tools, 2)
+	if len(resps) != len(calls) {
+		t.Fatalf("expected %d responses, got %d", len(calls), len(resps))
+	}
+
+	for i, resp := range resps {
+		if resp.ToolResponse.ID != calls[i].ToolCall.ID {
+			t.Errorf("response %d: expected call id %q, got %q", i, calls[i].ToolCall.ID, resp.ToolResponse.ID)
+		}
+	}
+	if resps[0].ToolResponse.Result != `"ok"` {
+		t.Errorf("expected %q, got %q", `"ok"`, resps[0].ToolResponse.Result)
+	}
+	if !bytes.Contains([]byte(resps[1].ToolResponse.Result), []byte("tool not found")) {
+		t.Errorf("expected tool not found error, got %q", resps[1].ToolResponse.Result)
+	}
+
+	// the calls are not run after the context is done.
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	resps = runToolCalls(ctx, nc, calls, tools, 1)
+	for i, resp := range resps {
+		if resp.ToolResponse == nil || !strings.Contains(resp.ToolResponse.Result, "context canceled") {
+			t.Errorf("response %d: expected context canceled error, got %+v", i, resp)
+		}
+	}
+}
+
+func TestRunOutputSchema(t *testing.T) {
+	// setup test server
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// setup test chat service: an invalid output first, then the repaired output
+	resps := [][]byte{}
+	for _, content := range []string{"hello", `{"greeting":"hello"}`} {
+		data, err := json.Marshal(chat.Response{
+			Model:        "gpt-4o-mini",
+			FinishReason: "stop",
+			Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, content)},
+		})
+		if err != nil {
+			t.Fatalf("failed to marshal response: %v", err)
+		}
+		resps = append(resps, data)
+	}
+	chtsvc, err := testutil.NewMicroServerSequence(nc, "chat.generate", resps)
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	scr := &Script{
+		Name:    "greeting",
+		Model:   "gpt-4o-mini",
+		Content: "1. Say hello",
+		OutputSchema: jsonschema.Schema{
+			"type":       "object",
+			"properties": map[string]any{"greeting": map[string]any{"type": "string"}},
+			"required":   []any{"greeting"},
+		},
+	}
+
+	resp, err := Run(t.Context(), nc, scr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(resp) != `{"greeting":"hello"}` {
+		t.Errorf("expected %q, got %q", `{"greeting":"hello"}`, resp)
+	}
+
+	// the output never matches the schema
+	outputSchema := scr.OutputSchema
+	scr.OutputSchema = jsonschema.Schema{"type": "integer"}
+	_, err = Run(t.Context(), nc, scr)
+	if err == nil {
+		t.Errorf("expected output validation error, got nil")
+	}
+	chtsvc.Stop()
+
+	// 0 turns off the repairs, so the invalid output is not repaired.
+	chtsvc2, err := testutil.NewMicroServerSequence(nc, "chat.generate", resps)
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	defer chtsvc2.Stop()
+	repairs := 0
+	scr.OutputSchema = outputSchema
+	scr.Config.MaxRepairs = &repairs
+	if _, err := Run(t.Context(), nc, scr); !errors.Is(err, ErrValidateOutput) {
+		t.Errorf("expected output validation error, got %v", err)
+	}
+}
+
+func TestRunCheckpoint(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	kv, err := js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: CheckpointBucket})
+	if err != nil {
+		t.Fatalf("failed to create kv: %v", err)
+	}
+
+	response := func(content string) []byte {
+		data, err := json.Marshal(chat.Response{
+			Model:        "gpt-4o-mini",
+			FinishReason: "stop",
+			Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, content)},
+		})
+		if err != nil {
+			t.Fatalf("failed to marshal response: %v", err)
+		}
+		return data
+	}
+
+	scr := &Script{
+		Name:    "two-steps",
+		Model:   "gpt-4o-mini",
+		Content: "1. Say first\n2. Say second",
+	}
+	ctx := WithCheckpoint(t.Context(), "run-1")
+
+	// the second step fails with a broken response.
+	chtsvc, err := testutil.NewMicroServerSequence(nc, "chat.generate", [][]byte{response("first"), []byte("broken")})
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	if _, err := Run(ctx, nc, scr); err == nil {
+		t.Fatal("expected run step error, got nil")
+	}
+	chtsvc.Stop()
+
+	cp, err := getCheckpoint(t.Context(), nc, "run-1")
+	if err != nil || cp == nil {
+		t.Fatalf("checkpoint not found: %v", err)
+	}
+	if cp.Steps != 1 || len(cp.Messages) != 2 {
+		t.Errorf("unexpected checkpoint: steps %d, messages %d", cp.Steps, len(cp.Messages))
+	}
+
+	// resume runs the second step only.
+	chtsvc, err = testutil.NewMicroServerSequence(nc, "chat.generate", [][]byte{response("second"), response("again")})
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	resp, err := Run(ctx, nc, scr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(resp) != `"second"` {
+		t.Errorf("expected %q, got %q", `"second"`, resp)
+	}
+	if _, err := kv.Get(t.Context(), "run-1"); !errors.Is(err, jetstream.ErrKeyNotFound) {
+		t.Errorf("checkpoint must be deleted after the run succeeded: %v", err)
+	}
+}
+
+func TestRunCondition(t *testing.T) {
+	// setup test server
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	response := func(content string) []byte {
+		data, err := json.Marshal(chat.Response{
+			Model:        "gpt-4o-mini",
+			FinishReason: "stop",
+			Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, content)},
+		})
+		if err != nil {
+			t.Fatalf("failed to marshal response: %v", err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		resps   []string
+		want    string
+	}{
+		{
+			name:    "predicate true",
+			content: "1. Classify\n2. If: `$.angry`\n   - Apologize\n3. Else:\n   - Thank\n",
+			resps:   []string{`{"angry":true}`, "sorry", "thanks"},
+			want:    `"sorry"`,
+		},
+		{
+			name:    "predicate false",
+			content: "1. Classify\n2. If: `$.angry`\n   - Apologize\n3. Else:\n   - Thank\n",
+			resps:   []string{`{"angry":false}`, "thanks", "sorry"},
+			want:    `"thanks"`,
+		},
+		{
+			name:    "model decision",
+			content: "1. Classify\n2. If: the customer is angry\n   - Apologize\n3. Else:\n   - Thank\n",
+			resps:   []string{"calm", `{"result":false,"reason":"calm"}`, "thanks", "sorry"},
+			want:    `"thanks"`,
+		},
+		{
+			name:    "false without else",
+			content: "1. Classify\n2. If: `$.angry`\n   - Apologize\n",
+			resps:   []string{`{"angry":false}`, "sorry"},
+			want:    `{"angry":false}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resps := [][]byte{}
+			for _, r := range tt.resps {
+				resps = append(resps, response(r))
+			}
+			chtsvc, err := testutil.NewMicroServerSequence(nc, "chat.generate", resps)
+			if err != nil {
+				t.Fatalf("failed to create test chat service: %v", err)
+			}
+			defer chtsvc.Stop()
+
+			scr := &Script{Name: "condition", Model: "gpt-4o-mini", Content: tt.content}
+			resp, err := Run(t.Context(), nc, scr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(resp) != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, resp)
+			}
+		})
+	}
+}
+
+func TestRunForEach(t *testing.T) {
+	// setup test server
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// mock chat service: it lists the tickets first, then echoes the ticket of the iteration.
+	chtsvc, err := micro.AddService(nc, micro.Config{
+		Name:    "test-chat",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "chat.generate",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				req := &chat.Request{}
+				if err := json.Unmarshal(r.Data(), req); err != nil {
+					r.Error("400", err.Error(), nil)
+					return
+				}
+				content := `{"tickets":[1,2,3]}`
+				for _, msg := range req.Messages {
+					text := msg.ContentString()
+					if strings.HasPrefix(text, "Run the following steps for the ticket") {
+						lines := strings.Split(text, "\n")
+						content = fmt.Sprintf(`{"closed":%s}`, lines[len(lines)-1])
+					}
+				}
+				r.RespondJSON(chat.Response{
+					Model:        "gpt-4o-mini",
+					FinishReason: "stop",
+					Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, content)},
+				})
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	for _, content := range []string{
+		"1. List the tickets\n2. For each ticket in $.tickets:\n   - Close the ticket\n",
+		"1. List the tickets\n2. For each ticket in $.tickets (parallel 2):\n   - Close the ticket\n",
+	} {
+		scr := &Script{Name: "foreach", Model: "gpt-4o-mini", Content: content}
+		resp, err := Run(t.Context(), nc, scr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := `[{"closed":1},{"closed":2},{"closed":3}]`
+		if string(resp) != want {
+			t.Errorf("expected %s, got %s", want, resp)
+		}
+	}
+
+	// the path is not an array
+	scr := &Script{Name: "foreach", Model: "gpt-4o-mini", Content: "1. List the tickets\n2. For each ticket in $.none:\n   - Close the ticket\n"}
+	if _, err := Run(t.Context(), nc, scr); err == nil {
+		t.Error("expected for each path error, got nil")
+	}
+}
+
+func TestRunForEachItemVar(t *testing.T) {
+	// setup test server
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// mock chat service: it lists the tickets first, then echoes the step of the iteration.
+	chtsvc, err := micro.AddService(nc, micro.Config{
+		Name:    "test-chat",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "chat.generate",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				req := &chat.Request{}
+				if err := json.Unmarshal(r.Data(), req); err != nil {
+					r.Error("400", err.Error(), nil)
+					return
+				}
+				content := `{"tickets":[{"title":"login"},{"title":"logout"}]}`
+				if len(req.Messages) > 2 {
+					last := strings.TrimSpace(req.Messages[len(req.Messages)-1].ContentString())
+					content = strconv.Quote(last)
+				}
+				r.RespondJSON(chat.Response{
+					Model:        "gpt-4o-mini",
+					FinishReason: "stop",
+					Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, content)},
+				})
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	scr := &Script{Name: "foreach", Model: "gpt-4o-mini", Content: "1. List the tickets\n2. For each ticket in $.tickets:\n   - Close {{ticket.title}}\n"}
+	resp, err := Run(t.Context(), nc, scr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `["- Close login","- Close logout"]`
+	if string(resp) != want {
+		t.Errorf("expected %s, got %s", want, resp)
+	}
+}
+
+func TestRunForEachCancel(t *testing.T) {
+	// setup test server
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// mock chat service: the ticket 1 fails, and the others wait until the test ends.
+	release := make(chan struct{})
+	defer close(release)
+	var (
+		mu      sync.Mutex
+		tickets []string
+	)
+	chtsvc, err := micro.AddService(nc, micro.Config{
+		Name:    "test-chat",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "chat.generate",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				req := &chat.Request{}
+				if err := json.Unmarshal(r.Data(), req); err != nil {
+					r.Error("400", err.Error(), nil)
+					return
+				}
+				text := ""
+				for _, msg := range req.Messages {
+					if strings.HasPrefix(msg.ContentString(), "Run the following steps for the ticket") {
+						text = msg.ContentString()
+					}
+				}
+				if text == "" {
+					r.RespondJSON(chat.Response{
+						Model:        "gpt-4o-mini",
+						FinishReason: "stop",
+						Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, `{"tickets":[1,2,3]}`)},
+					})
+					return
+				}
+				lines := strings.Split(text, "\n")
+				ticket := lines[len(lines)-1]
+				mu.Lock()
+				tickets = append(tickets, ticket)
+				mu.Unlock()
+				if ticket == "1" {
+					r.Error("500", "ticket 1 failed", nil)
+					return
+				}
+				go func() {
+					<-release
+					r.Error("500", "released", nil)
+				}()
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	scr := &Script{Name: "foreach", Model: "gpt-4o-mini", Content: "1. List the tickets\n2. For each ticket in $.tickets (parallel 2):\n   - Close the ticket\n"}
+	done := make(chan error, 1)
+	go func() {
+		_, err := Run(t.Context(), nc, scr)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "iteration 0") {
+			t.Errorf("expected iteration 0 error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the other iterations are not cancelled")
+	}
+
+	// the ticket 3 is not started after the ticket 1 failed.
+	mu.Lock()
+	defer mu.Unlock()
+	if slices.Contains(tickets, "3") {
+		t.Errorf("ticket 3 is started after the failure: %v", tickets)
+	}
+}
+
+func TestRunStepModel(t *testing.T) {
+	// setup test server
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// mock chat service: it records the model and the prompt of each request.
+	var (
+		mu     sync.Mutex
+		models []string
+	)
+	chtsvc, err := micro.AddService(nc, micro.Config{
+		Name:    "test-chat",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "chat.generate",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				req := &chat.Request{}
+				if err := json.Unmarshal(r.Data(), req); err != nil {
+					r.Error("400", err.Error(), nil)
+					return
+				}
+				prompt := req.Messages[len(req.Messages)-1].ContentString()
+				if strings.Contains(prompt, "[model:") {
+					r.Error("400", "annotation is not stripped", nil)
+					return
+				}
+				mu.Lock()
+				models = append(models, fmt.Sprintf("%s %v", req.Model, req.Config.Temperature))
+				mu.Unlock()
+				r.RespondJSON(chat.Response{
+					Model:        req.Model,
+					FinishReason: "stop",
+					Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, `"ok"`)},
+				})
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	scr := &Script{
+		Name:        "model",
+		Model:       "gpt-4o-mini",
+		ModelConfig: &chat.ModelConfig{Temperature: 0.7},
+		Content:     "1. [model: claude-3-haiku, temperature: 0.2] Summarize the input\n2. Write the answer\n",
+	}
+	if _, err := Run(t.Context(), nc, scr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"claude-3-haiku 0.2", "gpt-4o-mini 0.7"}
+	if diff := cmp.Diff(want, models); diff != "" {
+		t.Errorf("models mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunTemplate(t *testing.T) {
+	// setup test server
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// mock chat service which echoes the last message.
+	chtsvc, err := micro.AddService(nc, micro.Config{
+		Name:    "test-chat",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "chat.generate",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				req := &chat.Request{}
+				if err := json.Unmarshal(r.Data(), req); err != nil {
+					r.Error("400", err.Error(), nil)
+					return
+				}
+				last := req.Messages[len(req.Messages)-1].ContentString()
+				r.RespondJSON(chat.Response{
+					Model:        "gpt-4o-mini",
+					FinishReason: "stop",
+					Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, strings.TrimSpace(last))},
+				})
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	scr := &Script{
+		Name:    "template",
+		Model:   "gpt-4o-mini",
+		Content: "1. Say {{input.name}}\n2. Repeat {{steps.1.output}}\n",
+	}
+	scr.SetInput([]byte(`{"name":"jumon"}`))
+
+	resp, err := Run(t.Context(), nc, scr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `"2. Repeat 1. Say jumon"`; string(resp) != want {
+		t.Errorf("expected %s, got %s", want, resp)
+	}
+}
+
+func TestRunFiles(t *testing.T) {
+	// setup test server
+	nc, js, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// test llm: it answers with the types of the content parts of the first message sent to the provider.
+	testllm := testutil.NewMockOpenAIServerFunc(func(req testutil.ChatCompletionRequest) string {
+		parts, ok := req.Messages[0].Content.([]any)
+		if !ok {
+			return "text only"
+		}
+		types := []string{}
+		for _, p := range parts {
+			part, _ := p.(map[string]any)
+			types = append(types, fmt.Sprint(part["type"]))
+		}
+		return strings.Join(types, ",")
+	})
+	defer testllm.Close()
+
+	// the chat service sends the request to the test llm by the base URL in the config.
+	if _, err := js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: "config"}); err != nil {
+		t.Fatalf("failed to create config store: %v", err)
+	}
+	if err := config.Set(t.Context(), nc, config.OpenAIBaseURL, testllm.URL); err != nil {
+		t.Fatalf("failed to set config: %v", err)
+	}
+	chtsvc, err := chatsvc.NewService(nc)
+	if err != nil {
+		t.Fatalf("failed to create chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	scr := &Script{Name: "files", Model: "gpt-4o-mini", Content: "1. Describe the image photo.png"}
+	scr.AddFile("photo.png", []byte("\x89PNG\r\n\x1a\n"))
+
+	resp, err := Run(t.Context(), nc, scr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `"text,image_url"`; string(resp) != want {
+		t.Errorf("expected %s, got %s", want, resp)
+	}
+
+	scr = &Script{Name: "files", Model: "gpt-4o-mini", Content: "1. Summarize the receipt.pdf"}
+	scr.AddFile("receipt.pdf", []byte("%PDF-1.4\n"))
+	if _, err := Run(t.Context(), nc, scr); err == nil || !strings.Contains(err.Error(), "application/pdf") {
+		t.Errorf("expected unsupported file error of the openai model, got %v", err)
+	}
+}
+
+func TestRunHistoryStream(t *testing.T) {
+	// setup test server
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// mock chat service: it records the number of the messages of each request,
+	// and streams the number as a delta to the stream-to subject if any.
+	var (
+		mu       sync.Mutex
+		requests []int
+	)
+	chtsvc, err := micro.AddService(nc, micro.Config{
+		Name:    "test-chat",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "chat.generate",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				req := &chat.Request{}
+				if err := json.Unmarshal(r.Data(), req); err != nil {
+					r.Error("400", err.Error(), nil)
+					return
+				}
+				mu.Lock()
+				requests = append(requests, len(req.Messages))
+				mu.Unlock()
+				if streamTo := r.Headers().Get("stream-to"); streamTo != "" {
+					delta := chat.StreamResponse{Type: "text", Content: strconv.Itoa(len(req.Messages))}
+					_ = nc.Publish(streamTo, delta.JSON())
+				}
+				r.RespondJSON(chat.Response{
+					Model:        req.Model,
+					FinishReason: "stop",
+					Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, `"ok"`)},
+				})
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	scr := &Script{
+		Name:    "history",
+		Model:   "gpt-4o-mini",
+		Content: "1. Summarize the conversation\n2. Check the summary\n3. Write the answer\n",
+	}
+	history := []chat.Message{
+		chat.NewTextMessage(chat.MessageRoleHuman, "hi"),
+		chat.NewTextMessage(chat.MessageRoleAI, "hello"),
+	}
+
+	tests := []struct {
+		name        string
+		final       bool
+		wantDeltas  []string
+		wantHistory []int
+	}{
+		// jumon run streams every step.
+		{"every step", false, []string{"3", "5", "7"}, []int{3, 5, 7}},
+		// the chat completions stream only the final output.
+		{"final step", true, []string{"7"}, []int{3, 5, 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = nil
+			sub, err := nc.SubscribeSync("stream.test")
+			if err != nil {
+				t.Fatalf("failed to subscribe stream: %v", err)
+			}
+			defer sub.Unsubscribe()
+
+			ctx := tracer.WithStreamTo(WithHistory(t.Context(), history), "stream.test")
+			if tt.final {
+				ctx = WithFinalStream(ctx)
+			}
+			if _, err := Run(ctx, nc, scr); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			deltas := []string{}
+			for {
+				msg, err := sub.NextMsg(100 * time.Millisecond)
+				if err != nil {
+					break
+				}
+				delta := chat.StreamResponse{}
+				if err := json.Unmarshal(msg.Data, &delta); err != nil {
+					t.Fatalf("failed to unmarshal delta: %v", err)
+				}
+				deltas = append(deltas, delta.Content)
+			}
+			if diff := cmp.Diff(tt.wantDeltas, deltas); diff != "" {
+				t.Errorf("deltas mismatch (-want +got):\n%s", diff)
+			}
+			// the steps continue the conversation.
+			if diff := cmp.Diff(tt.wantHistory, requests); diff != "" {
+				t.Errorf("requests mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunMaxCheckRetries(t *testing.T) {
+	// setup test server
+	nc, _, _, cleanup, err := testutil.NewNATSServer()
+	if err != nil {
+		t.Fatalf("failed to start NATS server: %v", err)
+	}
+	defer cleanup()
+
+	// mock chat service: it records the max-check-retries header of each request.
+	var retries []string
+	chtsvc, err := micro.AddService(nc, micro.Config{
+		Name:    "test-chat",
+		Version: "0.1.0",
+		Endpoint: &micro.EndpointConfig{
+			Subject: "chat.generate",
+			Handler: micro.HandlerFunc(func(r micro.Request) {
+				retries = append(retries, r.Headers().Get("max-check-retries"))
+				r.RespondJSON(chat.Response{
+					Model:        "gpt-4o-mini",
+					FinishReason: "stop",
+					Messages:     []chat.Message{chat.NewTextMessage(chat.MessageRoleAI, `"ok"`)},
+				})
+			}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create test chat service: %v", err)
+	}
+	defer chtsvc.Stop()
+
+	scr := &Script{Name: "checks", Model: "gpt-4o-mini", Content: "1. Say ok\n   - [ ] says ok\n"}
+	if _, err := Run(t.Context(), nc, scr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 0 turns off the regenerations.
+	scr.Config.MaxCheckRetries = new(int)
+	if _, err := Run(t.Context(), nc, scr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"2", "0"}, retries); diff != "" {
+		t.Errorf("max-check-retries mismatch (-want +got):\n%s", diff)
+	}
+}
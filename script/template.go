@@ -0,0 +1,315 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jumonmd/gengo/jsonschema"
+	chatsvc "github.com/jumonmd/jumon/chat"
+	"github.com/jumonmd/jumon/internal/dataurl"
+)
+
+// templateVar matches the template variables in the script content.
+// e.g. "{{input.name}}", "{{steps.2.output}}" or "{{env.API_URL}}".
+var templateVar = regexp.MustCompile(`\{\{\s*([A-Za-z_][\w.\-]*)\s*\}\}`)
+
+// envPrefix is the prefix of the environment variables which the scripts can read,
+// so that the other environment of the server is not sent to the models.
+// e.g. "{{env.API_URL}}" is the value of JUMON_VAR_API_URL.
+const envPrefix = "JUMON_VAR_"
+
+// vars is the values of the template variables in a script run.
+type vars struct {
+	// input is the parsed JSON input, or the text input as a string.
+	input    any
+	hasInput bool
+	// steps is the outputs of the completed top-level steps by the step number starting from 1.
+	steps map[int]json.RawMessage
+	// items is the current elements of the enclosing for each steps by the item name.
+	// A nil item is not bound yet, and its variables are kept as is. e.g. in the for each step itself.
+	items map[string]json.RawMessage
+}
+
+// varScopes is the scopes of the variables which can not be used as the for each item names.
+var varScopes = []string{"input", "steps", "env", "secret"}
+
+// withItem returns the copy of the variables with the for each item bound to the name.
+// The step outputs are shared with the copy.
+func (v *vars) withItem(name string, item json.RawMessage) *vars {
+	c := *v
+	c.items = maps.Clone(v.items)
+	if c.items == nil {
+		c.items = map[string]json.RawMessage{}
+	}
+	c.items[name] = item
+	return &c
+}
+
+// newVars returns the variables with the input of the data URL.
+// The input is parsed as JSON when the MIME type is JSON or text, and otherwise is not available.
+func newVars(inputURL string) (*vars, error) {
+	v := &vars{steps: map[int]json.RawMessage{}}
+	if inputURL == "" {
+		return v, nil
+	}
+	data, mimetype, err := dataurl.Decode(inputURL)
+	if err != nil {
+		return nil, fmt.Errorf("decode input: %w", err)
+	}
+	if !isTextMIME(mimetype) {
+		return v, nil
+	}
+	v.hasInput = true
+	if err := json.Unmarshal(data, &v.input); err != nil {
+		v.input = string(data)
+	}
+	return v, nil
+}
+
+func isTextMIME(mimetype string) bool {
+	return strings.HasPrefix(mimetype, "text/") || strings.HasPrefix(mimetype, "application/json")
+}
+
+// render replaces the template variables in the text with their values.
+func (v *vars) render(text string) (string, error) {
+	var rerr error
+	out := templateVar.ReplaceAllStringFunc(text, func(m string) string {
+		value, err := v.resolve(templateVar.FindStringSubmatch(m)[1])
+		if err != nil && rerr == nil {
+			rerr = err
+		}
+		return value
+	})
+	return out, rerr
+}
+
+// resolve returns the value of the variable name as a text.
+// A string value is returned as is, and other values are returned as JSON.
+func (v *vars) resolve(name string) (string, error) {
+	scope, path, _ := strings.Cut(name, ".")
+	switch scope {
+	case "input":
+		if !v.hasInput {
+			return "", fmt.Errorf("unresolved variable %s: no text input", name)
+		}
+		value, ok := lookupPath(v.input, varPath(path))
+		if !ok {
+			return "", fmt.Errorf("unresolved variable %s: not found in input", name)
+		}
+		return varText(value)
+	case "steps":
+		n, err := stepVar(name, path)
+		if err != nil {
+			return "", err
+		}
+		output, ok := v.steps[n]
+		if !ok {
+			return "", fmt.Errorf("unresolved variable %s: step %d has no output", name, n)
+		}
+		var s string
+		if err := json.Unmarshal(output, &s); err == nil {
+			return s, nil
+		}
+		return string(output), nil
+	case "env":
+		return lookupEnv(name, path)
+	case "secret":
+		return "", errSecretVar(name)
+	}
+	if item, ok := v.items[scope]; ok {
+		if item == nil {
+			return "{{" + name + "}}", nil
+		}
+		var value any
+		if err := json.Unmarshal(item, &value); err != nil {
+			return "", fmt.Errorf("unmarshal variable %s: %w", name, err)
+		}
+		value, ok = lookupPath(value, varPath(path))
+		if !ok {
+			return "", fmt.Errorf("unresolved variable %s: not found in %s", name, scope)
+		}
+		return varText(value)
+	}
+	return "", fmt.Errorf("unknown variable %s", name)
+}
+
+// lookupEnv returns the value of the environment variable with the envPrefix.
+// The provider API keys are never returned even if they are set with the prefix.
+func lookupEnv(name, path string) (string, error) {
+	if path == "" {
+		return "", fmt.Errorf("unresolved variable %s: environment variable name is required", name)
+	}
+	if chatsvc.IsProviderKeyEnv(path) {
+		return "", fmt.Errorf("variable %s is not allowed: use the secret store for the API keys", name)
+	}
+	value, ok := os.LookupEnv(envPrefix + path)
+	if !ok {
+		return "", fmt.Errorf("unresolved variable %s: environment variable %s%s is not set", name, envPrefix, path)
+	}
+	return value, nil
+}
+
+// varPath converts the dotted variable path to the JSON path. e.g. "items.0.name" -> "$.items[0].name".
+func varPath(path string) string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	if path == "" {
+		return sb.String()
+	}
+	for _, seg := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(seg); err == nil {
+			sb.WriteString("[" + seg + "]")
+			continue
+		}
+		sb.WriteString("." + seg)
+	}
+	return sb.String()
+}
+
+func varText(value any) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("marshal variable: %w", err)
+	}
+	return string(data), nil
+}
+
+// stepVar returns the step number of the "steps.N.output" variable.
+func stepVar(name, path string) (int, error) {
+	num, field, _ := strings.Cut(path, ".")
+	n, err := strconv.Atoi(num)
+	if err != nil || n < 1 || field != "output" {
+		return 0, fmt.Errorf("invalid variable %s: expected steps.<number>.output", name)
+	}
+	return n, nil
+}
+
+// errSecretVar is the error of a secret reference in the script content.
+// The secrets are resolved only in the tool arguments not to send them to the model.
+func errSecretVar(name string) error {
+	return fmt.Errorf("invalid variable %s: secrets can be referred only in the tool arguments", name)
+}
+
+// usesInputVars reports whether the content refers to the input variables.
+func usesInputVars(content string) bool {
+	for _, m := range templateVar.FindAllStringSubmatch(content, -1) {
+		if scope, _, _ := strings.Cut(m[1], "."); scope == "input" {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateVars checks that all the template variables in the script can be resolved.
+// The input variables must be in the input schema if it is defined, the step outputs must be of
+// the previous steps, and the environment variables must be set with the JUMON_VAR_ prefix.
+func (s *Script) ValidateVars() error {
+	steps, preface, err := s.Steps()
+	if err != nil {
+		return fmt.Errorf("parse steps: %w", err)
+	}
+	if err := s.validateVars(preface, 0, nil); err != nil {
+		return err
+	}
+	for i, step := range steps {
+		if err := s.validateStepVars(step, i, nil); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// validateStepVars checks the variables of the step and its children.
+// The children of a for each step can also refer to its item.
+func (s *Script) validateStepVars(step *Step, completed int, items []string) error {
+	if err := s.validateVars(step.Content, completed, items); err != nil {
+		return err
+	}
+	if step.Type == StepTypeForEach {
+		if fe, err := step.ForEach(); err == nil {
+			items = append(slices.Clone(items), fe.Item)
+		}
+	}
+	for _, child := range step.Children {
+		if err := s.validateStepVars(child, completed, items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateVars checks the variables of the text which can refer to the outputs of the completed steps
+// and the items of the enclosing for each steps.
+func (s *Script) validateVars(text string, completed int, items []string) error {
+	for _, m := range templateVar.FindAllStringSubmatch(text, -1) {
+		name := m[1]
+		scope, path, _ := strings.Cut(name, ".")
+		switch scope {
+		case "input":
+			if !schemaHasPath(s.InputSchema, path) {
+				return fmt.Errorf("unresolved variable %s: not found in input schema", name)
+			}
+		case "steps":
+			n, err := stepVar(name, path)
+			if err != nil {
+				return err
+			}
+			if n > completed {
+				return fmt.Errorf("unresolved variable %s: step %d does not run before", name, n)
+			}
+		case "env":
+			if _, err := lookupEnv(name, path); err != nil {
+				return err
+			}
+		case "secret":
+			return errSecretVar(name)
+		default:
+			if !slices.Contains(items, scope) {
+				return fmt.Errorf("unknown variable %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+// schemaHasPath reports whether the dotted path may exist in the schema.
+// A path under a schema without properties, e.g. no schema, is not checked.
+func schemaHasPath(schema jsonschema.Schema, path string) bool {
+	var node any = map[string]any(schema)
+	if path == "" {
+		return true
+	}
+	for _, seg := range strings.Split(path, ".") {
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return true
+		}
+		if _, err := strconv.Atoi(seg); err == nil {
+			if items, ok := obj["items"]; ok {
+				node = items
+				continue
+			}
+			return true
+		}
+		props, ok := obj["properties"].(map[string]any)
+		if !ok {
+			return true
+		}
+		if node, ok = props[seg]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2025 Masa Cento
+// SPDX-License-Identifier: MPL-2.0
+
+package script
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jumonmd/gengo/jsonschema"
+	"github.com/jumonmd/jumon/internal/dataurl"
+)
+
+func TestVarsRender(t *testing.T) {
+	t.Setenv("JUMON_VAR_TEST_URL", "https://example.com")
+	t.Setenv("JUMON_TEST_PLAIN", "plain")
+	t.Setenv("JUMON_VAR_OPENAI_API_KEY", "sk-test")
+
+	v, err := newVars(dataurl.Encode("application/json", []byte(`{"name":"jumon","tags":["a","b"],"user":{"age":3}}`)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v.steps[1] = json.RawMessage(`"first"`)
+	v.steps[2] = json.RawMessage(`{"ok":true}`)
+
+	tests := []struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{text: "Hello {{input.name}}!", want: "Hello jumon!"},
+		{text: "{{ input.tags.1 }} {{input.user.age}}", want: "b 3"},
+		{text: "{{input.user}}", want: `{"age":3}`},
+		{text: "{{steps.1.output}} {{steps.2.output}}", want: `first {"ok":true}`},
+		{text: "Fetch {{env.TEST_URL}}", want: "Fetch https://example.com"},
+		{text: "no variables {name}", want: "no variables {name}"},
+		{text: "{{input.missing}}", wantErr: true},
+		{text: "{{steps.3.output}}", wantErr: true},
+		{text: "{{steps.1.input}}", wantErr: true},
+		{text: "{{env.JUMON_TEST_NOT_SET}}", wantErr: true},
+		{text: "{{env.JUMON_TEST_PLAIN}}", wantErr: true},
+		{text: "{{env.OPENAI_API_KEY}}", wantErr: true},
+		{text: "{{unknown}}", wantErr: true},
+		{text: "Use {{secret.API_KEY}}", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := v.render(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// the for each item is available in the iteration, and kept as is before it is bound.
+	item := v.withItem("ticket", json.RawMessage(`{"title":"login","tags":["a"]}`))
+	if got, err := item.render("{{ticket.title}} {{ticket.tags}} {{input.name}}"); err != nil || got != `login ["a"] jumon` {
+		t.Errorf("render() = %q, %v, want %q", got, err, `login ["a"] jumon`)
+	}
+	if _, err := item.render("{{ticket.missing}}"); err == nil {
+		t.Error("expected not found in item error, got nil")
+	}
+	if _, err := v.render("{{ticket.title}}"); err == nil {
+		t.Error("expected unknown variable error, got nil")
+	}
+	if got, err := v.withItem("ticket", nil).render("{{ticket.title}}"); err != nil || got != "{{ticket.title}}" {
+		t.Errorf("render() = %q, %v, want %q", got, err, "{{ticket.title}}")
+	}
+
+	// text input is available as a string.
+	v, err = newVars(dataurl.Encode("text/plain; charset=utf-8", []byte("plain text")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, err := v.render("{{input}}"); err != nil || got != "plain text" {
+		t.Errorf("render() = %q, %v, want %q", got, err, "plain text")
+	}
+
+	// binary input is not available.
+	v, err = newVars(dataurl.Encode("image/png", []byte{0x89, 0x50}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := v.render("{{input}}"); err == nil {
+		t.Error("expected no text input error, got nil")
+	}
+}
+
+func TestScriptValidateVars(t *testing.T) {
+	t.Setenv("JUMON_VAR_TEST_URL", "https://example.com")
+
+	schema := jsonschema.Schema{
+		"type": "object",
+		"properties": map[string]any{
+			"name":  map[string]any{"type": "string"},
+			"items": map[string]any{"type": "array", "items": map[string]any{"type": "object", "properties": map[string]any{"id": map[string]any{"type": "string"}}}},
+		},
+	}
+	tests := []struct {
+		name    string
+		content string
+		schema  jsonschema.Schema
+		wantErr bool
+	}{
+		{name: "valid", content: "Hi {{input.name}}\n\n1. Get {{env.TEST_URL}}\n2. Use {{steps.1.output}} and {{input.items.0.id}}\n", schema: schema},
+		{name: "no input schema", content: "1. Hi {{input.anything}}\n"},
+		{name: "not in input schema", content: "1. Hi {{input.age}}\n", schema: schema, wantErr: true},
+		{name: "not in items schema", content: "1. Hi {{input.items.0.name}}\n", schema: schema, wantErr: true},
+		{name: "later step", content: "1. Use {{steps.2.output}}\n2. Say hello\n", wantErr: true},
+		{name: "step in preface", content: "Use {{steps.1.output}}\n\n1. Say hello\n", wantErr: true},
+		{name: "env not set", content: "1. Get {{env.JUMON_TEST_NOT_SET}}\n", wantErr: true},
+		{name: "env without prefix", content: "1. Get {{env.PATH}}\n", wantErr: true},
+		{name: "unknown", content: "1. Say {{hello}}\n", wantErr: true},
+		{name: "secret", content: "1. Call with {{secret.API_KEY}}\n", wantErr: true},
+		{name: "for each item", content: "1. List\n2. For each ticket in $.tickets:\n   - Close {{ticket.title}}\n"},
+		{name: "for each item outside", content: "1. For each ticket in $.tickets:\n   - Close it\n2. Say {{ticket.title}}\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scr := &Script{Name: "vars", Content: tt.content, InputSchema: tt.schema}
+			err := scr.ValidateVars()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateVars() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
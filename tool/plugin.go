@@ -9,6 +9,7 @@ import (
 	"log/slog"
 
 	"github.com/jumonmd/jumon/internal/dataurl"
+	"github.com/jumonmd/jumon/internal/errors"
 	"github.com/jumonmd/jumon/internal/tracer"
 	"github.com/nats-io/nats.go"
 )
@@ -19,6 +20,11 @@ func runWasmPlugin(ctx context.Context, tl *Tool) ([]byte, error) {
 	if err != nil {
 		return nil, ErrWasmValidate.Wrap(fmt.Errorf("wasm runner create failed: %w", err))
 	}
+	defer func() {
+		if err := wasmRunner.Close(context.WithoutCancel(ctx)); err != nil {
+			slog.Warn("run tool", "status", "close wasm plugin failed", "error", err)
+		}
+	}()
 
 	input, _, err := dataurl.Decode(tl.InputURL)
 	if err != nil {
@@ -60,9 +66,8 @@ func runNatsPlugin(ctx context.Context, nc *nats.Conn, tl *Tool) ([]byte, error)
 	if err != nil {
 		return nil, ErrNatsValidate.Wrap(fmt.Errorf("nats request failed: %w", err))
 	}
-	if errorCode := resp.Header.Get("Nats-Service-Error-Code"); errorCode != "" {
-		errorMessage := resp.Header.Get("Nats-Service-Error")
-		return nil, fmt.Errorf("%s: %s", errorCode, errorMessage)
+	if err := errors.FromHeaders(resp.Header); err != nil {
+		return nil, err
 	}
 
 	slog.Debug("run tool", "nats response", string(resp.Data))
@@ -80,17 +85,20 @@ func runScriptPlugin(ctx context.Context, nc *nats.Conn, tl *Tool) ([]byte, erro
 		return nil, ErrScriptValidate.Wrap(fmt.Errorf("script name is not set"))
 	}
 
+	header, err := requestHeaders(ctx, []byte(script))
+	if err != nil {
+		return nil, ErrRunScript.Wrap(err)
+	}
 	resp, err := nc.RequestMsgWithContext(ctx, &nats.Msg{
 		Subject: "script.run",
 		Data:    []byte(script),
-		Header:  tracer.HeadersFromContext(ctx),
+		Header:  header,
 	})
 	if err != nil {
 		return nil, ErrRunScript.Wrap(fmt.Errorf("script run failed: %w", err))
 	}
-	if errorCode := resp.Header.Get("Nats-Service-Error-Code"); errorCode != "" {
-		errorMessage := resp.Header.Get("Nats-Service-Error")
-		return nil, ErrRunScript.Wrap(fmt.Errorf("script error: %s", errorMessage))
+	if err := errors.FromHeaders(resp.Header); err != nil {
+		return nil, err
 	}
 
 	slog.Debug("run tool", "script output", string(resp.Data))
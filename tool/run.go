@@ -9,11 +9,67 @@ import (
 	"fmt"
 	"log/slog"
 
+	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/jumonmd/jumon/internal/secret"
 	"github.com/jumonmd/jumon/internal/tracer"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
+	"github.com/nats-io/nats.go/micro"
 )
 
+const (
+	// HeaderModule is the header of the tool.run request which has the name of the module running the tool.
+	// The secrets granted to the module are resolved in the tool arguments.
+	HeaderModule = "Jumon-Tool-Module"
+	// HeaderModuleSignature is the header of the signature of the request data made for the module.
+	// The module header without the valid signature is rejected, as any client can send the headers.
+	HeaderModuleSignature = "Jumon-Tool-Module-Signature"
+)
+
+type contextKey string
+
+const contextKeyModule contextKey = "module"
+
+// WithModule returns the context with the name of the module which runs the tools.
+func WithModule(ctx context.Context, modname string) context.Context {
+	return context.WithValue(ctx, contextKeyModule, modname)
+}
+
+// ModuleFromContext returns the name of the module which runs the tools, or an empty string.
+func ModuleFromContext(ctx context.Context) string {
+	modname, _ := ctx.Value(contextKeyModule).(string)
+	return modname
+}
+
+// requestHeaders returns the headers of the tool request of the data with the module of the context.
+func requestHeaders(ctx context.Context, data []byte) (nats.Header, error) {
+	h := tracer.HeadersFromContext(ctx)
+	modname := ModuleFromContext(ctx)
+	if modname == "" {
+		return h, nil
+	}
+	signature, err := secret.SignModule(modname, data)
+	if err != nil {
+		return nil, fmt.Errorf("sign module request: %w", err)
+	}
+	h.Set(HeaderModule, modname)
+	h.Set(HeaderModuleSignature, signature)
+	return h, nil
+}
+
+// RequestModule returns the name of the module of the tool or script request after checking its signature.
+// It returns an empty string if the request has no module.
+func RequestModule(r micro.Request) (string, error) {
+	modname := r.Headers().Get(HeaderModule)
+	if modname == "" {
+		return "", nil
+	}
+	if err := secret.VerifyModule(modname, r.Data(), r.Headers().Get(HeaderModuleSignature)); err != nil {
+		return "", err
+	}
+	return modname, nil
+}
+
 // Run runs a tool using NATS service.
 func Run(ctx context.Context, nc *nats.Conn, tl Tool) (json.RawMessage, error) {
 	slog.Info("run tool", "status", "start", "tool", tl.Name)
@@ -24,17 +80,20 @@ func Run(ctx context.Context, nc *nats.Conn, tl Tool) (json.RawMessage, error) {
 	}
 	slog.Debug("run tool", "tool", tl.Name, "inputsize", len(tl.InputURL))
 
+	header, err := requestHeaders(ctx, data)
+	if err != nil {
+		return nil, err
+	}
 	resp, err := nc.RequestMsgWithContext(ctx, &nats.Msg{
 		Subject: "tool.run",
 		Data:    data,
-		Header:  tracer.HeadersFromContext(ctx),
+		Header:  header,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to request tool: %w", err)
 	}
-	if errorCode := resp.Header.Get("Nats-Service-Error-Code"); errorCode != "" {
-		errorMessage := resp.Header.Get("Nats-Service-Error")
-		return nil, fmt.Errorf("tool error: %s: %s", errorCode, errorMessage)
+	if err := errors.FromHeaders(resp.Header); err != nil {
+		return nil, fmt.Errorf("tool error: %w", err)
 	}
 
 	slog.Info("run tool", "status", "end", "tool", tl.Name, "headers", resp.Header)
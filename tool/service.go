@@ -4,11 +4,14 @@
 package tool
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 
+	"github.com/jumonmd/jumon/internal/config"
 	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/jumonmd/jumon/internal/secret"
 	"github.com/jumonmd/jumon/internal/tracer"
 	"github.com/jumonmd/jumon/tool/std"
 	"github.com/nats-io/nats.go"
@@ -22,6 +25,9 @@ var (
 	ErrWasmValidate    = errors.New(400202, "wasm validation failed")
 	ErrNatsValidate    = errors.New(400203, "nats validation failed")
 	ErrScriptValidate  = errors.New(400204, "script validation failed")
+	ErrInvalidInput    = errors.New(400205, "invalid input")
+	ErrResolveSecret   = errors.New(400206, "resolve secret failed")
+	ErrInvalidModule   = errors.New(400207, "invalid module")
 
 	ErrLoadResources = errors.New(500200, "load resources failed")
 	ErrRunTool       = errors.New(500201, "tool execution failed")
@@ -63,9 +69,19 @@ func runHandler(nc *nats.Conn, obs jetstream.ObjectStore, r micro.Request) {
 
 	ctx, span := tracer.Start(tracer.NewContext(r.Headers()), nc, "tool.run")
 	defer span.End()
+	ctx, stop := span.WithCancel(ctx)
+	defer stop()
+
+	modname, err := RequestModule(r)
+	if err != nil {
+		span.SetError(ErrInvalidModule.Wrap(err))
+		r.Error(ErrInvalidModule.ServiceError(err))
+		return
+	}
+	ctx = WithModule(ctx, modname)
 
 	tl := &Tool{}
-	err := json.Unmarshal(r.Data(), tl)
+	err = json.Unmarshal(r.Data(), tl)
 	if err != nil {
 		span.SetError(ErrToolValidate.Wrap(err))
 		r.Error(ErrToolValidate.ServiceError(err))
@@ -74,6 +90,31 @@ func runHandler(nc *nats.Conn, obs jetstream.ObjectStore, r micro.Request) {
 
 	span.SetRequest(tl)
 
+	err = tl.ValidateInput()
+	if err != nil {
+		span.SetError(ErrInvalidInput.Wrap(err))
+		r.Error(ErrInvalidInput.ServiceError(err))
+		return
+	}
+
+	// the secret references are resolved after the request is traced not to record the values.
+	err = tl.resolveSecrets(ctx, nc)
+	if err != nil {
+		span.SetError(ErrResolveSecret.Wrap(err))
+		r.Error(ErrResolveSecret.ServiceError(err))
+		return
+	}
+
+	timeout, err := config.GetDuration(ctx, nc, config.ToolTimeout, config.ModuleScope(ModuleFromContext(ctx)))
+	if err != nil {
+		slog.Warn("tool.run", "status", "get tool timeout from config failed", "error", err)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	output, err := run(ctx, tl, nc, obs)
 	if err != nil {
 		span.SetError(err)
@@ -85,3 +126,18 @@ func runHandler(nc *nats.Conn, obs jetstream.ObjectStore, r micro.Request) {
 	slog.Info("tool.run", "status", "finished")
 	r.Respond(output, micro.WithHeaders(span.Headers()))
 }
+
+// resolveSecrets replaces the secret references such as "{{secret.GITHUB_TOKEN}}" in the arguments.
+// Only the secrets granted to the module running the tool are resolved, so the direct tool runs
+// such as the gateway /v1/tools/run can not refer any secret.
+func (t *Tool) resolveSecrets(ctx context.Context, nc *nats.Conn) error {
+	if len(t.Arguments) == 0 {
+		return nil
+	}
+	args, err := secret.ResolveValue(ctx, nc, ModuleFromContext(ctx), map[string]any(t.Arguments))
+	if err != nil {
+		return err
+	}
+	t.Arguments = args.(map[string]any)
+	return nil
+}
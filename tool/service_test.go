@@ -4,15 +4,20 @@
 package tool
 
 import (
-	"errors"
+	"encoding/json"
 	"testing"
+	"time"
 
+	"github.com/jumonmd/jumon/internal/errors"
+	"github.com/jumonmd/jumon/internal/secret"
 	"github.com/jumonmd/jumon/internal/testutil"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 )
 
 func TestToolService(t *testing.T) {
 	// setup test server
-	nc, _, obs, cleanup, err := testutil.NewNATSServer()
+	nc, js, obs, cleanup, err := testutil.NewNATSServer()
 	if err != nil {
 		t.Fatalf("failed to start NATS server: %v", err)
 	}
@@ -32,8 +37,18 @@ func TestToolService(t *testing.T) {
 	}
 	defer tsvc.Stop()
 
+	// setup secret store
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if _, err := js.CreateKeyValue(t.Context(), jetstream.KeyValueConfig{Bucket: secret.Bucket}); err != nil {
+		t.Fatalf("failed to create secret store: %v", err)
+	}
+	if err := secret.Set(t.Context(), nc, "TEST_SUBJECT", "test.secret", []string{"test/tools"}); err != nil {
+		t.Fatalf("failed to set secret: %v", err)
+	}
+
 	tests := []struct {
 		name     string
+		module   string
 		tool     Tool
 		expected []byte
 		err      error
@@ -48,6 +63,48 @@ func TestToolService(t *testing.T) {
 			expected: []byte("hello"),
 			err:      nil,
 		},
+		{
+			name:   "nats subject from secret",
+			module: "test/tools",
+			tool: Tool{
+				Name:      "nats-secret-test",
+				Type:      "nats",
+				Arguments: Arguments{"subject": "{{secret.TEST_SUBJECT}}"},
+			},
+			expected: []byte("hello"),
+			err:      nil,
+		},
+		{
+			name:   "secret not granted",
+			module: "test/other",
+			tool: Tool{
+				Name:      "nats-secret-test",
+				Type:      "nats",
+				Arguments: Arguments{"subject": "{{secret.TEST_SUBJECT}}"},
+			},
+			expected: nil,
+			err:      ErrResolveSecret,
+		},
+		{
+			name: "secret without module",
+			tool: Tool{
+				Name:      "nats-secret-test",
+				Type:      "nats",
+				Arguments: Arguments{"subject": "{{secret.TEST_SUBJECT}}"},
+			},
+			expected: nil,
+			err:      ErrResolveSecret,
+		},
+		{
+			name: "unknown secret",
+			tool: Tool{
+				Name:      "nats-secret-test",
+				Type:      "nats",
+				Arguments: Arguments{"subject": "{{secret.UNKNOWN}}"},
+			},
+			expected: nil,
+			err:      ErrResolveSecret,
+		},
 		{
 			name: "wasm error",
 			tool: Tool{
@@ -62,7 +119,7 @@ func TestToolService(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.tool.SetInput([]byte("hello"))
-			resp, err := Run(t.Context(), nc, tt.tool)
+			resp, err := Run(WithModule(t.Context(), tt.module), nc, tt.tool)
 
 			if tt.err != nil {
 				if err == nil || errors.Is(err, tt.err) {
@@ -80,4 +137,23 @@ func TestToolService(t *testing.T) {
 			}
 		})
 	}
+
+	// the module header is rejected without the signature made by the server running the module.
+	data, err := json.Marshal(Tool{Name: "nats-secret-test", Type: "nats", Arguments: Arguments{"subject": "{{secret.TEST_SUBJECT}}"}})
+	if err != nil {
+		t.Fatalf("failed to marshal tool: %v", err)
+	}
+	for _, signature := range []string{"", "forged"} {
+		resp, err := nc.RequestMsg(&nats.Msg{
+			Subject: "tool.run",
+			Data:    data,
+			Header:  nats.Header{HeaderModule: {"test/tools"}, HeaderModuleSignature: {signature}},
+		}, time.Second)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if got := errors.Code(errors.FromHeaders(resp.Header)); got != 400207 {
+			t.Errorf("signature %q error code = %d, want 400207", signature, got)
+		}
+	}
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/jumonmd/gengo/chat"
 	"github.com/jumonmd/gengo/jsonschema"
 	"github.com/jumonmd/jumon/internal/dataurl"
+	"github.com/jumonmd/jumon/internal/validate"
 )
 
 // Tool is a tool definition.
@@ -53,6 +54,18 @@ func (t *Tool) Validate() error {
 	return nil
 }
 
+// ValidateInput validates the input against the input schema.
+func (t *Tool) ValidateInput() error {
+	if len(t.InputSchema) == 0 {
+		return nil
+	}
+	input, _, err := dataurl.Decode(t.InputURL)
+	if err != nil {
+		return fmt.Errorf("decode input: %w", err)
+	}
+	return validate.JSON(t.InputSchema, input)
+}
+
 // SetInput sets the input of the tool.
 // mime is automatically detected.
 func (t *Tool) SetInput(input []byte) {
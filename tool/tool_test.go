@@ -12,6 +12,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/jumonmd/gengo/jsonschema"
 )
 
 func TestNewResource(t *testing.T) {
@@ -77,6 +78,48 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestValidateInput(t *testing.T) {
+	schema := jsonschema.Schema{
+		"type":       "object",
+		"properties": map[string]any{"city": map[string]any{"type": "string"}},
+		"required":   []any{"city"},
+	}
+
+	tests := []struct {
+		name    string
+		tool    Tool
+		input   string
+		wantErr bool
+	}{
+		{
+			name:  "valid input",
+			tool:  Tool{Name: "weather", Type: "nats", InputSchema: schema},
+			input: `{"city":"tokyo"}`,
+		},
+		{
+			name:    "wrong type",
+			tool:    Tool{Name: "weather", Type: "nats", InputSchema: schema},
+			input:   `{"city":1}`,
+			wantErr: true,
+		},
+		{
+			name:  "no schema",
+			tool:  Tool{Name: "echo", Type: "nats"},
+			input: `hello`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.tool.SetInput([]byte(tt.input))
+			err := tt.tool.ValidateInput()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateInput() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestResourceFetch(t *testing.T) {
 	t.Run("successful fetch", func(t *testing.T) {
 		expected := "test resource data"
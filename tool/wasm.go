@@ -5,11 +5,13 @@ package tool
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 
 	extism "github.com/extism/go-sdk"
+	"github.com/tetratelabs/wazero"
 )
 
 type wasmRunner struct {
@@ -18,8 +20,10 @@ type wasmRunner struct {
 }
 
 func newWASMRunner(ctx context.Context, arguments Arguments, resources []*Resource) (*wasmRunner, error) {
+	// the running plugin is closed when the context is done, e.g. the run is cancelled.
 	config := extism.PluginConfig{
-		EnableWasi: true,
+		EnableWasi:    true,
+		RuntimeConfig: wazero.NewRuntimeConfig().WithCloseOnContextDone(true),
 	}
 
 	if len(resources) == 0 {
@@ -33,6 +37,11 @@ func newWASMRunner(ctx context.Context, arguments Arguments, resources []*Resour
 		return nil, fmt.Errorf("name is not a string")
 	}
 
+	pluginConfig, err := wasmConfig(arguments)
+	if err != nil {
+		return nil, err
+	}
+
 	wasmres := resources[0]
 	data, err := io.ReadAll(wasmres.reader)
 	if err != nil {
@@ -48,6 +57,7 @@ func newWASMRunner(ctx context.Context, arguments Arguments, resources []*Resour
 				Hash: wasmres.Hash,
 			},
 		},
+		Config: pluginConfig,
 	}
 
 	plugin, err := extism.NewPlugin(ctx, wasmManifest, config, []extism.HostFunction{})
@@ -58,6 +68,32 @@ func newWASMRunner(ctx context.Context, arguments Arguments, resources []*Resour
 	return &wasmRunner{plugin: plugin, funcname: funcname}, nil
 }
 
+// wasmConfig returns the plugin config of the "config" argument, which the plugin reads with config_get.
+// A string value is passed as is, and other values are passed as JSON.
+func wasmConfig(arguments Arguments) (map[string]string, error) {
+	v, ok := arguments["config"]
+	if !ok {
+		return nil, nil
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("config is not an object")
+	}
+	config := make(map[string]string, len(obj))
+	for k, v := range obj {
+		if s, ok := v.(string); ok {
+			config[k] = s
+			continue
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("marshal config %s: %w", k, err)
+		}
+		config[k] = string(data)
+	}
+	return config, nil
+}
+
 // Run runs the wasm function using extism.
 func (r *wasmRunner) Run(ctx context.Context, input []byte) (output []byte, err error) {
 	exit, out, err := r.plugin.CallWithContext(ctx, r.funcname, input)
@@ -67,3 +103,8 @@ func (r *wasmRunner) Run(ctx context.Context, input []byte) (output []byte, err
 
 	return out, nil
 }
+
+// Close closes the wasm plugin and releases its resources.
+func (r *wasmRunner) Close(ctx context.Context) error {
+	return r.plugin.Close(ctx)
+}
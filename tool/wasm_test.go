@@ -31,3 +31,16 @@ func TestRunWASM(t *testing.T) {
 		t.Fatalf("expected 'yo!', got %q", string(out))
 	}
 }
+
+func TestWASMConfig(t *testing.T) {
+	config, err := wasmConfig(Arguments{"name": "fetch", "config": map[string]any{"token": "abc", "retries": 3.0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config["token"] != "abc" || config["retries"] != "3" {
+		t.Errorf("unexpected config: %v", config)
+	}
+	if _, err := wasmConfig(Arguments{"config": "token=abc"}); err == nil {
+		t.Errorf("expected error for non-object config")
+	}
+}